@@ -0,0 +1,314 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Which notifications are sent with -all-configs (allConfigs.notifications)
+const (
+	allConfigsNotifyBoth    = "both"    // Notifications for each configuration, and a summary
+	allConfigsNotifyEach    = "each"    // Notifications for each configuration only
+	allConfigsNotifySummary = "summary" // Summary notification only
+)
+
+var (
+	// Settings for -all-configs (from the global configuration)
+	allConfigsOrder         []string
+	allConfigsParallel      int
+	allConfigsNotifications string
+)
+
+// Result of running a configuration (for -all-configs)
+type configResult struct {
+	name     string
+	exitCode int
+	duration string
+}
+
+// Status of a configuration, based on the exit code of duplicacy-util
+func (result configResult) status() string {
+	switch result.exitCode {
+	case 0:
+		return statusSuccess
+	case 501:
+		return "Completed with failures"
+	case 510:
+		return statusFailed + " (duplicacy prompted for input)"
+	case 6200:
+		return "Skipped (already running)"
+	case 6201:
+		return statusDeferred
+	}
+
+	return fmt.Sprintf("%s (exit code %d)", statusFailed, result.exitCode)
+}
+
+func (result configResult) failed() bool {
+	return result.exitCode != 0 && result.exitCode != 6200 && result.exitCode != 6201
+}
+
+// Find repository configuration files in the storage directory (or its conf.d
+// subdirectory). Files that viper can read are repository configurations if they
+// specify a repository (this excludes the global configuration, checkpoints, and
+// files included by configurations). Configurations are returned by name, sorted,
+// with duplicate names (in conf.d) ignored.
+func findRepositoryConfigs(storageDir string) ([]string, error) {
+	found := map[string]bool{}
+	names := []string{}
+	for _, directory := range []string{storageDir, filepath.Join(storageDir, "conf.d")} {
+		files, err := ioutil.ReadDir(directory)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, file := range files {
+			extension := strings.TrimPrefix(filepath.Ext(file.Name()), ".")
+			name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+			if file.IsDir() || !stringInSlice(extension, viper.SupportedExts) || found[name] || name == "duplicacy-util" {
+				continue
+			}
+
+			v := viper.New()
+			v.SetConfigFile(filepath.Join(directory, file.Name()))
+			if err := v.ReadInConfig(); err != nil || !v.IsSet("repository") {
+				continue
+			}
+
+			found[name] = true
+			names = append(names, name)
+		}
+	}
+
+	// Override files for this host (like "quicken.myhost.yaml") aren't configurations
+	// on their own, even if they specify the repository
+	configs := []string{}
+	hosts := hostOverrideNames()
+	for _, name := range names {
+		if !isOverrideOf(name, names, hosts) {
+			configs = append(configs, name)
+		}
+	}
+
+	sort.Strings(configs)
+	return configs, nil
+}
+
+// Is the configuration an override file (for one of the host names) for another
+// configuration? Other names with dots (like "web.staging") are configurations.
+func isOverrideOf(name string, names []string, hosts []string) bool {
+	for _, other := range names {
+		for _, host := range hosts {
+			if name == other+"."+host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Put configurations in the order they should be run: configurations listed in
+// allConfigs.order first (in that order), then the remaining configurations
+func orderConfigs(names []string, order []string) ([]string, []string) {
+	remaining := map[string]bool{}
+	for _, name := range names {
+		remaining[name] = true
+	}
+
+	ordered, unknown := []string{}, []string{}
+	for _, name := range order {
+		if !remaining[name] {
+			unknown = append(unknown, name)
+			continue
+		}
+		ordered = append(ordered, name)
+		delete(remaining, name)
+	}
+	for _, name := range names {
+		if remaining[name] {
+			ordered = append(ordered, name)
+		}
+	}
+
+	return ordered, unknown
+}
+
+// Command line arguments to run a single configuration: the same options we
+// were run with (other than -all-configs)
+func configArguments(name string) []string {
+	args := []string{}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "all-configs" && f.Name != "f" {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+	if allConfigsNotifications == allConfigsNotifySummary {
+		args = append(args, "-no-notify")
+	}
+
+	return append(args, "-f", name)
+}
+
+// Run every repository configuration (-all-configs). Each configuration is run by
+// a separate duplicacy-util process, so it has its own lock, log file and
+// checkpoint. Up to allConfigs.parallel configurations are run at the same time.
+// When done, a summary notification is sent (unless allConfigs.notifications
+// is "each").
+func performAllConfigs(ctx context.Context) (int, error) {
+	names, err := findRepositoryConfigs(globalStorageDirectory)
+	if err != nil {
+		return 2, err
+	}
+	names, unknown := orderConfigs(names, allConfigsOrder)
+	for _, name := range unknown {
+		logError(nil, fmt.Sprintf("Warning: configuration %s (in allConfigs.order) not found", name))
+	}
+	if len(names) == 0 {
+		return 2, fmt.Errorf("no repository configurations found in %s", globalStorageDirectory)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return 2, err
+	}
+
+	parallel := allConfigsParallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	logMessage(nil, fmt.Sprintf("duplicacy-util starting, version: %s, Git Hash: %s", versionText, gitHash))
+	logMessage(nil, fmt.Sprintf("Running %d configurations (up to %d at a time): %s", len(names), parallel, strings.Join(names, ", ")))
+
+	results := make([]configResult, len(names))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			results[i] = configResult{name: name, exitCode: 500, duration: "Not run (interrupted)"}
+			<-slots
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, name string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			results[i] = runConfig(ctx, executable, name)
+		}(i, name)
+	}
+	wg.Wait()
+
+	// Summarize results (the summary is part of the summary notification)
+	failed, returnStatus := 0, 0
+	logMessage(nil, "")
+	logMessage(nil, fmt.Sprintf("  %-20s  %-12s  %s", "Configuration", "Duration", "Status"))
+	for _, result := range results {
+		logMessage(nil, fmt.Sprintf("  %-20s  %-12s  %s", result.name, result.duration, result.status()))
+		switch {
+		case result.failed() && result.exitCode != 501:
+			failed++
+			returnStatus = 500
+		case result.failed() && returnStatus == 0:
+			returnStatus = 501
+		}
+	}
+
+	if failed != 0 {
+		logError(nil, fmt.Sprintf("Error: %d of %d configurations failed", failed, len(results)))
+	}
+
+	if allConfigsNotifications != allConfigsNotifyEach {
+		if err := notifyOfSummary(results, returnStatus != 0); err != nil {
+			logError(nil, fmt.Sprintf("Error: unable to send summary notification: %s", err))
+			if returnStatus == 0 {
+				returnStatus = 5
+			}
+		}
+	}
+
+	return returnStatus, nil
+}
+
+// Run a single configuration, passing along its output (prefixed with its name).
+// The output is already time stamped, so it's not passed through logMessage. The
+// child isn't checked for prompts (it checks duplicacy itself, and exits with 510).
+func runConfig(ctx context.Context, executable string, name string) configResult {
+	startTime := time.Now()
+	logMessage(nil, fmt.Sprint("Starting configuration ", name))
+
+	err := executor(ctx, executable, configArguments(name), "", nil, func(line string) {
+		outputMutex.Lock()
+		defer outputMutex.Unlock()
+
+		w := os.Stdout
+		if strings.HasPrefix(line, stderrPrefix) {
+			w, line = os.Stderr, strings.TrimPrefix(line, stderrPrefix)
+		}
+		mailBody = append(mailBody, name+": "+line)
+		if !quietFlag {
+			fmt.Fprintln(w, name+": "+line)
+		}
+	})
+
+	result := configResult{name: name, duration: getTimeDiffString(startTime, time.Now())}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.exitCode = exitErr.ExitCode()
+
+		// Exit codes are truncated to 8 bits on most platforms other than Windows
+		for _, exitCode := range []int{500, 501, 510, 6200, 6201} {
+			if result.exitCode == exitCode&0xff {
+				result.exitCode = exitCode
+			}
+		}
+	default:
+		logError(nil, fmt.Sprintf("Error: unable to run configuration %s: %s", name, err))
+		result.exitCode = 500
+	}
+
+	logMessage(nil, fmt.Sprintf("Finished configuration %s: %s", name, result.status()))
+	return result
+}
+
+func stringInSlice(s string, slice []string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Notifier that records summary notifications
+type summaryNotifier struct {
+	results *[]configResult
+	failed  *bool
+}
+
+func (summaryNotifier) NotifyOfStart() error   { return nil }
+func (summaryNotifier) NotifyOfSkip() error    { return nil }
+func (summaryNotifier) NotifyOfDefer() error   { return nil }
+func (summaryNotifier) NotifyOfSuccess() error { return nil }
+func (summaryNotifier) NotifyOfFailure() error { return nil }
+
+func (notifier summaryNotifier) NotifyOfSummary(results []configResult, failed bool) error {
+	*notifier.results, *notifier.failed = results, failed
+	return nil
+}
+
+// Storage directory with repository configurations (and other files)
+func setupAllConfigs(t *testing.T, files map[string]string) func() {
+	savedStorageDirectory := globalStorageDirectory
+	directory, err := ioutil.TempDir("", "allconfigs")
+	if err != nil {
+		t.Fatalf("Error creating storage directory: %s", err)
+	}
+	os.Mkdir(filepath.Join(directory, "conf.d"), 0755)
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(directory, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Error writing %s: %s", name, err)
+		}
+	}
+	globalStorageDirectory = directory
+
+	return func() {
+		os.RemoveAll(directory)
+		globalStorageDirectory = savedStorageDirectory
+	}
+}
+
+func TestFindRepositoryConfigs(t *testing.T) {
+	hostname = func() (string, error) { return "laptop.example.com", nil }
+	defer func() { hostname = os.Hostname }()
+	defer setupAllConfigs(t, map[string]string{
+		"duplicacy-util.yaml":          "logfilecount: 5\n",
+		"photos.yaml":                  "repository: /photos\n",
+		"documents.json":               `{"repository": "/documents"}`,
+		"photos_checkpoint.yaml":       "operation: 1\niteration: 1\n",
+		"notes.txt":                    "repository: /notes\n",
+		"conf.d/music.yml":             "repository: /music\n",
+		"conf.d/photos.yml":            "repository: /other/photos\n",
+		"conf.d/broken.yaml":           "repository: [\n",
+		"conf.d/not-a-config.toml":     "title = \"Not a configuration\"\n",
+		"conf.d/secrets.vault.yaml":    "secrets: {}\n",
+		"photos.laptop.yaml":           "repository: /laptop/photos\n",
+		"music.laptop.example.com.yml": "repository: /laptop/music\n",
+		"web.yaml":                     "repository: /web\n",
+		"web.staging.yaml":             "repository: /web-staging\n",
+	})()
+
+	names, err := findRepositoryConfigs(globalStorageDirectory)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// Override files for this host are ignored, but not other names with dots
+	expected := []string{"documents", "music", "photos", "web", "web.staging"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("configurations were incorrect, got %v, expected %v", names, expected)
+	}
+}
+
+func TestOrderConfigs(t *testing.T) {
+	ordered, unknown := orderConfigs([]string{"documents", "music", "photos"}, []string{"photos", "missing", "documents"})
+	if expected := []string{"photos", "documents", "music"}; !reflect.DeepEqual(ordered, expected) {
+		t.Errorf("order was incorrect, got %v, expected %v", ordered, expected)
+	}
+	if expected := []string{"missing"}; !reflect.DeepEqual(unknown, expected) {
+		t.Errorf("unknown configurations were incorrect, got %v, expected %v", unknown, expected)
+	}
+}
+
+func TestConfigResultStatus(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		status   string
+		failed   bool
+	}{
+		{0, statusSuccess, false},
+		{501, "Completed with failures", true},
+		{510, statusFailed + " (duplicacy prompted for input)", true},
+		{6200, "Skipped (already running)", false},
+		{6201, statusDeferred, false},
+		{1, statusFailed + " (exit code 1)", true},
+	}
+
+	for _, test := range tests {
+		result := configResult{exitCode: test.exitCode}
+		if result.status() != test.status || result.failed() != test.failed {
+			t.Errorf("exit code %d: got %q (failed: %t), expected %q (failed: %t)",
+				test.exitCode, result.status(), result.failed(), test.status, test.failed)
+		}
+	}
+}
+
+func TestPerformAllConfigs(t *testing.T) {
+	defer setupAllConfigs(t, map[string]string{
+		"working-config.yaml":        "repository: /working\n",
+		"conf.d/failing-config.yaml": "repository: /failing\n",
+		"partial-config.yaml":        "repository: /partial\n",
+	})()
+
+	var results []configResult
+	var failed bool
+	savedNotifiers := onFailureNotifiers
+	onFailureNotifiers = []Notifier{summaryNotifier{&results, &failed}}
+	allConfigsOrder, allConfigsParallel = []string{"partial-config"}, 2
+	execCommand = fakeExecCommand
+	defer func() {
+		onFailureNotifiers = savedNotifiers
+		allConfigsOrder, allConfigsParallel = []string{}, 1
+		execCommand = exec.Command
+	}()
+
+	status, err := performAllConfigs(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if status != 500 {
+		t.Errorf("exit status was incorrect, got %d, expected 500", status)
+	}
+
+	if !failed || len(results) != 3 {
+		t.Fatalf("summary notification was incorrect, got %v (failed: %t)", results, failed)
+	}
+	expected := map[string]int{"partial-config": 501, "failing-config": 500, "working-config": 0}
+	for i, name := range []string{"partial-config", "failing-config", "working-config"} {
+		if results[i].name != name || results[i].exitCode != expected[name] {
+			t.Errorf("result %d was incorrect, got %s (exit code %d), expected %s (exit code %d)",
+				i, results[i].name, results[i].exitCode, name, expected[name])
+		}
+	}
+}
+
+// Prompt-like output from a configuration is passed along; its exit code reports the prompt
+func TestRunConfig_Prompt(t *testing.T) {
+	savedMailBody := mailBody
+	mailBody, quietFlag = []string{}, true
+	execCommand = fakeExecCommand
+	defer func() {
+		mailBody, quietFlag = savedMailBody, false
+		execCommand = exec.Command
+	}()
+
+	result := runConfig(context.Background(), "duplicacy-util", "prompting-config")
+	if result.exitCode != 510 || !result.failed() {
+		t.Errorf("Incorrect result, got exit code %d (failed: %t), expected 510", result.exitCode, result.failed())
+	}
+	expected := []string{
+		"prompting-config: Enter the keyring password:",
+		"prompting-config: Do you want to continue? [y/n]",
+	}
+	if !reflect.DeepEqual(mailBody[1:len(mailBody)-1], expected) {
+		t.Errorf("Incorrect output, got %q, expected %q", mailBody, expected)
+	}
+}
@@ -15,6 +15,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -25,6 +27,25 @@ import (
 	"time"
 )
 
+// Status of an operation (as shown in summary tables)
+const (
+	statusSuccess      = "Success"
+	statusFailed       = "Failed"
+	statusTimedOut     = "Timed out"
+	statusHookTimedOut = "Hook timed out"
+	statusSkipped      = "Skipped (completed in previous attempt)"
+	statusDeferred     = "Deferred (outside maintenance window)"
+)
+
+// Returned by performBackup if operations failed but processing continued
+var errOperationsFailed = errors.New("one or more operations failed")
+
+// Did any operation fail (with processing continuing due to continueOnError)?
+var operationsFailed bool
+
+// First operation that failed because duplicacy prompted for input (if any)
+var promptFailure *promptError
+
 type backupRevision struct {
 	storage          string
 	chunkTotalCount  string // Like: 348444
@@ -37,6 +58,16 @@ type backupRevision struct {
 	chunkNewSize     string // Like: 12,391M
 	chunkNewUploaded string // Like: 12,255M
 	duration         string
+	status           string
+	attempts         int
+}
+
+// Summary of prune and check operations
+type operationRevision struct {
+	storage  string
+	duration string
+	status   string
+	attempts int
 }
 
 type copyRevision struct {
@@ -46,9 +77,11 @@ type copyRevision struct {
 	chunkCopyCount  string // Like: 3
 	chunkSkipCount  string // Like: 106
 	duration        string
+	status          string
+	attempts        int
 }
 
-func performBackup() error {
+func performBackup(ctx context.Context) error {
 	// Handle log file rotation (before any output to log file so old one doesn't get trashed)
 
 	logMessage(nil, "Rotating log files")
@@ -64,53 +97,279 @@ func performBackup() error {
 	}
 	logger := log.New(file, "", log.Ltime)
 
-	startTime := time.Now().UTC()
+	startTime := timeNow().UTC()
+
+	// Limit the run time of the job as a whole, if so configured
+	jobCtx := ctx
+	if configFile.maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, configFile.maxRuntime)
+		defer cancel()
+	}
 
-	logMessage(logger, fmt.Sprint("Beginning backup on ", time.Now().Format("01-02-2006 15:04:05")))
+	logMessage(logger, fmt.Sprint("Beginning backup on ", timeNow().Format("01-02-2006 15:04:05")))
 
 	// Notify all configure channels that the backup process has started
 	notifyOfStart()
 
+	// Pick up where a previous failed run left off (if appropriate)
+	prepareCheckpoint(logger)
+	operationsFailed = false
+	promptFailure = nil
+	resetStepResults()
+	resetCredentials()
+
+	// Run the preJob hook and all operations. The postJob hook runs even if the
+	// job failed (it isn't subject to maxRuntime), so it can clean up after preJob.
+	err = runHook(ctx, logger, hookName("pre", checkpointNone), checkpointNone, nil, "")
+	if err == nil {
+		err = performOperations(ctx, logger)
+	}
+
+	// Remember when operations last succeeded (so missed runs can be caught up)
+	recordLastSuccess(logger)
+
+	status := statusSuccess
+	if err != nil {
+		status = failureStatus(err)
+	} else if operationsFailed {
+		status = statusFailed
+	}
+	if hookErr := runHook(jobCtx, logger, hookName("post", checkpointNone), checkpointNone, nil, status); err == nil {
+		err = hookErr
+	}
+	if errors.Is(err, errDeferred) {
+		// Operations completed so far are in the checkpoint; a later run resumes from there
+		logger.Println("######################################################################")
+		logMessage(logger, fmt.Sprint("Remaining operations deferred to the next maintenance window after ", getTimeDiffString(startTime, timeNow().UTC())))
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Println("######################################################################")
+	if operationsFailed {
+		logMessage(logger, fmt.Sprint("Operations completed with failures in ", getTimeDiffString(startTime, timeNow().UTC())))
+		if promptFailure != nil {
+			return promptFailure
+		}
+		return errOperationsFailed
+	}
+	logMessage(logger, fmt.Sprint("Operations completed in ", getTimeDiffString(startTime, timeNow().UTC())))
+
+	// Everything completed, so the checkpoint is no longer needed
+	clearCheckpoint()
+
+	// Notify all configure channels that the backup process has completd
+	err = notifyOfSuccess()
+
+	return err
+}
+
+// Perform the requested operations (backup, copy, prune, and check)
+func performOperations(ctx context.Context, logger *log.Logger) error {
 	// Perform "duplicacy backup" if required
 	if cmdBackup {
-		if err := performDuplicacyBackup(logger, []string{}); err != nil {
+		if err := performDuplicacyBackup(ctx, logger); err != nil {
 			return err
 		}
 	}
 
 	// Perform "duplicacy copy" if required
 	if cmdCopy {
-		if err := performDuplicacyCopy(logger, []string{}); err != nil {
+		if err := performDuplicacyCopy(ctx, logger); err != nil {
 			return err
 		}
 	}
 
 	// Perform "duplicacy prune" if required
 	if cmdPrune {
-		if err := performDuplicacyPrune(logger, []string{}); err != nil {
+		if err := performDuplicacyPrune(ctx, logger); err != nil {
 			return err
 		}
 	}
 
 	// Perform "duplicacy check" if required
 	if cmdCheck {
-		if err := performDuplicacyCheck(logger, []string{}); err != nil {
+		if err := performDuplicacyCheck(ctx, logger); err != nil {
 			return err
 		}
 	}
 
-	logger.Println("######################################################################")
-	logMessage(logger, fmt.Sprint("Operations completed in ", getTimeDiffString(startTime, time.Now().UTC())))
+	return nil
+}
 
-	// Notify all configure channels that the backup process has completd
-	err = notifyOfSuccess()
+// Log a line of output from duplicacy. Anything written to stderr (panics, runtime
+// errors, storage backend failures) goes to notifications as well as the log file.
+func logDuplicacyOutput(logger *log.Logger, line string) {
+	if strings.HasPrefix(line, stderrPrefix) {
+		logMessage(logger, fmt.Sprint("  ", line))
+		return
+	}
 
-	return err
+	logger.Println(line)
 }
 
-func performDuplicacyBackup(logger *log.Logger, testArgs []string) error {
+// Execute duplicacy for a single operation, honoring the "timeout" setting for
+// the operation (as well as any limit on the run time of the job as a whole).
+// Failed operations are retried as configured. Returns the number of attempts.
+func executeDuplicacy(ctx context.Context, logger *log.Logger, info operationInfo, cmdArgs []string, env []string, output func(string)) (int, error) {
+	settings := info.settings()
+	var opCtx context.Context
+	var cancel context.CancelFunc
+	if settings.Timeout > 0 {
+		opCtx, cancel = context.WithTimeout(ctx, settings.Timeout)
+	} else {
+		opCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	policy := newRetryPolicy(settings)
+
+	var err error
+	var prompt *promptError
+	attempt := 1
+	for ; ; attempt++ {
+		// If retryOn is set, we only retry if some line of output matches
+		retryable := policy.retryOn == nil
+		err = runRateLimited(opCtx, logger, info, cmdArgs, env, func(line string) {
+			if policy.retryOn != nil && policy.retryOn.MatchString(line) {
+				retryable = true
+			}
+			output(line)
+		})
+
+		// Retrying won't help if duplicacy prompted for input
+		if err == nil || attempt > policy.retries || opCtx.Err() != nil || !retryable || errors.As(err, &prompt) {
+			break
+		}
+
+		delay := policy.delayFor(attempt)
+		logMessage(logger, fmt.Sprintf("  Attempt %d of %d failed (%s), retrying in %s", attempt, policy.retries+1, err, delay))
+		select {
+		case <-opCtx.Done():
+			err = opCtx.Err()
+		case <-time.After(delay):
+		}
+		if opCtx.Err() != nil {
+			break
+		}
+	}
+
+	if err == nil && attempt > 1 {
+		logMessage(logger, fmt.Sprintf("  Succeeded on attempt %d of %d", attempt, policy.retries+1))
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil:
+		logError(logger, fmt.Sprint("Error: maximum runtime for job exceeded: ", configFile.maxRuntime))
+	case errors.Is(err, context.DeadlineExceeded):
+		logError(logger, fmt.Sprint("Error: operation timed out after ", settings.Timeout))
+	case errors.Is(err, context.Canceled):
+		logError(logger, "Error: operation was interrupted")
+	case errors.As(err, &prompt):
+		// Reported by executeOperation, which knows the storages involved
+	default:
+		logError(logger, fmt.Sprint("Error executing command: ", err))
+	}
+
+	return attempt, err
+}
+
+// Should processing continue after an operation failed? Settings for the operation
+// override the repository configuration, which overrides the global configuration.
+func continueOnError(info operationInfo) bool {
+	if value := info.settings().ContinueOnError; value != nil {
+		return *value
+	}
+
+	return configFile.continueOnError
+}
+
+// Handle a failed operation. Returns true if processing should continue with the
+// next operation, or false if the job should be aborted.
+func continueAfterFailure(ctx context.Context, logger *log.Logger, info operationInfo) bool {
+	// Stop recording progress; a later run must resume with the failed operation
+	haltCheckpoint()
+
+	// If the job as a whole was cancelled (maxRuntime or interrupted), we're done
+	if ctx.Err() != nil || !continueOnError(info) {
+		return false
+	}
+
+	resultsMutex.Lock()
+	operationsFailed = true
+	resultsMutex.Unlock()
+
+	logMessage(logger, "  Continuing with remaining operations (continueOnError)")
+	return true
+}
+
+// Status of a failed operation
+func failureStatus(err error) string {
+	var hookTimeout *hookTimeoutError
+	if errors.As(err, &hookTimeout) {
+		return statusHookTimedOut
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return statusTimedOut
+	}
+	if errors.Is(err, errDeferred) {
+		return statusDeferred
+	}
+
+	return statusFailed
+}
+
+// Arguments for "duplicacy backup", along with a description of the operation
+func backupArguments(backupInfo backupConfig) ([]string, string) {
+	cmdArgs := []string{"backup", "-storage", backupInfo.Name, "-stats"}
+
+	// Handle optional parameters that may be specified
+	threadCount := "1"
+	if backupInfo.Threads != 0 {
+		threadCount = strconv.Itoa(backupInfo.Threads)
+		cmdArgs = append(cmdArgs, "-threads", threadCount)
+	}
+
+	vssFlags := ""
+	if backupInfo.VSS {
+		cmdArgs = append(cmdArgs, "-vss")
+
+		vssFlags = " -vss"
+		if backupInfo.VSSTimeout != 0 {
+			cmdArgs = append(cmdArgs, "-vss-timeout", strconv.Itoa(backupInfo.VSSTimeout))
+			vssFlags = fmt.Sprintf("%s -vss-timeout %d", vssFlags, backupInfo.VSSTimeout)
+		}
+	}
+
+	quoteFlags := ""
+	if backupInfo.Quote != "" {
+		quoteFlags = " " + backupInfo.Quote
+		cmdArgs = append(cmdArgs, strings.Split(backupInfo.Quote, " ")...)
+	}
+
+	return cmdArgs, fmt.Sprintf("Backing up to storage %s%s with %s threads%s", backupInfo.Name, vssFlags, threadCount, quoteFlags)
+}
+
+func performDuplicacyBackup(ctx context.Context, logger *log.Logger) error {
+	return performParallel(ctx, logger, checkpointBackup, configFile.backupOperations(), func(ctx context.Context, logger *log.Logger, i int) error {
+		return performDuplicacyBackupToStorage(ctx, logger, i, configFile.backupInfo[i])
+	})
+}
+
+// Back up to a single storage (iteration i of the backup operation). Returns an
+// error if the job should be aborted.
+func performDuplicacyBackupToStorage(ctx context.Context, logger *log.Logger, i int, backupInfo backupConfig) error {
 	// Handling when processing output from "duplicacy backup" command
-	var backupEntry backupRevision
+	backupEntry := backupRevision{storage: backupInfo.Name}
+	saveBackupEntry := func() {
+		resultsMutex.Lock()
+		backupTable = append(backupTable, backupEntry)
+		resultsMutex.Unlock()
+		recordStepResult(backupInfo, backupEntry.status)
+	}
 
 	backupLogger := func(line string) {
 		switch {
@@ -153,88 +412,106 @@ func performDuplicacyBackup(logger *log.Logger, testArgs []string) error {
 			logMessage(logger, fmt.Sprint("  ", line))
 
 		default:
-			logger.Println(line)
+			logDuplicacyOutput(logger, line)
 		}
 	}
 
 	// Perform backup operation
-	for i, backupInfo := range configFile.backupInfo {
-		backupStartTime := time.Now().UTC()
-		logger.Println("######################################################################")
+	if checkpointCompleted(checkpointBackup, i+1) {
+		logMessage(logger, fmt.Sprintf("Skipping backup to storage %s (completed in previous attempt)", backupInfo.Name))
+		backupEntry.status = statusSkipped
+		saveBackupEntry()
+		return nil
+	}
 
-		// Minor support for unit tests - distasteful but only reasonable option
-		cmdArgs := make([]string, len(testArgs))
-		copy(cmdArgs, testArgs)
-		if len(cmdArgs) > 0 && cmdArgs[0] == "testbackup" {
-			cmdArgs[1] = testArgs[1] + "_backup" + strconv.Itoa(i+1)
-		}
+	// Don't perform the operation if a prerequisite did not succeed
+	if prerequisite := failedPrerequisite(backupInfo); prerequisite != "" {
+		logMessage(logger, fmt.Sprintf("Not performing backup to storage %s: %s did not succeed", backupInfo.Name, prerequisite))
+		backupEntry.status = dependencyStatus(prerequisite)
+		saveBackupEntry()
+		haltCheckpoint()
+		return nil
+	}
 
-		// Build remainder of command arguments
-		cmdArgs = append(cmdArgs, "backup", "-storage", backupInfo["name"], "-stats")
+	// Stop (leaving remaining operations for a later run) if outside the maintenance window
+	if reason := deferralReason(backupInfo); reason != "" {
+		logMessage(logger, fmt.Sprintf("Deferring backup to storage %s: %s", backupInfo.Name, reason))
+		backupEntry.status = statusDeferred
+		saveBackupEntry()
+		return errDeferred
+	}
 
-		// Handle optional parameters that may be specified
-		threadCount := "1"
-		if _, ok := backupInfo["threads"]; ok {
-			if backupInfo["threads"] != "" {
-				threadCount = backupInfo["threads"]
-				cmdArgs = append(cmdArgs, "-threads", threadCount)
-			}
-		}
+	backupStartTime := timeNow().UTC()
+	logger.Println("######################################################################")
 
-		vssFlags := ""
-		if _, ok := backupInfo["vss"]; ok {
-			if backupInfo["vss"] == "true" {
-				cmdArgs = append(cmdArgs, "-vss")
-
-				vssFlags = " -vss"
-				if _, ok := backupInfo["vssTimeout"]; ok {
-					if backupInfo["vssTimeout"] != "" {
-						cmdArgs = append(cmdArgs, "-vss-timeout", backupInfo["vssTimeout"])
-						vssFlags = fmt.Sprintf("%s -vss-timeout %s", vssFlags, backupInfo["vssTimeout"])
-					}
-				}
-			}
-		}
+	// Build command arguments
+	cmdArgs, description := backupArguments(backupInfo)
 
-		quoteFlags := ""
-		if _, ok := backupInfo["quote"]; ok {
-			if backupInfo["quote"] != "" {
-				quoteFlags = " " + backupInfo["quote"]
-				cmdArgs = append(cmdArgs, strings.Split(backupInfo["quote"], " ")...)
-			}
+	logMessage(logger, description)
+
+	// Execute duplicacy
+	attempts, err := executeOperation(ctx, logger, checkpointBackup, backupInfo, cmdArgs, backupLogger)
+	backupEntry.attempts = attempts
+	if err != nil {
+		backupEntry.duration = getTimeDiffString(backupStartTime, timeNow().UTC())
+		backupEntry.status = failureStatus(err)
+		saveBackupEntry()
+		if continueAfterFailure(ctx, logger, backupInfo) {
+			return nil
 		}
+		return err
+	}
+	backupDuration := getTimeDiffString(backupStartTime, timeNow().UTC())
 
-		logMessage(logger, fmt.Sprintf("Backing up to storage %s%s with %s threads%s", backupInfo["name"], vssFlags, threadCount, quoteFlags))
+	logMessage(logger, fmt.Sprint("  Duration: ", backupDuration))
 
-		// Execute duplicacy
-		if debugFlag {
-			logMessage(logger, fmt.Sprint("Executing: ", duplicacyPath, cmdArgs))
-		}
-		err := executor(duplicacyPath, cmdArgs, configFile.repoDir, backupLogger)
-		if err != nil {
-			logError(logger, fmt.Sprint("Error executing command: ", err))
-			return err
-		}
-		backupDuration := getTimeDiffString(backupStartTime, time.Now().UTC())
+	// Save data from backup for HTML table in E-Mail
+	backupEntry.duration = backupDuration
+	backupEntry.status = statusSuccess
+	saveBackupEntry()
 
-		// For test, could do a regexp on results, but easier to force known duration here
-		if cmdArgs[0] == "testbackup" {
-			backupDuration = "x seconds"
-		}
-		logMessage(logger, fmt.Sprint("  Duration: ", backupDuration))
+	updateCheckpoint(logger, checkpointBackup, i+1)
 
-		// Save data from backup for HTML table in E-Mail
-		backupEntry.storage = backupInfo["name"]
-		backupEntry.duration = backupDuration
-		backupTable = append(backupTable, backupEntry)
+	return nil
+}
+
+// Arguments for "duplicacy copy", along with a description of the operation
+func copyArguments(copyInfo copyConfig) ([]string, string) {
+	cmdArgs := []string{"copy", "-from", copyInfo.From, "-to", copyInfo.To}
+
+	// Handle optional parameters that may be specified
+	threadCount := "1"
+	if copyInfo.Threads != 0 {
+		threadCount = strconv.Itoa(copyInfo.Threads)
+		cmdArgs = append(cmdArgs, "-threads", threadCount)
 	}
 
-	return nil
+	quoteFlags := ""
+	if copyInfo.Quote != "" {
+		quoteFlags = " " + copyInfo.Quote
+		cmdArgs = append(cmdArgs, strings.Split(copyInfo.Quote, " ")...)
+	}
+
+	return cmdArgs, fmt.Sprintf("Copying from storage %s to storage %s with %s threads%s", copyInfo.From, copyInfo.To, threadCount, quoteFlags)
 }
 
-func performDuplicacyCopy(logger *log.Logger, testArgs []string) error {
-	// Handling when processing output from "duplicacy backup" command
-	var copyEntry copyRevision
+func performDuplicacyCopy(ctx context.Context, logger *log.Logger) error {
+	return performParallel(ctx, logger, checkpointCopy, configFile.copyOperations(), func(ctx context.Context, logger *log.Logger, i int) error {
+		return performDuplicacyCopyBetweenStorages(ctx, logger, i, configFile.copyInfo[i])
+	})
+}
+
+// Copy between two storages (iteration i of the copy operation). Returns an error
+// if the job should be aborted.
+func performDuplicacyCopyBetweenStorages(ctx context.Context, logger *log.Logger, i int, copyInfo copyConfig) error {
+	// Handling when processing output from "duplicacy copy" command
+	copyEntry := copyRevision{storageFrom: copyInfo.From, storageTo: copyInfo.To}
+	saveCopyEntry := func() {
+		resultsMutex.Lock()
+		copyTable = append(copyTable, copyEntry)
+		resultsMutex.Unlock()
+		recordStepResult(copyInfo, copyEntry.status)
+	}
 
 	copyLogger := func(line string) {
 		switch {
@@ -253,178 +530,247 @@ func performDuplicacyCopy(logger *log.Logger, testArgs []string) error {
 			}
 
 		default:
-			logger.Println(line)
+			logDuplicacyOutput(logger, line)
 		}
 	}
 
-	for i, copyInfo := range configFile.copyInfo {
-		copyStartTime := time.Now().UTC()
-		logger.Println("######################################################################")
+	if checkpointCompleted(checkpointCopy, i+1) {
+		logMessage(logger, fmt.Sprintf("Skipping copy from storage %s to storage %s (completed in previous attempt)", copyInfo.From, copyInfo.To))
+		copyEntry.status = statusSkipped
+		saveCopyEntry()
+		return nil
+	}
 
-		// Minor support for unit tests - distasteful but only reasonable option
-		cmdArgs := make([]string, len(testArgs))
-		copy(cmdArgs, testArgs)
-		if len(cmdArgs) > 0 && cmdArgs[0] == "testbackup" {
-			cmdArgs[1] = testArgs[1] + "_copy" + strconv.Itoa(i+1)
-		}
+	// Don't perform the operation if a prerequisite did not succeed
+	if prerequisite := failedPrerequisite(copyInfo); prerequisite != "" {
+		logMessage(logger, fmt.Sprintf("Not performing copy from storage %s to storage %s: %s did not succeed", copyInfo.From, copyInfo.To, prerequisite))
+		copyEntry.status = dependencyStatus(prerequisite)
+		saveCopyEntry()
+		haltCheckpoint()
+		return nil
+	}
 
-		// Build remainder of command arguments
-		cmdArgs = append(cmdArgs, "copy", "-from", copyInfo["from"], "-to", copyInfo["to"])
+	// Stop (leaving remaining operations for a later run) if outside the maintenance window
+	if reason := deferralReason(copyInfo); reason != "" {
+		logMessage(logger, fmt.Sprintf("Deferring copy from storage %s to storage %s: %s", copyInfo.From, copyInfo.To, reason))
+		copyEntry.status = statusDeferred
+		saveCopyEntry()
+		return errDeferred
+	}
 
-		// Handle optional parameters that may be specified
-		threadCount := "1"
-		if _, ok := copyInfo["threads"]; ok {
-			if copyInfo["threads"] != "" {
-				threadCount = copyInfo["threads"]
-				cmdArgs = append(cmdArgs, "-threads", threadCount)
-			}
-		}
+	copyStartTime := timeNow().UTC()
+	logger.Println("######################################################################")
 
-		quoteFlags := ""
-		if _, ok := copyInfo["quote"]; ok {
-			if copyInfo["quote"] != "" {
-				quoteFlags = " " + copyInfo["quote"]
-				cmdArgs = append(cmdArgs, strings.Split(copyInfo["quote"], " ")...)
-			}
-		}
+	// Build command arguments
+	cmdArgs, description := copyArguments(copyInfo)
 
-		logMessage(logger, fmt.Sprintf("Copying from storage %s to storage %s with %s threads%s", copyInfo["from"], copyInfo["to"], threadCount, quoteFlags))
+	logMessage(logger, description)
 
-		if debugFlag {
-			logMessage(logger, fmt.Sprint("Executing: ", duplicacyPath, cmdArgs))
-		}
-		err := executor(duplicacyPath, cmdArgs, configFile.repoDir, copyLogger)
-		if err != nil {
-			logError(logger, fmt.Sprint("Error executing command: ", err))
-			return err
+	attempts, err := executeOperation(ctx, logger, checkpointCopy, copyInfo, cmdArgs, copyLogger)
+	copyEntry.attempts = attempts
+	if err != nil {
+		copyEntry.duration = getTimeDiffString(copyStartTime, timeNow().UTC())
+		copyEntry.status = failureStatus(err)
+		saveCopyEntry()
+		if continueAfterFailure(ctx, logger, copyInfo) {
+			return nil
 		}
-		copyDuration := getTimeDiffString(copyStartTime, time.Now().UTC())
+		return err
+	}
+	copyDuration := getTimeDiffString(copyStartTime, timeNow().UTC())
 
-		// For test, could do a regexp on results, but easier to force known duration here
-		if cmdArgs[0] == "testbackup" {
-			copyDuration = "x seconds"
-		}
-		logMessage(logger, fmt.Sprint("  Duration: ", copyDuration))
+	logMessage(logger, fmt.Sprint("  Duration: ", copyDuration))
 
-		// Save data from backup for HTML table in E-Mail
-		copyEntry.storageFrom = copyInfo["from"]
-		copyEntry.storageTo = copyInfo["to"]
-		copyEntry.duration = copyDuration
-		copyTable = append(copyTable, copyEntry)
-	}
+	// Save data from backup for HTML table in E-Mail
+	copyEntry.duration = copyDuration
+	copyEntry.status = statusSuccess
+	saveCopyEntry()
+
+	updateCheckpoint(logger, checkpointCopy, i+1)
 
 	return nil
 }
 
-func performDuplicacyPrune(logger *log.Logger, testArgs []string) error {
+// Arguments for "duplicacy prune", along with a description of the operation
+func pruneArguments(pruneInfo pruneConfig) ([]string, string) {
+	cmdArgs := []string{"prune", "-storage", pruneInfo.Storage}
+	for _, keep := range pruneInfo.Keep {
+		cmdArgs = append(cmdArgs, "-keep", keep)
+	}
+
+	// Handle optional parameters that may be specified
+	threadCount := "1"
+	if pruneInfo.Threads != 0 {
+		threadCount = strconv.Itoa(pruneInfo.Threads)
+		cmdArgs = append(cmdArgs, "-threads", threadCount)
+	}
+
+	allFlag := ""
+	if pruneInfo.All {
+		allFlag = " -all"
+		cmdArgs = append(cmdArgs, "-all")
+	}
+
+	quoteFlags := ""
+	if pruneInfo.Quote != "" {
+		quoteFlags = " " + pruneInfo.Quote
+		cmdArgs = append(cmdArgs, strings.Split(pruneInfo.Quote, " ")...)
+	}
+
+	return cmdArgs, fmt.Sprintf("Pruning storage %s using %s thread(s)%s%s", pruneInfo.Storage, threadCount, allFlag, quoteFlags)
+}
+
+func performDuplicacyPrune(ctx context.Context, logger *log.Logger) error {
 	// Handling when processing output from generic "duplicacy" command
-	anon := func(s string) { logger.Println(s) }
+	anon := func(s string) { logDuplicacyOutput(logger, s) }
 
 	// Perform prune operations
 	for i, pruneInfo := range configFile.pruneInfo {
-		logger.Println("######################################################################")
-
-		// Minor support for unit tests - distasteful but only reasonable option
-		cmdArgs := make([]string, len(testArgs))
-		copy(cmdArgs, testArgs)
-		if len(cmdArgs) > 0 && cmdArgs[0] == "testbackup" {
-			cmdArgs[1] = testArgs[1] + "_prune" + strconv.Itoa(i+1)
+		pruneEntry := operationRevision{storage: pruneInfo.Storage}
+		savePruneEntry := func() {
+			resultsMutex.Lock()
+			pruneTable = append(pruneTable, pruneEntry)
+			resultsMutex.Unlock()
+			recordStepResult(pruneInfo, pruneEntry.status)
 		}
 
-		// Build remainder of command arguments
-		cmdArgs = append(testArgs, "prune", "-storage", pruneInfo["storage"])
-		cmdArgs = append(cmdArgs, strings.Split(pruneInfo["keep"], " ")...)
-
-		// Handle optional parameters that may be specified
-		threadCount := "1"
-		if _, ok := pruneInfo["threads"]; ok {
-			if pruneInfo["threads"] != "" {
-				threadCount = pruneInfo["threads"]
-				cmdArgs = append(cmdArgs, "-threads", threadCount)
-			}
+		if checkpointCompleted(checkpointPrune, i+1) {
+			logMessage(logger, fmt.Sprintf("Skipping prune of storage %s (completed in previous attempt)", pruneInfo.Storage))
+			pruneEntry.status = statusSkipped
+			savePruneEntry()
+			continue
 		}
 
-		allFlag := ""
-		if _, ok := pruneInfo["all"]; ok {
-			if pruneInfo["all"] != "false" {
-				allFlag = " -all"
-				cmdArgs = append(cmdArgs, "-all")
-			}
-		} else {
-			allFlag = " -all"
-			cmdArgs = append(cmdArgs, "-all")
+		// Don't perform the operation if a prerequisite did not succeed
+		if prerequisite := failedPrerequisite(pruneInfo); prerequisite != "" {
+			logMessage(logger, fmt.Sprintf("Not performing prune of storage %s: %s did not succeed", pruneInfo.Storage, prerequisite))
+			pruneEntry.status = dependencyStatus(prerequisite)
+			savePruneEntry()
+			haltCheckpoint()
+			continue
 		}
 
-		quoteFlags := ""
-		if _, ok := pruneInfo["quote"]; ok {
-			if pruneInfo["quote"] != "" {
-				quoteFlags = " " + pruneInfo["quote"]
-				cmdArgs = append(cmdArgs, strings.Split(pruneInfo["quote"], " ")...)
-			}
+		// Stop (leaving remaining operations for a later run) if outside the maintenance window
+		if reason := deferralReason(pruneInfo); reason != "" {
+			logMessage(logger, fmt.Sprintf("Deferring prune of storage %s: %s", pruneInfo.Storage, reason))
+			pruneEntry.status = statusDeferred
+			savePruneEntry()
+			return errDeferred
 		}
 
-		logMessage(logger, fmt.Sprintf("Pruning storage %s using %s thread(s)%s%s", pruneInfo["storage"], threadCount, allFlag, quoteFlags))
+		pruneStartTime := timeNow().UTC()
+		logger.Println("######################################################################")
+
+		// Build command arguments
+		cmdArgs, description := pruneArguments(pruneInfo)
+
+		logMessage(logger, description)
 
 		// Execute duplicacy
-		if debugFlag {
-			logMessage(logger, fmt.Sprint("Executing: ", duplicacyPath, cmdArgs))
-		}
-		err := executor(duplicacyPath, cmdArgs, configFile.repoDir, anon)
+		attempts, err := executeOperation(ctx, logger, checkpointPrune, pruneInfo, cmdArgs, anon)
+		pruneEntry.attempts = attempts
+		pruneEntry.duration = getTimeDiffString(pruneStartTime, timeNow().UTC())
 		if err != nil {
-			logError(logger, fmt.Sprint("Error executing command: ", err))
-			return err
+			pruneEntry.status = failureStatus(err)
+			savePruneEntry()
+			if !continueAfterFailure(ctx, logger, pruneInfo) {
+				return err
+			}
+			continue
 		}
+
+		pruneEntry.status = statusSuccess
+		savePruneEntry()
+
+		updateCheckpoint(logger, checkpointPrune, i+1)
 	}
 
 	return nil
 }
 
-func performDuplicacyCheck(logger *log.Logger, testArgs []string) error {
+// Arguments for "duplicacy check", along with a description of the operation
+func checkArguments(checkInfo checkConfig) ([]string, string) {
+	cmdArgs := []string{"check", "-storage", checkInfo.Storage}
+
+	// Handle optional parameters that may be specified
+	allText := ""
+	if checkInfo.All {
+		allText = " with -all"
+		cmdArgs = append(cmdArgs, "-all")
+	}
+
+	quoteFlags := ""
+	if checkInfo.Quote != "" {
+		quoteFlags = " " + checkInfo.Quote
+		cmdArgs = append(cmdArgs, strings.Split(checkInfo.Quote, " ")...)
+	}
+
+	return cmdArgs, fmt.Sprintf("Checking storage %s%s%s", checkInfo.Storage, allText, quoteFlags)
+}
+
+func performDuplicacyCheck(ctx context.Context, logger *log.Logger) error {
 	// Handling when processing output from generic "duplicacy" command
-	anon := func(s string) { logger.Println(s) }
+	anon := func(s string) { logDuplicacyOutput(logger, s) }
 
 	// Perform check operations
 	for i, checkInfo := range configFile.checkInfo {
-		logger.Println("######################################################################")
-
-		// Minor support for unit tests - distasteful but only reasonable option
-		cmdArgs := make([]string, len(testArgs))
-		copy(cmdArgs, testArgs)
-		if len(cmdArgs) > 0 && cmdArgs[0] == "testbackup" {
-			cmdArgs[1] = testArgs[1] + "_check" + strconv.Itoa(i+1)
+		checkEntry := operationRevision{storage: checkInfo.Storage}
+		saveCheckEntry := func() {
+			resultsMutex.Lock()
+			checkTable = append(checkTable, checkEntry)
+			resultsMutex.Unlock()
+			recordStepResult(checkInfo, checkEntry.status)
 		}
 
-		// Build remainder of command arguments
-		cmdArgs = append(cmdArgs, "check", "-storage", checkInfo["storage"])
+		if checkpointCompleted(checkpointCheck, i+1) {
+			logMessage(logger, fmt.Sprintf("Skipping check of storage %s (completed in previous attempt)", checkInfo.Storage))
+			checkEntry.status = statusSkipped
+			saveCheckEntry()
+			continue
+		}
 
-		// Handle optional parameters that may be specified
-		allText := ""
-		if _, ok := checkInfo["all"]; ok {
-			if checkInfo["all"] == "true" {
-				allText = " with -all"
-				cmdArgs = append(cmdArgs, "-all")
-			}
+		// Don't perform the operation if a prerequisite did not succeed
+		if prerequisite := failedPrerequisite(checkInfo); prerequisite != "" {
+			logMessage(logger, fmt.Sprintf("Not performing check of storage %s: %s did not succeed", checkInfo.Storage, prerequisite))
+			checkEntry.status = dependencyStatus(prerequisite)
+			saveCheckEntry()
+			haltCheckpoint()
+			continue
 		}
 
-		quoteFlags := ""
-		if _, ok := checkInfo["quote"]; ok {
-			if checkInfo["quote"] != "" {
-				quoteFlags = " " + checkInfo["quote"]
-				cmdArgs = append(cmdArgs, strings.Split(checkInfo["quote"], " ")...)
-			}
+		// Stop (leaving remaining operations for a later run) if outside the maintenance window
+		if reason := deferralReason(checkInfo); reason != "" {
+			logMessage(logger, fmt.Sprintf("Deferring check of storage %s: %s", checkInfo.Storage, reason))
+			checkEntry.status = statusDeferred
+			saveCheckEntry()
+			return errDeferred
 		}
 
-		logMessage(logger, fmt.Sprintf("Checking storage %s%s%s", checkInfo["storage"], allText, quoteFlags))
+		checkStartTime := timeNow().UTC()
+		logger.Println("######################################################################")
+
+		// Build command arguments
+		cmdArgs, description := checkArguments(checkInfo)
+
+		logMessage(logger, description)
 
 		// Execute duplicacy
-		if debugFlag {
-			logMessage(logger, fmt.Sprint("Executing: ", duplicacyPath, cmdArgs))
-		}
-		err := executor(duplicacyPath, cmdArgs, configFile.repoDir, anon)
+		attempts, err := executeOperation(ctx, logger, checkpointCheck, checkInfo, cmdArgs, anon)
+		checkEntry.attempts = attempts
+		checkEntry.duration = getTimeDiffString(checkStartTime, timeNow().UTC())
 		if err != nil {
-			logError(logger, fmt.Sprint("Error executing command: ", err))
-			return err
+			checkEntry.status = failureStatus(err)
+			saveCheckEntry()
+			if !continueAfterFailure(ctx, logger, checkInfo) {
+				return err
+			}
+			continue
 		}
+
+		checkEntry.status = statusSuccess
+		saveCheckEntry()
+
+		updateCheckpoint(logger, checkpointCheck, i+1)
 	}
 
 	return nil
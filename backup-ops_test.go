@@ -15,15 +15,15 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Set up logging for test purposes
@@ -39,40 +39,51 @@ func setupLogging() (*log.Logger, *os.File, error) {
 	return logger, file, nil
 }
 
-// Set up arguments for testing of os/exec calls
-func fakeBackupOpsCommand(command string, args ...string) *exec.Cmd {
-	cs := []string{"-test.run=TestBackupOpsHelperProcess", "--", command}
-	cs = append(cs, args...)
-	cmd := exec.Command(os.Args[0], cs...)
-	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
-	return cmd
+// Simulate duplicacy, replaying test assets (like "taltos.log_backup1") for each
+// iteration of the operation
+func assetRunner(assetInputFragment string, operation string, count int) *simulatedRunner {
+	runner := &simulatedRunner{directory: "test/assets"}
+	for i := 1; i <= count; i++ {
+		runner.steps = append(runner.steps, simulationStep{
+			Operation:  operation,
+			OutputFile: path.Join("test/assets", fmt.Sprintf("%s_%s%d", assetInputFragment, operation, i)),
+		})
+	}
+	runner.used = make([]bool, len(runner.steps))
+
+	return runner
+}
+
+// Use a fixed time, so durations are predictable
+func fixedTimeNow() time.Time {
+	return time.Date(2018, 7, 17, 17, 58, 25, 0, time.UTC)
 }
 
 func TestRunDuplicacyBackup(t *testing.T) {
 	tests := []struct {
 		assetInputFragment string
 		resultsFile        string
-		backupInfo         []map[string]string
+		backupInfo         []backupConfig
 	}{
 		// Dupicacy Error: Enter Backblaze Account ID:Enter Backblaze Application Key:Failed to load the Backblaze B2 storage at b2://hidden-bucket: Authorization failure
 		{
 			"account_id.log", "account_id.log_results_backup",
-			[]map[string]string{
-				{"name": "b2", "threads": "10", "vss": "false"},
+			[]backupConfig{
+				{Name: "b2", Threads: 10},
 			},
 		},
 		// Duplicacy Error: Enter storage password:Failed to read the password: EOF
 		{
 			"storagepw.log", "storagepw.log_results_backup",
-			[]map[string]string{
-				{"name": "b2", "threads": "5", "vss": "false"},
+			[]backupConfig{
+				{Name: "b2", Threads: 5},
 			},
 		},
 		// Test of long, very involved backup
 		{"taltos.log", "taltos.log_results_backup",
-			[]map[string]string{
-				{"name": "gcd", "threads": "5", "vss": "false"},
-				{"name": "azure-direct", "threads": "10", "vss": "false"},
+			[]backupConfig{
+				{Name: "gcd", Threads: 5},
+				{Name: "azure-direct", Threads: 10},
 			},
 		},
 	}
@@ -98,9 +109,13 @@ func TestRunDuplicacyBackup(t *testing.T) {
 		mailBody = nil
 		//defer os.Remove(file.Name())
 
-		execCommand = fakeBackupOpsCommand
-		defer func() { execCommand = exec.Command }()
-		if err := performDuplicacyBackup(logger, []string{"testbackup", test.assetInputFragment}); err != nil {
+		duplicacyRunner = assetRunner(test.assetInputFragment, "backup", len(test.backupInfo))
+		timeNow = fixedTimeNow
+		defer func() {
+			duplicacyRunner = execRunner{}
+			timeNow = time.Now
+		}()
+		if err := performDuplicacyBackup(context.Background(), logger); err != nil {
 			t.Errorf("expected nil error, got %v", err)
 		}
 
@@ -118,16 +133,127 @@ func TestRunDuplicacyBackup(t *testing.T) {
 	}
 }
 
+func TestRunDuplicacyBackup_ContinueOnError(t *testing.T) {
+	tests := []struct {
+		continueOnError bool
+		expectError     bool
+		statuses        []string
+	}{
+		{false, true, []string{statusSuccess, statusFailed}},
+		{true, false, []string{statusSuccess, statusFailed, statusSuccess}},
+	}
+
+	for _, test := range tests {
+		logger, file, err := setupLogging()
+		if err != nil {
+			t.Errorf("unexpected error creating log file, got %#v", err)
+		}
+		quietFlag = true
+		defer func() {
+			file.Close()
+			os.Remove(file.Name())
+			quietFlag = false
+		}()
+
+		// Second storage fails (there is no test asset for "taltos.log_backup2" with
+		// this fragment), so we only get to the third with continueOnError
+		configFile.continueOnError = test.continueOnError
+		configFile.backupInfo = []backupConfig{
+			{Name: "gcd"},
+			{Name: "missing"},
+			{Name: "gcd-again"},
+		}
+		backupTable = nil
+		operationsFailed = false
+		defer func() {
+			configFile.continueOnError = false
+			backupTable = nil
+			operationsFailed = false
+		}()
+
+		duplicacyRunner = assetRunner("continue.log", "backup", len(configFile.backupInfo))
+		defer func() { duplicacyRunner = execRunner{} }()
+		err = performDuplicacyBackup(context.Background(), logger)
+		if (err != nil) != test.expectError {
+			t.Errorf("unexpected error result (continueOnError=%t), got %v", test.continueOnError, err)
+		}
+		if operationsFailed != test.continueOnError {
+			t.Errorf("operationsFailed is incorrect, got %t, expected %t", operationsFailed, test.continueOnError)
+		}
+
+		statuses := []string{}
+		for _, entry := range backupTable {
+			statuses = append(statuses, entry.status)
+		}
+		if strings.Join(statuses, ",") != strings.Join(test.statuses, ",") {
+			t.Errorf("statuses were incorrect, got %v, expected %v", statuses, test.statuses)
+		}
+	}
+}
+
+func TestRunDuplicacyBackup_Prompt(t *testing.T) {
+	logger, file, err := setupLogging()
+	if err != nil {
+		t.Errorf("unexpected error creating log file, got %#v", err)
+	}
+	quietFlag = true
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+		quietFlag = false
+	}()
+
+	// A prompt is never retried, and is remembered even with continueOnError
+	configFile.continueOnError = true
+	configFile.backupInfo = []backupConfig{
+		{Name: "b2", operationConfig: operationConfig{Retries: 2}},
+		{Name: "azure"},
+	}
+	backupTable = nil
+	operationsFailed = false
+	promptFailure = nil
+	defer func() {
+		configFile.continueOnError = false
+		backupTable = nil
+		operationsFailed = false
+		promptFailure = nil
+	}()
+
+	duplicacyRunner = &simulatedRunner{
+		steps: []simulationStep{
+			{Operation: "backup", Storage: "b2", Prompt: "Enter Backblaze Account ID:"},
+			{Operation: "backup", Storage: "b2", Output: []string{"Retried after a prompt"}},
+			{Operation: "backup", Storage: "azure"},
+		},
+		used: make([]bool, 3),
+	}
+	defer func() { duplicacyRunner = execRunner{} }()
+	if err := performDuplicacyBackup(context.Background(), logger); err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	if promptFailure == nil {
+		t.Fatalf("expected prompt failure to be recorded")
+	}
+	expected := "duplicacy prompted for Backblaze account ID (b2_id) for storage b2"
+	if promptFailure.Error() != expected {
+		t.Errorf("incorrect prompt failure, got %q, expected %q", promptFailure.Error(), expected)
+	}
+	if len(backupTable) != 2 || backupTable[0].status != statusFailed || backupTable[1].status != statusSuccess {
+		t.Errorf("incorrect backup results, got %+v", backupTable)
+	}
+}
+
 func TestRunDuplicacyCopy(t *testing.T) {
 	tests := []struct {
 		assetInputFragment string
 		resultsFile        string
-		copyInfo           []map[string]string
+		copyInfo           []copyConfig
 	}{
 		// Test of long, very involved copy operation
 		{"taltos.log", "taltos.log_results_copy",
-			[]map[string]string{
-				{"from": "gcd", "to": "azure", "threads": "5"},
+			[]copyConfig{
+				{From: "gcd", To: "azure", Threads: 5},
 			},
 		},
 	}
@@ -153,9 +279,13 @@ func TestRunDuplicacyCopy(t *testing.T) {
 		mailBody = nil
 		//defer os.Remove(file.Name())
 
-		execCommand = fakeBackupOpsCommand
-		defer func() { execCommand = exec.Command }()
-		if err := performDuplicacyCopy(logger, []string{"testbackup", test.assetInputFragment}); err != nil {
+		duplicacyRunner = assetRunner(test.assetInputFragment, "copy", len(test.copyInfo))
+		timeNow = fixedTimeNow
+		defer func() {
+			duplicacyRunner = execRunner{}
+			timeNow = time.Now
+		}()
+		if err := performDuplicacyCopy(context.Background(), logger); err != nil {
 			t.Errorf("expected nil error, got %v", err)
 		}
 
@@ -173,62 +303,29 @@ func TestRunDuplicacyCopy(t *testing.T) {
 	}
 }
 
-// Read a file, dumping to stdout. Helper function for TestBackupOpsHelperProcess
-func readFileToStdout(logFile string) error {
-	file, err := os.OpenFile(logFile, os.O_RDONLY, os.ModePerm)
+func TestLogDuplicacyOutput(t *testing.T) {
+	logger, file, err := setupLogging()
 	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		fmt.Println(scanner.Text())
+		t.Errorf("unexpected error creating log file, got %#v", err)
 	}
+	loggingSystemDisplayTime = false
+	quietFlag = true
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
 
-	return scanner.Err()
-}
+		loggingSystemDisplayTime = true
+		quietFlag = false
+	}()
 
-// TestBackupOpsHelperProcess isn't a real test; it's a helper process for TestRunDuplicacy*
-func TestBackupOpsHelperProcess(t *testing.T) {
-	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
-		return
-	}
+	// Only output from stderr should be included in notifications
+	mailBody = nil
+	logDuplicacyOutput(logger, "Indexing /Volumes/Storage")
+	logDuplicacyOutput(logger, stderrPrefix+"panic: runtime error: invalid memory address")
 
-	args := os.Args
-	for len(args) > 0 {
-		if args[0] == "--" {
-			args = args[1:]
-			break
-		}
-		args = args[1:]
-	}
-	if len(args) == 0 {
-		fmt.Fprintf(os.Stderr, "No command\n")
-		os.Exit(2)
+	expectedOutput := "  " + stderrPrefix + "panic: runtime error: invalid memory address\n"
+	actualOutput := strings.Join(mailBody, "\n") + "\n"
+	if actualOutput != expectedOutput {
+		t.Errorf("result was incorrect, got\n=====\n%s=====\nexpected\n=====\n%s=====", actualOutput, expectedOutput)
 	}
-
-	cmd, args := args[0], args[1:]
-	if cmd != "" {
-		// For test, we don't pass a command. If one is found, just return a failure.
-		fmt.Fprintf(os.Stderr, "Unknown command %q\n", cmd)
-		os.Exit(2)
-	}
-
-	switch args[0] {
-	case "testbackup":
-		backupFile := args[1]
-		backupFile = path.Join("test/assets", backupFile)
-		fmt.Fprintf(os.Stdout, "Processing backup file: %q\n", backupFile)
-		if err := readFileToStdout(backupFile); err != nil {
-			fmt.Printf("error opening assets file: %s\n", err)
-			os.Exit(1)
-		}
-
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown argument %q\n", args)
-		os.Exit(2)
-	}
-
-	os.Exit(0)
 }
@@ -0,0 +1,203 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// A scheduled run that starts more than this late is a catch-up of a missed run
+var catchUpGrace = 5 * time.Minute
+
+// Description of the missed run being caught up (empty if this run isn't a
+// catch-up), for notifications
+var catchUpNote string
+
+// Operations (in the order performed)
+var operationNames = []string{"backup", "copy", "prune", "check"}
+
+// File with the times operations of a configuration last succeeded
+func lastSuccessFilename(config string) string {
+	return filepath.Join(globalLockDir, config+"_lastsuccess.yaml")
+}
+
+// Times operations of a configuration last succeeded (keyed by operation)
+func readLastSuccess(config string) map[string]time.Time {
+	v := viper.New()
+	v.SetConfigFile(lastSuccessFilename(config))
+
+	lastSuccess := map[string]time.Time{}
+	if err := v.ReadInConfig(); err != nil {
+		return lastSuccess
+	}
+	for _, operation := range operationNames {
+		if t, err := time.Parse(time.RFC3339, v.GetString(operation)); err == nil {
+			lastSuccess[operation] = t
+		}
+	}
+
+	return lastSuccess
+}
+
+func writeLastSuccess(config string, lastSuccess map[string]time.Time) error {
+	contents := ""
+	for _, operation := range operationNames {
+		if t, ok := lastSuccess[operation]; ok {
+			contents += fmt.Sprintf("%s: %s\n", operation, t.Format(time.RFC3339))
+		}
+	}
+
+	file, err := os.Create(lastSuccessFilename(config))
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Operations that were performed in this run, and succeeded for every storage
+func succeededOperations() []string {
+	stepResultsMutex.Lock()
+	defer stepResultsMutex.Unlock()
+
+	succeeded := func(infos []operationInfo) bool {
+		for _, info := range infos {
+			if status := stepResults[stepName(info)]; status != statusSuccess && status != statusSkipped {
+				return false
+			}
+		}
+		return true
+	}
+
+	operations := []string{}
+	if cmdBackup && succeeded(configFile.backupOperations()) {
+		operations = append(operations, "backup")
+	}
+	if cmdCopy && succeeded(configFile.copyOperations()) {
+		operations = append(operations, "copy")
+	}
+	if cmdPrune && succeeded(configFile.pruneOperations()) {
+		operations = append(operations, "prune")
+	}
+	if cmdCheck && succeeded(configFile.checkOperations()) {
+		operations = append(operations, "check")
+	}
+
+	return operations
+}
+
+// Record the time operations that succeeded in this run completed (so runs that
+// are missed can be detected). Simulated runs (with -simulate) aren't recorded,
+// or a missed run would never be caught up.
+func recordLastSuccess(logger *log.Logger) {
+	operations := succeededOperations()
+	if len(operations) == 0 || cmdSimulate != "" {
+		return
+	}
+
+	lastSuccess := readLastSuccess(cmdConfig)
+	now := timeNow()
+	for _, operation := range operations {
+		lastSuccess[operation] = now
+	}
+	if err := writeLastSuccess(cmdConfig, lastSuccess); err != nil {
+		logError(logger, fmt.Sprint("Warning: unable to record time of successful operations: ", err))
+	}
+}
+
+// Operations of a scheduled job ("all" is every operation)
+func jobOperations(job *scheduledJob) []string {
+	operations := []string{}
+	for _, operation := range operationNames {
+		if stringInSlice(operation, job.operations) || stringInSlice("all", job.operations) {
+			operations = append(operations, operation)
+		}
+	}
+
+	return operations
+}
+
+// Time a scheduled job was due, if that run was missed: the job was due (more
+// than catchUpGrace ago) after the operations of the job last succeeded. An
+// operation that never succeeded counts from when the configuration last changed
+// (so a new configuration, or one that always fails, is caught up too).
+func missedRun(job *scheduledJob, now time.Time) (time.Time, bool) {
+	lastSuccess := readLastSuccess(job.config)
+	var last time.Time
+	for _, operation := range jobOperations(job) {
+		t, ok := lastSuccess[operation]
+		if !ok {
+			if job.configured.IsZero() {
+				logError(nil, fmt.Sprintf("Warning: unable to tell if a run of %s was missed (%s never succeeded)", job, operation))
+				return time.Time{}, false
+			}
+			t = job.configured
+		}
+		if last.IsZero() || t.Before(last) {
+			last = t
+		}
+	}
+
+	due := job.schedule.next(last)
+	return due, !due.IsZero() && now.Sub(due) > catchUpGrace
+}
+
+// Perform scheduled jobs whose last run was missed (-catch-up), each once (for
+// every repository configuration, or just the one specified with -f)
+func performCatchUp(ctx context.Context) (int, error) {
+	jobs, err := scheduledJobs()
+	if err != nil {
+		return 2, err
+	}
+
+	now := timeNow()
+	missed := []*scheduledJob{}
+	for _, job := range jobs {
+		if due, ok := missedRun(job, now); ok {
+			job.next = due
+			missed = append(missed, job)
+		}
+	}
+	if len(missed) == 0 {
+		logMessage(nil, "No missed runs to catch up")
+		return 0, nil
+	}
+
+	// Catch up the runs that have been waiting longest first
+	sort.SliceStable(missed, func(i, j int) bool { return missed[i].next.Before(missed[j].next) })
+	returnStatus := 0
+	for _, job := range missed {
+		if ctx.Err() != nil {
+			break
+		}
+		if status := runScheduledJob(ctx, job); status != 0 && returnStatus == 0 {
+			returnStatus = status
+		}
+	}
+
+	return returnStatus, nil
+}
@@ -0,0 +1,189 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Lock directory (where the times operations last succeeded are recorded)
+func setupLastSuccess(t *testing.T) func() {
+	savedLockDir := globalLockDir
+	directory, err := ioutil.TempDir("", "catchup")
+	if err != nil {
+		t.Fatalf("Error creating lock directory: %s", err)
+	}
+	globalLockDir = directory
+
+	return func() {
+		os.RemoveAll(directory)
+		globalLockDir = savedLockDir
+	}
+}
+
+func TestRecordLastSuccess(t *testing.T) {
+	defer setupLastSuccess(t)()
+	savedConfig, savedConfigFile := cmdConfig, configFile
+	defer func() {
+		cmdConfig, configFile = savedConfig, savedConfigFile
+		cmdBackup, cmdCopy, cmdPrune, cmdCheck = false, false, false, false
+		timeNow = time.Now
+		resetStepResults()
+	}()
+
+	cmdConfig = "photos"
+	configFile = newConfigurationFile()
+	configFile.backupInfo = []backupConfig{{Name: "b2"}, {Name: "azure"}}
+	configFile.pruneInfo = []pruneConfig{{Storage: "b2"}}
+	configFile.checkInfo = []checkConfig{{Storage: "b2"}}
+	cmdBackup, cmdCopy, cmdPrune, cmdCheck = true, true, true, false
+	completed := time.Date(2020, time.May, 15, 3, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return completed }
+
+	// Backup succeeded (or was completed earlier), copy had nothing to do, prune failed
+	resetStepResults()
+	recordStepResult(configFile.backupInfo[0], statusSuccess)
+	recordStepResult(configFile.backupInfo[1], statusSkipped)
+	recordStepResult(configFile.pruneInfo[0], statusFailed)
+	recordLastSuccess(nil)
+
+	lastSuccess := readLastSuccess("photos")
+	expected := map[string]time.Time{"backup": completed, "copy": completed}
+	if !reflect.DeepEqual(lastSuccess, expected) {
+		t.Errorf("Incorrect last success, got %v, expected %v", lastSuccess, expected)
+	}
+
+	// Later runs only update operations that succeeded
+	timeNow = func() time.Time { return completed.Add(time.Hour) }
+	resetStepResults()
+	recordStepResult(configFile.pruneInfo[0], statusSuccess)
+	cmdBackup, cmdCopy = false, false
+	recordLastSuccess(nil)
+	lastSuccess = readLastSuccess("photos")
+	if !lastSuccess["backup"].Equal(completed) || !lastSuccess["prune"].Equal(completed.Add(time.Hour)) {
+		t.Errorf("Incorrect last success after prune, got %v", lastSuccess)
+	}
+
+	// Simulated runs aren't recorded
+	cmdSimulate = "test/assets/scenario.yml"
+	defer func() { cmdSimulate = "" }()
+	timeNow = func() time.Time { return completed.Add(2 * time.Hour) }
+	recordLastSuccess(nil)
+	if !readLastSuccess("photos")["prune"].Equal(completed.Add(time.Hour)) {
+		t.Errorf("Simulated run should not be recorded, got %v", readLastSuccess("photos"))
+	}
+}
+
+func TestMissedRun(t *testing.T) {
+	defer setupLastSuccess(t)()
+	schedule, _ := parseCron("0 2 * * *")
+	job := &scheduledJob{config: "photos", schedule: schedule, operations: []string{"backup", "copy"}}
+	now := time.Date(2020, time.May, 15, 10, 0, 0, 0, time.Local)
+
+	// Never succeeded, and the configuration couldn't be loaded (so there's no telling when it was due)
+	quietFlag = true
+	defer func() { quietFlag = false }()
+	if _, missed := missedRun(job, now); missed {
+		t.Error("Job without a successful run or configuration time shouldn't be missed")
+	}
+
+	// Never succeeded, so it counts from when the configuration last changed
+	job.configured = time.Date(2020, time.May, 15, 3, 0, 0, 0, time.Local)
+	if _, missed := missedRun(job, now); missed {
+		t.Error("Job configured after it was last due shouldn't be missed")
+	}
+	job.configured = time.Date(2020, time.May, 13, 9, 0, 0, 0, time.Local)
+	due, missed := missedRun(job, now)
+	if expected := time.Date(2020, time.May, 14, 2, 0, 0, 0, time.Local); !missed || !due.Equal(expected) {
+		t.Errorf("Incorrect missed run for new configuration, got %v %s, expected %s", missed, due, expected)
+	}
+
+	writeLastSuccess("photos", map[string]time.Time{
+		"backup": time.Date(2020, time.May, 14, 2, 30, 0, 0, time.Local),
+		"copy":   time.Date(2020, time.May, 13, 2, 45, 0, 0, time.Local),
+	})
+	due, missed = missedRun(job, now)
+	if expected := time.Date(2020, time.May, 14, 2, 0, 0, 0, time.Local); !missed || !due.Equal(expected) {
+		t.Errorf("Incorrect missed run, got %v %s, expected %s", missed, due, expected)
+	}
+
+	// A run that's only just due isn't missed
+	if _, missed := missedRun(job, time.Date(2020, time.May, 14, 2, 3, 0, 0, time.Local)); missed {
+		t.Error("Run that just became due shouldn't be missed")
+	}
+	job.operations = []string{"backup"}
+	if _, missed := missedRun(job, time.Date(2020, time.May, 15, 1, 0, 0, 0, time.Local)); missed {
+		t.Error("Backup ran after it was last due, so shouldn't be missed")
+	}
+}
+
+func TestPerformCatchUp(t *testing.T) {
+	defer setupDaemon(t)()
+	defer setupLastSuccess(t)()
+	cmdDryRun = true
+	now := time.Date(2020, time.May, 15, 10, 0, 0, 0, time.Local)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	// Photos (backup and copy at 2:00) missed its run this morning; documents has never
+	// run, but was only just configured
+	lastRun := time.Date(2020, time.May, 14, 2, 10, 0, 0, time.Local)
+	writeLastSuccess("photos", map[string]time.Time{"backup": lastRun, "copy": lastRun})
+
+	mailBody = nil
+	if status, err := performCatchUp(context.Background()); status != 0 || err != nil {
+		t.Fatalf("Unexpected result: %d (%v)", status, err)
+	}
+	output := strings.Join(mailBody, "\n")
+	if !strings.Contains(output, "Starting scheduled job photos (backup copy)") || strings.Contains(output, "documents") {
+		t.Errorf("Incorrect jobs caught up:\n%s", output)
+	}
+	if !strings.Contains(output, "Catch-up of missed run (due 05-15-2020 02:00, overdue by 8:00:00)") {
+		t.Errorf("Catch-up wasn't noted:\n%s", output)
+	}
+	if catchUpNote != "" {
+		t.Errorf("Catch-up note wasn't cleared, got %s", catchUpNote)
+	}
+
+	// Nothing to catch up once the job has run
+	writeLastSuccess("photos", map[string]time.Time{"backup": now, "copy": now})
+	mailBody = nil
+	performCatchUp(context.Background())
+	if output := strings.Join(mailBody, "\n"); !strings.Contains(output, "No missed runs to catch up") {
+		t.Errorf("Expected nothing to catch up:\n%s", output)
+	}
+
+	if _, err := os.Stat(filepath.Join(globalLockDir, "photos_lastsuccess.yaml")); err != nil {
+		t.Errorf("Last success file missing: %s", err)
+	}
+}
+
+func TestSubjectStatus(t *testing.T) {
+	if status := subjectStatus("success"); status != "success" {
+		t.Errorf("Incorrect status, got %s", status)
+	}
+	catchUpNote = "Catch-up of missed run"
+	defer func() { catchUpNote = "" }()
+	if status := subjectStatus("FAILURE"); status != "FAILURE, catch-up" {
+		t.Errorf("Incorrect status for catch-up, got %s", status)
+	}
+}
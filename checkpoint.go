@@ -16,8 +16,12 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -30,6 +34,184 @@ const (
 	checkpointCheck
 )
 
+// Checkpoints older than this are ignored (unless checkpointMaxAge is set)
+const defaultCheckpointMaxAge = 7 * 24 * time.Hour
+
+var (
+	// Checkpoint recorded by a previous (failed) run that we are resuming from
+	resumeOperation = checkpointNone
+	resumeIteration = 0
+
+	// Should completed operations be recorded in the checkpoint file?
+	checkpointActive bool
+
+	// Has an operation failed (so later operations must not advance the checkpoint)?
+	checkpointHalted bool
+
+	// When the checkpoint was first written (kept when a resumed run advances it)
+	checkpointCreated time.Time
+
+	// Last operation/iteration recorded, and iterations that completed out of
+	// order (when operations run in parallel) but can't be recorded yet
+	checkpointOperation = checkpointNone
+	checkpointIteration = 0
+	checkpointPending   = map[int]bool{}
+
+	// Operations running in parallel update the checkpoint concurrently
+	checkpointMutex sync.Mutex
+)
+
+func checkpointFilename() string {
+	return filepath.Join(globalLockDir, cmdConfig+"_checkpoint.yaml")
+}
+
+// Decide if we should resume from a checkpoint left behind by a prior run. By
+// default, we resume unless the configuration file changed after the checkpoint
+// was written (iterations may no longer line up). The -resume and -restart
+// flags force one behavior or the other. Simulated runs (with -simulate) don't
+// use checkpoints at all, since their operations were never really performed.
+func prepareCheckpoint(logger *log.Logger) {
+	resumeOperation, resumeIteration = checkpointNone, 0
+	checkpointOperation, checkpointIteration = checkpointNone, 0
+	checkpointPending = map[int]bool{}
+	checkpointActive = cmdSimulate == ""
+	checkpointHalted = false
+	checkpointCreated = time.Time{}
+
+	if !checkpointActive {
+		logMessage(logger, "Checkpoints aren't used when simulating duplicacy")
+		return
+	}
+
+	operation, iteration := readCheckpoint()
+	if operation == checkpointNone {
+		if cmdResume {
+			logMessage(logger, "No checkpoint found from a previous run; starting from the beginning")
+		}
+		return
+	}
+
+	switch {
+	case cmdRestart:
+		logMessage(logger, "Ignoring checkpoint from previous run (restart requested)")
+		removeCheckpoint()
+		return
+
+	case !cmdResume && configChangedSinceCheckpoint():
+		logMessage(logger, "Configuration file changed since previous run; ignoring checkpoint")
+		removeCheckpoint()
+		return
+	}
+
+	// Completed operations can only be skipped if the same operations were requested,
+	// and a checkpoint that's too old (say, an operation that always fails) shouldn't
+	// keep other operations from being performed
+	operations, created := readCheckpointOrigin()
+	switch {
+	case operations != requestedOperations():
+		if operations == "" {
+			operations = "unknown"
+		}
+		logMessage(logger, fmt.Sprintf("Previous run performed different operations (%s); ignoring checkpoint", operations))
+		removeCheckpoint()
+		return
+
+	case timeNow().Sub(created) > globalCheckpointMaxAge:
+		logMessage(logger, fmt.Sprintf("Checkpoint from previous run is older than %s; ignoring checkpoint", globalCheckpointMaxAge))
+		removeCheckpoint()
+		return
+	}
+
+	checkpointCreated = created
+	resumeOperation, resumeIteration = operation, iteration
+	checkpointOperation, checkpointIteration = operation, iteration
+	logMessage(logger, fmt.Sprintf("Resuming previous run (last completed: %s #%d)", checkpointName(operation), iteration))
+}
+
+// Has this operation/iteration already completed in a prior run?
+func checkpointCompleted(operation int, iteration int) bool {
+	if operation < resumeOperation {
+		return true
+	}
+
+	return operation == resumeOperation && iteration <= resumeIteration
+}
+
+// Record that an operation/iteration has completed successfully. When operations
+// run in parallel, they may complete out of order; the checkpoint only advances
+// once all earlier iterations of the operation have completed.
+func updateCheckpoint(logger *log.Logger, operation int, iteration int) {
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+
+	if !checkpointActive || checkpointHalted {
+		return
+	}
+
+	if operation != checkpointOperation {
+		checkpointOperation, checkpointIteration = operation, 0
+		checkpointPending = map[int]bool{}
+	}
+
+	checkpointPending[iteration] = true
+	if !checkpointPending[checkpointIteration+1] {
+		return
+	}
+	for checkpointPending[checkpointIteration+1] {
+		checkpointIteration++
+		delete(checkpointPending, checkpointIteration)
+	}
+
+	if err := writeCheckpoint(checkpointOperation, checkpointIteration); err != nil {
+		logError(logger, fmt.Sprint("Warning: unable to write checkpoint file: ", err))
+	}
+}
+
+// Stop recording progress after an operation fails. Operations that complete
+// later (with continueOnError) will be performed again when we resume.
+func haltCheckpoint() {
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+
+	checkpointHalted = true
+}
+
+// Clear the checkpoint (once all operations have completed)
+func clearCheckpoint() {
+	if !checkpointActive {
+		return
+	}
+
+	checkpointActive = false
+	resumeOperation, resumeIteration = checkpointNone, 0
+	removeCheckpoint()
+}
+
+func checkpointName(operation int) string {
+	switch operation {
+	case checkpointBackup:
+		return "backup"
+	case checkpointCopy:
+		return "copy"
+	case checkpointPrune:
+		return "prune"
+	case checkpointCheck:
+		return "check"
+	}
+
+	return "none"
+}
+
+// Has the configuration (any file merged into it) changed since the checkpoint was written?
+func configChangedSinceCheckpoint() bool {
+	checkpointStat, err := os.Stat(checkpointFilename())
+	if err != nil {
+		return false
+	}
+
+	return configFile.modTime().After(checkpointStat.ModTime())
+}
+
 func readCheckpoint() (int, int) {
 	// Create new viper instance and read in checkpoint file
 	v := viper.New()
@@ -61,14 +243,39 @@ func readCheckpoint() (int, int) {
 	return operation, iteration
 }
 
+// Operations requested (like "backup copy") and creation time of the checkpoint.
+// Checkpoints written by older versions have neither.
+func readCheckpointOrigin() (string, time.Time) {
+	v := viper.New()
+	v.AddConfigPath(globalLockDir)
+	v.SetConfigName(cmdConfig + "_checkpoint")
+	if err := v.ReadInConfig(); err != nil {
+		return "", time.Time{}
+	}
+
+	return v.GetString("Operations"), v.GetTime("Created")
+}
+
+// Operations requested for this run, like "backup copy"
+func requestedOperations() string {
+	operations := []string{}
+	for i, requested := range []bool{cmdBackup, cmdCopy, cmdPrune, cmdCheck} {
+		if requested {
+			operations = append(operations, checkpointName(checkpointBackup+i))
+		}
+	}
+
+	return strings.Join(operations, " ")
+}
+
 func removeCheckpoint() error {
-	filename := filepath.Join(globalLockDir, cmdConfig+"_checkpoint.yaml")
+	filename := checkpointFilename()
 	err := os.Remove(filename)
 	return err
 }
 
 func writeCheckpoint(checkpoint int, iteration int) error {
-	filename := filepath.Join(globalLockDir, cmdConfig+"_checkpoint.yaml")
+	filename := checkpointFilename()
 
 	file, err := os.Create(filename)
 	if err != nil {
@@ -84,7 +291,11 @@ func writeCheckpoint(checkpoint int, iteration int) error {
 	}()
 
 	// Write out checkpoint information in YAML format
-	_, err = file.WriteString(fmt.Sprintf("Operation: %d\nIteration: %d\n", checkpoint, iteration))
+	if checkpointCreated.IsZero() {
+		checkpointCreated = timeNow()
+	}
+	_, err = file.WriteString(fmt.Sprintf("Operation: %d\nIteration: %d\nOperations: %s\nCreated: %s\n",
+		checkpoint, iteration, requestedOperations(), checkpointCreated.UTC().Format(time.RFC3339)))
 	if err != nil {
 		return err
 	}
@@ -178,3 +178,217 @@ func TestCheckpointWrite(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckpointCompleted(t *testing.T) {
+	testEntries := []struct {
+		operation int
+		iteration int
+		completed bool
+	}{
+		{checkpointBackup, 1, true},
+		{checkpointBackup, 5, true},
+		{checkpointCopy, 1, true},
+		{checkpointCopy, 2, true},
+		{checkpointCopy, 3, false},
+		{checkpointPrune, 1, false},
+		{checkpointCheck, 1, false},
+	}
+
+	resumeOperation, resumeIteration = checkpointCopy, 2
+	defer func() {
+		resumeOperation, resumeIteration = checkpointNone, 0
+	}()
+
+	for _, entry := range testEntries {
+		if completed := checkpointCompleted(entry.operation, entry.iteration); completed != entry.completed {
+			t.Errorf("Incorrect completion for %s #%d, got '%t', expected '%t'.",
+				checkpointName(entry.operation), entry.iteration, completed, entry.completed)
+		}
+	}
+}
+
+func TestCheckpointCompleted_NoCheckpoint(t *testing.T) {
+	resumeOperation, resumeIteration = checkpointNone, 0
+
+	for _, operation := range []int{checkpointBackup, checkpointCopy, checkpointPrune, checkpointCheck} {
+		if checkpointCompleted(operation, 1) {
+			t.Errorf("Operation %s should not be completed without a checkpoint.", checkpointName(operation))
+		}
+	}
+}
+
+func TestPrepareCheckpoint_Restart(t *testing.T) {
+	quietFlag = true
+	globalLockDir = os.TempDir()
+	cmdConfig = "checkpoint-file-" + randomStringBytes(6)
+	cmdRestart = true
+	defer func() {
+		quietFlag = false
+		cmdRestart = false
+		checkpointActive = false
+		removeCheckpoint()
+	}()
+
+	if err := writeCheckpoint(checkpointBackup, 2); err != nil {
+		t.Fatalf("Error writing checkpoint file: %s", err)
+	}
+
+	prepareCheckpoint(nil)
+	if resumeOperation != checkpointNone || resumeIteration != 0 {
+		t.Errorf("Restart should ignore checkpoint, got '%d/%d'.", resumeOperation, resumeIteration)
+	}
+	if _, err := os.Stat(checkpointFilename()); !os.IsNotExist(err) {
+		t.Errorf("Restart should have removed the checkpoint file")
+	}
+}
+
+func TestPrepareCheckpoint_Resume(t *testing.T) {
+	quietFlag = true
+	globalLockDir = os.TempDir()
+	cmdConfig = "checkpoint-file-" + randomStringBytes(6)
+	defer func() {
+		quietFlag = false
+		checkpointActive = false
+		resumeOperation, resumeIteration = checkpointNone, 0
+		removeCheckpoint()
+	}()
+
+	if err := writeCheckpoint(checkpointCopy, 1); err != nil {
+		t.Fatalf("Error writing checkpoint file: %s", err)
+	}
+
+	prepareCheckpoint(nil)
+	if resumeOperation != checkpointCopy || resumeIteration != 1 {
+		t.Errorf("Incorrect resume point, got '%d/%d', expected '%d/%d'.", resumeOperation, resumeIteration, checkpointCopy, 1)
+	}
+}
+
+func TestUpdateCheckpoint_OutOfOrder(t *testing.T) {
+	quietFlag = true
+	globalLockDir = os.TempDir()
+	cmdConfig = "checkpoint-file-" + randomStringBytes(6)
+	cmdResume, cmdRestart = false, false
+	defer func() {
+		quietFlag = false
+		checkpointActive = false
+		removeCheckpoint()
+	}()
+
+	prepareCheckpoint(nil)
+
+	// Iterations completing out of order (in parallel) only advance the
+	// checkpoint once all earlier iterations have completed
+	updateCheckpoint(nil, checkpointBackup, 2)
+	if checkpoint, iteration := readCheckpoint(); checkpoint != checkpointNone {
+		t.Errorf("Incorrect checkpoint, got '%d/%d', expected none.", checkpoint, iteration)
+	}
+
+	updateCheckpoint(nil, checkpointBackup, 1)
+	if checkpoint, iteration := readCheckpoint(); checkpoint != checkpointBackup || iteration != 2 {
+		t.Errorf("Incorrect checkpoint, got '%d/%d', expected '%d/%d'.", checkpoint, iteration, checkpointBackup, 2)
+	}
+
+	updateCheckpoint(nil, checkpointCopy, 1)
+	if checkpoint, iteration := readCheckpoint(); checkpoint != checkpointCopy || iteration != 1 {
+		t.Errorf("Incorrect checkpoint, got '%d/%d', expected '%d/%d'.", checkpoint, iteration, checkpointCopy, 1)
+	}
+}
+
+func TestPrepareCheckpoint_DifferentOperations(t *testing.T) {
+	quietFlag = true
+	globalLockDir = os.TempDir()
+	cmdConfig = "checkpoint-file-" + randomStringBytes(6)
+	cmdBackup, cmdCopy, cmdPrune, cmdCheck = true, true, true, true
+	defer func() {
+		quietFlag = false
+		cmdBackup, cmdCopy, cmdPrune, cmdCheck = false, false, false, false
+		checkpointActive = false
+		resumeOperation, resumeIteration = checkpointNone, 0
+		removeCheckpoint()
+	}()
+
+	// A run of all operations failed during check; backups must not be skipped
+	// by a later run that only performs backups
+	if err := writeCheckpoint(checkpointPrune, 1); err != nil {
+		t.Fatalf("Error writing checkpoint file: %s", err)
+	}
+	cmdCopy, cmdPrune, cmdCheck = false, false, false
+
+	prepareCheckpoint(nil)
+	if resumeOperation != checkpointNone || checkpointCompleted(checkpointBackup, 1) {
+		t.Errorf("Checkpoint for different operations should be ignored, got '%d/%d'.", resumeOperation, resumeIteration)
+	}
+	if _, err := os.Stat(checkpointFilename()); !os.IsNotExist(err) {
+		t.Errorf("Checkpoint file for different operations should have been removed")
+	}
+}
+
+func TestPrepareCheckpoint_Expired(t *testing.T) {
+	quietFlag = true
+	globalLockDir = os.TempDir()
+	cmdConfig = "checkpoint-file-" + randomStringBytes(6)
+	cmdBackup, cmdCheck = true, true
+	defer func() {
+		quietFlag = false
+		cmdBackup, cmdCheck = false, false
+		checkpointActive = false
+		resumeOperation, resumeIteration = checkpointNone, 0
+		globalCheckpointMaxAge = defaultCheckpointMaxAge
+		timeNow = time.Now
+		removeCheckpoint()
+	}()
+
+	checkpointCreated = time.Time{}
+	if err := writeCheckpoint(checkpointBackup, 2); err != nil {
+		t.Fatalf("Error writing checkpoint file: %s", err)
+	}
+
+	// Still recent enough to resume from
+	globalCheckpointMaxAge = 2 * time.Hour
+	timeNow = func() time.Time { return time.Now().Add(time.Hour) }
+	prepareCheckpoint(nil)
+	if resumeOperation != checkpointBackup || resumeIteration != 2 {
+		t.Errorf("Incorrect resume point, got '%d/%d', expected '%d/%d'.", resumeOperation, resumeIteration, checkpointBackup, 2)
+	}
+
+	// Resuming (even if the checkpoint is rewritten) keeps the original creation
+	// time, so a check that always fails doesn't keep the checkpoint alive forever
+	updateCheckpoint(nil, checkpointCheck, 1)
+	timeNow = func() time.Time { return time.Now().Add(3 * time.Hour) }
+	prepareCheckpoint(nil)
+	if resumeOperation != checkpointNone || resumeIteration != 0 {
+		t.Errorf("Expired checkpoint should be ignored, got '%d/%d'.", resumeOperation, resumeIteration)
+	}
+	if _, err := os.Stat(checkpointFilename()); !os.IsNotExist(err) {
+		t.Errorf("Expired checkpoint file should have been removed")
+	}
+}
+
+func TestPrepareCheckpoint_Simulate(t *testing.T) {
+	quietFlag = true
+	globalLockDir = os.TempDir()
+	cmdConfig = "checkpoint-file-" + randomStringBytes(6)
+	cmdSimulate = "test/assets/scenario.yml"
+	defer func() {
+		quietFlag = false
+		cmdSimulate = ""
+		checkpointActive = false
+		resumeOperation, resumeIteration = checkpointNone, 0
+		removeCheckpoint()
+	}()
+
+	if err := writeCheckpoint(checkpointCopy, 1); err != nil {
+		t.Fatalf("Error writing checkpoint file: %s", err)
+	}
+
+	// A simulated run neither resumes from the checkpoint nor changes it
+	prepareCheckpoint(nil)
+	if resumeOperation != checkpointNone || resumeIteration != 0 {
+		t.Errorf("Simulated run should ignore checkpoint, got '%d/%d'.", resumeOperation, resumeIteration)
+	}
+	updateCheckpoint(nil, checkpointCheck, 1)
+	clearCheckpoint()
+	if operation, iteration := readCheckpoint(); operation != checkpointCopy || iteration != 1 {
+		t.Errorf("Simulated run should not change checkpoint, got '%d/%d'.", operation, iteration)
+	}
+}
@@ -18,8 +18,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -30,14 +35,183 @@ type configurationFile struct {
 	// Name (without extension) of the configuration file
 	configFilename string
 
+	// Full path of the configuration file that was loaded
+	configFileUsed string
+
+	// Full paths of all files merged into the configuration (see readConfigLayers)
+	layerFiles []string
+
+	// Effective settings, and where each came from (for -show-config)
+	effective []effectiveSetting
+
 	// Directory for repository
 	repoDir string
 
+	// Maximum run time for the job as a whole (zero for no limit)
+	maxRuntime time.Duration
+
+	// Continue with remaining operations if an operation fails?
+	continueOnError bool
+
+	// Maximum number of backup/copy operations to run at the same time
+	parallel int
+
+	// Times when operations may be performed (nil for any time)
+	window *maintenanceWindow
+
+	// Credentials (secret references) for storages, keyed by storage name
+	credentials map[string]map[string]string
+
+	// Hooks to run before/after the job and each operation (keyed by hook name)
+	hooks map[string]map[string]string
+
+	// Prerequisites for each step (keyed by step name, see buildDependencyGraph)
+	dependencies map[string][]string
+
 	// Storage information for backup, copy, prune, and check commands respectively
-	backupInfo []map[string]string
-	copyInfo   []map[string]string
-	pruneInfo  []map[string]string
-	checkInfo  []map[string]string
+	backupInfo []backupConfig
+	copyInfo   []copyConfig
+	pruneInfo  []pruneConfig
+	checkInfo  []checkConfig
+
+	// Schedule for operations (when run by -daemon)
+	scheduleInfo []scheduleConfig
+}
+
+// Settings at the top level of the repository configuration (other than sections,
+// hooks, and credentials, which are read separately)
+type repositoryConfig struct {
+	Repository      string             `config:"repository" required:"true" description:"Location of the repository to back up"`
+	MaxRuntime      time.Duration      `config:"maxRuntime" minimum:"1s" description:"Maximum run time for all operations (i.e. 12h)"`
+	ContinueOnError *bool              `config:"continueOnError" description:"Continue with remaining operations if one fails (overrides global setting)"`
+	Parallel        int                `config:"parallel" minimum:"1" description:"Number of backups/copies to run at the same time (overrides global maxParallel)"`
+	Window          *maintenanceWindow `config:"window" description:"Days and times when operations may be performed (i.e. mon-fri 18:00-07:00; sat,sun)"`
+}
+
+// Settings common to all operations (entries in the storage, copy, prune, and
+// check sections)
+type operationConfig struct {
+	Quote           string             `config:"quote" description:"Additional duplicacy parameters (for advanced users only)"`
+	Timeout         time.Duration      `config:"timeout" minimum:"1s" description:"Maximum run time for the operation (i.e. 6h or 90m)"`
+	ContinueOnError *bool              `config:"continueOnError" description:"Continue with remaining operations if this one fails (overrides repository setting)"`
+	Retries         int                `config:"retries" minimum:"0" description:"Number of times to retry a failed operation"`
+	RetryDelay      time.Duration      `config:"retryDelay" minimum:"0s" default:"1m" description:"Delay before the first retry (i.e. 30s or 5m)"`
+	RetryBackoff    float64            `config:"retryBackoff" minimum:"1" default:"2" description:"Multiplier applied to the delay after each retry"`
+	RetryOn         *regexp.Regexp     `config:"retryOn" description:"Only retry if some output line from duplicacy matches this regular expression"`
+	After           []string           `config:"after" description:"Steps (like backup:b2) that must succeed before this one is performed"`
+	Window          *maintenanceWindow `config:"window" description:"Days and times when this operation may be performed (overrides repository setting)"`
+}
+
+// An entry in the storage, copy, prune, or check section
+type operationInfo interface {
+	settings() operationConfig
+}
+
+func (config operationConfig) settings() operationConfig {
+	return config
+}
+
+// Rate limit settings for the entries of the storage and copy sections
+type rateConfig struct {
+	LimitRate           *rateSchedule `config:"limitRate" description:"Upload rate limit in KB/s, optionally by time of day (i.e. 2MB/s mon-fri 08:00-18:00; unlimited)"`
+	RestartOnRateChange bool          `config:"restartOnRateChange" description:"Restart the operation with the new rate if the rate limit changes while it is running"`
+}
+
+// An entry in the storage section (duplicacy backup)
+type backupConfig struct {
+	Name       string `config:"name" required:"true" description:"Storage name to back up"`
+	Threads    int    `config:"threads" minimum:"1" description:"Number of threads to use for backup (default 1)"`
+	VSS        bool   `config:"vss" description:"Enable Volume Shadow Copy service"`
+	VSSTimeout int    `config:"vssTimeout" minimum:"1" description:"Timeout in seconds to wait for the Volume Shadow Copy operation to complete"`
+	rateConfig
+	operationConfig
+}
+
+// An entry in the copy section (duplicacy copy)
+type copyConfig struct {
+	From    string `config:"from" required:"true" description:"Storage name to copy from"`
+	To      string `config:"to" required:"true" description:"Storage name to copy to"`
+	Threads int    `config:"threads" minimum:"1" description:"Number of threads to use for copy (default 1)"`
+	rateConfig
+	operationConfig
+}
+
+// An entry in the prune section (duplicacy prune)
+type pruneConfig struct {
+	Storage string   `config:"storage" required:"true" description:"Storage name to prune"`
+	Keep    []string `config:"keep" required:"true" pattern:"[0-9]+:[0-9]+" description:"Retention specification (i.e. 0:365 30:180 7:30 1:7)"`
+	Threads int      `config:"threads" minimum:"1" description:"Number of threads to use (requires duplicacy CLI v2.1.1 or later)"`
+	All     bool     `config:"all" default:"true" description:"Should all storages be pruned"`
+	operationConfig
+}
+
+// An entry in the check section (duplicacy check)
+type checkConfig struct {
+	Storage string `config:"storage" required:"true" description:"Storage name to check"`
+	All     bool   `config:"all" description:"Should all revisions be checked"`
+	operationConfig
+}
+
+// An entry in the schedule section (operations performed by -daemon)
+type scheduleConfig struct {
+	Cron       *cronSchedule `config:"cron" required:"true" description:"When to perform the operations, as a cron expression (i.e. 0 2 * * * or @daily)"`
+	Operations []string      `config:"operations" required:"true" pattern:"backup|copy|prune|check|all" description:"Operations to perform (backup, copy, prune, check, or all)"`
+}
+
+// Top level keys of the repository configuration other than repositoryConfig settings
+var repositorySections = []string{"storage", "copy", "prune", "check", "schedule", "hooks", "credentials"}
+
+// Entries of the storage section (as operations)
+func (config *configurationFile) backupOperations() []operationInfo {
+	infos := make([]operationInfo, len(config.backupInfo))
+	for i, info := range config.backupInfo {
+		infos[i] = info
+	}
+	return infos
+}
+
+// Entries of the copy section (as operations)
+func (config *configurationFile) copyOperations() []operationInfo {
+	infos := make([]operationInfo, len(config.copyInfo))
+	for i, info := range config.copyInfo {
+		infos[i] = info
+	}
+	return infos
+}
+
+// Entries of the prune section (as operations)
+func (config *configurationFile) pruneOperations() []operationInfo {
+	infos := make([]operationInfo, len(config.pruneInfo))
+	for i, info := range config.pruneInfo {
+		infos[i] = info
+	}
+	return infos
+}
+
+// Entries of the check section (as operations)
+func (config *configurationFile) checkOperations() []operationInfo {
+	infos := make([]operationInfo, len(config.checkInfo))
+	for i, info := range config.checkInfo {
+		infos[i] = info
+	}
+	return infos
+}
+
+// When the configuration (any file merged into it) last changed
+func (config *configurationFile) modTime() time.Time {
+	filenames := config.layerFiles
+	if len(filenames) == 0 && config.configFileUsed != "" {
+		filenames = []string{config.configFileUsed}
+	}
+
+	var modTime time.Time
+	for _, filename := range filenames {
+		if stat, err := os.Stat(filename); err == nil && stat.ModTime().After(modTime) {
+			modTime = stat.ModTime()
+		}
+	}
+
+	return modTime
 }
 
 func newConfigurationFile() *configurationFile {
@@ -53,11 +227,7 @@ func (config *configurationFile) loadConfig(verboseFlag bool, debugFlag bool) er
 	var err error
 
 	// Separate config file should use new viper instance
-	v := viper.New()
-
-	// Search config in home directory with name ".duplicacy-util" (without extension).
-	v.AddConfigPath(globalStorageDirectory)
-	v.SetConfigName(config.configFilename)
+	v := repositoryConfigViper(config.configFilename)
 
 	v.AutomaticEnv() // read in environment variables that match
 
@@ -66,115 +236,244 @@ func (config *configurationFile) loadConfig(verboseFlag bool, debugFlag bool) er
 		logError(nil, fmt.Sprint("Error: ", err))
 		return err
 	}
+	config.configFileUsed = v.ConfigFileUsed()
 
-	// Grab the repository location
-	config.repoDir = v.GetString("repository")
-	if config.repoDir == "" {
-		err = errors.New("missing mandatory repository location")
+	// Merge in files included by the configuration file, and the override file for this host
+	layers, layerErr := readConfigLayers(v)
+	if layerErr != nil {
+		logError(nil, fmt.Sprint("Error: ", layerErr))
+		return layerErr
+	}
+	config.layerFiles = nil
+	for _, layer := range layers {
+		config.layerFiles = append(config.layerFiles, layer.filename)
+	}
+	sources := settingSources(layers)
+	if v, layerErr = mergeConfigLayers(layers); layerErr != nil {
+		logError(nil, fmt.Sprint("Error: ", layerErr))
+		return layerErr
+	}
+
+	// Expand variables (like "${HOSTNAME}") in any setting
+	for _, expandErr := range expandConfigVariables(v) {
+		err = expandErr
 		logError(nil, fmt.Sprint("Error: ", err))
 	}
-	if _, err = os.Stat(config.repoDir); err != nil {
+
+	// Resolve secret references (like "env:B2_BUCKET") in any setting
+	if err := resolveConfigSecrets(v); err != nil {
 		logError(nil, fmt.Sprint("Error: ", err))
+		return err
 	}
 
-	// Populate information from configuration
-	config.backupInfo = readSection(v, config.configFilename, "storage")
-	config.copyInfo = readSection(v, config.configFilename, "copy")
-	config.pruneInfo = readSection(v, config.configFilename, "prune")
-	config.checkInfo = readSection(v, config.configFilename, "check")
+	// Settings we don't know about are likely typos (but may be for a newer version)
+	known := append([]string{"include", "defaults"}, repositorySections...)
+	for _, setting := range settingsOf(reflect.TypeOf(repositoryConfig{})) {
+		known = append(known, setting.name)
+	}
+	keys := []string{}
+	for key := range v.AllSettings() {
+		keys = append(keys, key)
+	}
+	for _, warning := range unknownSettings(keys, known) {
+		logError(nil, fmt.Sprint("Warning: ", warning))
+	}
 
-	// Validate, set defaults
-	if len(config.backupInfo) == 0 {
-		err = errors.New("no storage locations defined in configuration")
-		logError(nil, fmt.Sprint("Error: ", err))
-	} else {
-		for i, bi := range config.backupInfo {
-			if bi["name"] == "" {
-				err = fmt.Errorf("missing mandatory storage field: %d.name", i)
-				logError(nil, fmt.Sprint("Error: ", err))
-			}
+	// Decode settings at the top level
+	var repository repositoryConfig
+	raw := map[string]string{}
+	for _, setting := range settingsOf(reflect.TypeOf(repository)) {
+		if v.IsSet(setting.name) {
+			raw[setting.name] = v.GetString(setting.name)
 		}
 	}
+	config.effective = effectiveSettings("", raw, reflect.TypeOf(repository), func(key string) string {
+		return sources[strings.ToLower(key)]
+	})
+	for _, decodeErr := range decodeSettings("", raw, &repository) {
+		err = decodeErr
+		logError(nil, fmt.Sprint("Error: ", err))
+	}
 
-	for i, ci := range config.copyInfo {
-		if ci["from"] == "" {
-			err = fmt.Errorf("missing mandatory from field: %d.from", i)
-			logError(nil, fmt.Sprint("Error: ", err))
-		}
-		if ci["to"] == "" {
-			err = fmt.Errorf("missing mandatory to field: %d.to", i)
+	// Grab the repository location
+	config.repoDir = repository.Repository
+	if config.repoDir != "" {
+		if _, statErr := os.Stat(config.repoDir); statErr != nil {
+			err = statErr
 			logError(nil, fmt.Sprint("Error: ", err))
 		}
 	}
 
-	if len(config.pruneInfo) == 0 {
-		err = errors.New("no prune locations defined in configuration")
-		logError(nil, fmt.Sprint("Error: ", err))
+	// Grab the maximum run time for the job (if any)
+	config.maxRuntime = repository.MaxRuntime
+
+	// Repository setting for continueOnError overrides the global setting
+	config.continueOnError = globalContinueOnError
+	if repository.ContinueOnError != nil {
+		config.continueOnError = *repository.ContinueOnError
+	}
+
+	// Repository setting for parallel overrides the global maxParallel setting
+	config.parallel = globalMaxParallel
+	if repository.Parallel != 0 {
+		config.parallel = repository.Parallel
 	}
 
-	for i, pi := range config.pruneInfo {
-		if pi["storage"] == "" {
-			err = fmt.Errorf("missing mandatory prune field: %d.storage", i)
+	// Grab the maintenance window for operations (if any)
+	config.window = repository.Window
+
+	// Populate information from configuration, decoding (and validating) each entry.
+	// Settings not in an entry are taken from the defaults for the section (if any).
+	for _, defaultsErr := range validateDefaults(v) {
+		err = defaultsErr
+		logError(nil, fmt.Sprint("Error: ", err))
+	}
+	decodeSection := func(section string, t reflect.Type, decode func(location string, raw map[string]string) []error) {
+		defaults, errs := sectionDefaults(v, section, t)
+		for _, defaultsErr := range errs {
+			err = defaultsErr
 			logError(nil, fmt.Sprint("Error: ", err))
 		}
-		if pi["keep"] == "" {
-			err = fmt.Errorf("missing mandatory prune field: %d.keep", i)
+
+		entries, sectionErr := readSection(v, config.configFilename, section)
+		if sectionErr != nil {
+			err = sectionErr
 			logError(nil, fmt.Sprint("Error: ", err))
-		} else {
-			// Split/join to get "-keep " before each element
-			splitList := strings.Split(pi["keep"], " ")
-			for i, element := range splitList {
-				splitList[i] = "-keep " + element
+		}
+		for i, raw := range entries {
+			location := fmt.Sprint(section, ".", i)
+			applied := applyDefaults(raw, defaults)
+			config.effective = append(config.effective, effectiveSettings(location, raw, t, func(key string) string {
+				if applied[key] {
+					return sources["defaults."+section+"."+strings.ToLower(key)] + " (defaults)"
+				}
+				return sources[section]
+			})...)
+
+			for _, decodeErr := range decode(location, raw) {
+				err = decodeErr
+				logError(nil, fmt.Sprint("Error: ", err))
 			}
+		}
+	}
 
-			pi["keep"] = strings.Join(splitList, " ")
+	config.backupInfo = nil
+	decodeSection("storage", reflect.TypeOf(backupConfig{}), func(location string, raw map[string]string) []error {
+		var info backupConfig
+		errs := decodeSettings(location, raw, &info)
+		config.backupInfo = append(config.backupInfo, info)
+		return errs
+	})
+	config.copyInfo = nil
+	decodeSection("copy", reflect.TypeOf(copyConfig{}), func(location string, raw map[string]string) []error {
+		var info copyConfig
+		errs := decodeSettings(location, raw, &info)
+		config.copyInfo = append(config.copyInfo, info)
+		return errs
+	})
+	config.pruneInfo = nil
+	decodeSection("prune", reflect.TypeOf(pruneConfig{}), func(location string, raw map[string]string) []error {
+		var info pruneConfig
+		errs := decodeSettings(location, raw, &info)
+		config.pruneInfo = append(config.pruneInfo, info)
+		return errs
+	})
+	config.checkInfo = nil
+	decodeSection("check", reflect.TypeOf(checkConfig{}), func(location string, raw map[string]string) []error {
+		var info checkConfig
+		errs := decodeSettings(location, raw, &info)
+		config.checkInfo = append(config.checkInfo, info)
+		return errs
+	})
+	config.scheduleInfo = nil
+	decodeSection("schedule", reflect.TypeOf(scheduleConfig{}), func(location string, raw map[string]string) []error {
+		var info scheduleConfig
+		errs := decodeSettings(location, raw, &info)
+		config.scheduleInfo = append(config.scheduleInfo, info)
+		return errs
+	})
+
+	// Hooks and credentials are shown as is (credentials may be literal secrets)
+	keys = v.AllKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch strings.SplitN(key, ".", 2)[0] {
+		case "hooks":
+			config.effective = append(config.effective, effectiveSetting{name: key, value: v.GetString(key), source: sources[key]})
+		case "credentials":
+			config.effective = append(config.effective, effectiveSetting{name: key, value: "(hidden)", source: sources[key]})
 		}
 	}
 
+	// Validate
+	if len(config.backupInfo) == 0 {
+		err = errors.New("no storage locations defined in configuration")
+		logError(nil, fmt.Sprint("Error: ", err))
+	}
+
+	if len(config.pruneInfo) == 0 {
+		err = errors.New("no prune locations defined in configuration")
+		logError(nil, fmt.Sprint("Error: ", err))
+	}
+
 	if len(config.checkInfo) == 0 {
 		err = errors.New("no check locations defined in configuration")
 		logError(nil, fmt.Sprint("Error: ", err))
+	}
+
+	// Read credentials for storages (secrets are resolved when needed)
+	if credentials, credentialErr := readCredentials(v, config); credentialErr != nil {
+		err = credentialErr
+		logError(nil, fmt.Sprint("Error: ", err))
 	} else {
-		for i, ci := range config.checkInfo {
-			if ci["storage"] == "" {
-				err = fmt.Errorf("missing mandatory check field: %d.storage", i)
-				logError(nil, fmt.Sprint("Error: ", err))
-			}
-		}
+		config.credentials = credentials
+	}
+
+	// Read (and validate) hooks to run around operations
+	if hooks, hookErr := readHooks(v); hookErr != nil {
+		err = hookErr
+		logError(nil, fmt.Sprint("Error: ", err))
+	} else {
+		config.hooks = hooks
+	}
+
+	// Build (and validate) the dependency graph between operations
+	if dependencies, graphErr := buildDependencyGraph(config); graphErr != nil {
+		err = graphErr
+		logError(nil, fmt.Sprint("Error: ", err))
+	} else {
+		config.dependencies = dependencies
 	}
 
 	// Generate verbose/debug output if requested (assuming no fatal errors)
 
 	if err == nil {
-		logMessage(nil, fmt.Sprint("Using config file:   ", v.ConfigFileUsed()))
+		logMessage(nil, fmt.Sprint("Using config file:   ", config.configFileUsed))
+		for _, filename := range config.layerFiles {
+			if filename != config.configFileUsed {
+				logMessage(nil, fmt.Sprint("Merging config file: ", filename))
+			}
+		}
 
 		if verboseFlag {
 			logMessage(nil, "")
 			logMessage(nil, "Backup Information:")
 			logMessage(nil, fmt.Sprintf("  Num\t%-20s%s", "Storage", "Threads"))
 			for i := range config.backupInfo {
-				var localThreads string
-				if _, ok := config.backupInfo[i]["threads"]; ok {
-					localThreads = config.backupInfo[i]["threads"]
-				}
-				logMessage(nil, fmt.Sprintf("  %2d\t%-20s   %-2s", i+1, config.backupInfo[i]["name"], localThreads))
+				logMessage(nil, fmt.Sprintf("  %2d\t%-20s   %-2s", i+1, config.backupInfo[i].Name, threadsText(config.backupInfo[i].Threads)))
 			}
 			if len(config.copyInfo) != 0 {
 				logMessage(nil, "Copy Information:")
 				logMessage(nil, fmt.Sprintf("  Num\t%-20s%-20s%s", "From", "To", "Threads"))
 				for i := range config.copyInfo {
-					var localThreads string
-					if _, ok := config.copyInfo[i]["threads"]; ok {
-						localThreads = config.copyInfo[i]["threads"]
-					}
-					logMessage(nil, fmt.Sprintf("  %2d\t%-20s%-20s   %-2s", i+1, config.copyInfo[i]["from"], config.copyInfo[i]["to"], localThreads))
+					logMessage(nil, fmt.Sprintf("  %2d\t%-20s%-20s   %-2s", i+1, config.copyInfo[i].From, config.copyInfo[i].To, threadsText(config.copyInfo[i].Threads)))
 				}
 			}
 			logMessage(nil, "")
 
 			logMessage(nil, "Prune Information:")
 			for i := range config.pruneInfo {
-				logMessage(nil, fmt.Sprintf("  %2d: Storage %s\n      Keep: %s", i+1, config.pruneInfo[i]["storage"], config.pruneInfo[i]["keep"]))
+				logMessage(nil, fmt.Sprintf("  %2d: Storage %s\n      Keep: %s", i+1, config.pruneInfo[i].Storage, strings.Join(config.pruneInfo[i].Keep, " ")))
 			}
 			logMessage(nil, "")
 
@@ -182,34 +481,61 @@ func (config *configurationFile) loadConfig(verboseFlag bool, debugFlag bool) er
 			logMessage(nil, fmt.Sprintf("  Num\t%-20s%s", "Storage", "All Snapshots"))
 			for i := range config.checkInfo {
 				var checkAll string
-				if _, ok := config.checkInfo[i]["all"]; ok {
+				if config.checkInfo[i].All {
 					checkAll = "true"
 				}
-				logMessage(nil, fmt.Sprintf("  %2d\t%-20s    %-2s", i+1, config.checkInfo[i]["storage"], checkAll))
+				logMessage(nil, fmt.Sprintf("  %2d\t%-20s    %-2s", i+1, config.checkInfo[i].Storage, checkAll))
 			}
 			logMessage(nil, "")
 		}
 
+		// Settings may include resolved secrets, so redact them
 		if debugFlag {
 			logMessage(nil, "")
-			logMessage(nil, fmt.Sprint("Backup Info: ", config.backupInfo))
-			logMessage(nil, fmt.Sprint("Copy Info: ", config.copyInfo))
-			logMessage(nil, fmt.Sprint("Prune Info: ", config.pruneInfo))
-			logMessage(nil, fmt.Sprint("Check Info", config.checkInfo))
+			logMessage(nil, redactSecrets(fmt.Sprint("Backup Info: ", formatSection(config.backupInfo))))
+			logMessage(nil, redactSecrets(fmt.Sprint("Copy Info: ", formatSection(config.copyInfo))))
+			logMessage(nil, redactSecrets(fmt.Sprint("Prune Info: ", formatSection(config.pruneInfo))))
+			logMessage(nil, redactSecrets(fmt.Sprint("Check Info: ", formatSection(config.checkInfo))))
+			logMessage(nil, fmt.Sprint("Dependencies: ", config.dependencies))
+			logMessage(nil, fmt.Sprint("Parallel: ", config.parallel))
+			logMessage(nil, redactSecrets(fmt.Sprint("Hooks: ", config.hooks)))
+
+			// Don't show credentials themselves (they may be literal secrets)
+			for storage, secrets := range config.credentials {
+				keys := []string{}
+				for key := range secrets {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				logMessage(nil, fmt.Sprintf("Credentials for %s: %s", storage, strings.Join(keys, ", ")))
+			}
 		}
 	}
 
 	return err
 }
 
-func readSection(viper *viper.Viper, filename string, sectionKey string) []map[string]string {
+// Viper instance to read a repository configuration file (in the storage
+// directory, or its conf.d subdirectory)
+func repositoryConfigViper(name string) *viper.Viper {
+	v := viper.New()
+	v.AddConfigPath(globalStorageDirectory)
+	v.AddConfigPath(filepath.Join(globalStorageDirectory, "conf.d"))
+	v.SetConfigName(name)
+
+	return v
+}
+
+// Entries of a section (a list, or numbered entries in the old format). Each
+// entry must be a map of settings.
+func readSection(viper *viper.Viper, filename string, sectionKey string) ([]map[string]string, error) {
 	if viper.IsSet(sectionKey) {
 		section := make([]interface{}, 0)
 		if viper.IsSet(sectionKey + ".1") {
 			// Have we issued our global warning before; if not, do so
 			if !oldBackupFileFormat {
 				oldBackupFileFormat = true
-				logError(nil, "WARNING: Upgrade format of backup configuration "+filename+" to new format!")
+				logError(nil, "WARNING: Upgrade format of backup configuration "+filename+" to new format (use -migrate-config)!")
 			}
 
 			// Keyed by number
@@ -223,12 +549,23 @@ func readSection(viper *viper.Viper, filename string, sectionKey string) []map[s
 			}
 		} else {
 			// Array
-			section = viper.Get(sectionKey).([]interface{})
+			var ok bool
+			if section, ok = viper.Get(sectionKey).([]interface{}); !ok {
+				return nil, fmt.Errorf("invalid section: %s (must be a list of entries)", sectionKey)
+			}
+		}
+
+		for i, item := range section {
+			switch item.(type) {
+			case map[string]interface{}, map[interface{}]interface{}:
+			default:
+				return nil, fmt.Errorf("invalid section: %s.%d (must be a map of settings)", sectionKey, i)
+			}
 		}
 
-		return coerceToArrayOfMapStringString(section[0:])
+		return coerceToArrayOfMapStringString(section[0:]), nil
 	}
-	return nil
+	return nil, nil
 }
 
 func coerceToArrayOfMapStringString(slice []interface{}) []map[string]string {
@@ -239,13 +576,13 @@ func coerceToArrayOfMapStringString(slice []interface{}) []map[string]string {
 		case map[string]interface{}:
 			for key, value := range typeList {
 				strKey := key
-				strValue := fmt.Sprintf("%v", value)
+				strValue := coerceToString(value)
 				itemMap[strKey] = strValue
 			}
 		case map[interface{}]interface{}:
 			for key, value := range typeList {
 				strKey := fmt.Sprintf("%v", key)
-				strValue := fmt.Sprintf("%v", value)
+				strValue := coerceToString(value)
 				itemMap[strKey] = strValue
 			}
 		}
@@ -253,3 +590,24 @@ func coerceToArrayOfMapStringString(slice []interface{}) []map[string]string {
 	}
 	return section
 }
+
+// Lists (like "keep" or "after") may be written as YAML lists
+func coerceToString(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprintf("%v", item)
+		}
+		return strings.Join(items, " ")
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// Number of threads (for display)
+func threadsText(threads int) string {
+	if threads == 0 {
+		return ""
+	}
+	return strconv.Itoa(threads)
+}
@@ -3,8 +3,12 @@ package main
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
+// Settings of an operation with no optional settings (only defaults)
+var defaultSettings = operationConfig{RetryDelay: time.Minute, RetryBackoff: 2}
+
 func TestValidConfigWithNumberedKeys(t *testing.T) {
 	quietFlag = true
 	defer func() {
@@ -20,10 +24,10 @@ func TestValidConfigWithNumberedKeys(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for backupInfo
-	var backupInfo = []map[string]string{
-		{"name": "b2", "threads": "10"},
-		{"name": "azure-direct", "threads": "5"},
-		{"name": "default-threads"},
+	var backupInfo = []backupConfig{
+		{Name: "b2", Threads: 10, operationConfig: defaultSettings},
+		{Name: "azure-direct", Threads: 5, operationConfig: defaultSettings},
+		{Name: "default-threads", operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(backupInfo, configFile.backupInfo) == false {
@@ -31,9 +35,9 @@ func TestValidConfigWithNumberedKeys(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for copyInfo
-	var copyInfo = []map[string]string{
-		{"from": "b2", "to": "azure", "threads": "10"},
-		{"from": "b2", "to": "default-threads"},
+	var copyInfo = []copyConfig{
+		{From: "b2", To: "azure", Threads: 10, operationConfig: defaultSettings},
+		{From: "b2", To: "default-threads", operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(copyInfo, configFile.copyInfo) == false {
@@ -41,9 +45,9 @@ func TestValidConfigWithNumberedKeys(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for pruneInfo
-	var pruneInfo = []map[string]string{
-		{"storage": "b2", "keep": "-keep 0:365 -keep 30:180 -keep 7:30 -keep 1:7"},
-		{"storage": "azure", "keep": "-keep 0:365 -keep 30:180 -keep 7:30 -keep 1:7"},
+	var pruneInfo = []pruneConfig{
+		{Storage: "b2", Keep: []string{"0:365", "30:180", "7:30", "1:7"}, All: true, operationConfig: defaultSettings},
+		{Storage: "azure", Keep: []string{"0:365", "30:180", "7:30", "1:7"}, All: true, operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(pruneInfo, configFile.pruneInfo) == false {
@@ -51,9 +55,9 @@ func TestValidConfigWithNumberedKeys(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for checkInfo
-	var checkInfo = []map[string]string{
-		{"storage": "b2", "all": "true"},
-		{"storage": "azure"},
+	var checkInfo = []checkConfig{
+		{Storage: "b2", All: true, operationConfig: defaultSettings},
+		{Storage: "azure", operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(checkInfo, configFile.checkInfo) == false {
@@ -76,10 +80,10 @@ func TestValidConfigWithArray(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for backupInfo
-	var backupInfo = []map[string]string{
-		{"name": "b2", "threads": "10"},
-		{"name": "azure-direct", "threads": "5"},
-		{"name": "default-threads"},
+	var backupInfo = []backupConfig{
+		{Name: "b2", Threads: 10, operationConfig: defaultSettings},
+		{Name: "azure-direct", Threads: 5, operationConfig: defaultSettings},
+		{Name: "default-threads", operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(backupInfo, configFile.backupInfo) == false {
@@ -87,9 +91,9 @@ func TestValidConfigWithArray(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for copyInfo
-	var copyInfo = []map[string]string{
-		{"from": "b2", "to": "azure", "threads": "10"},
-		{"from": "b2", "to": "default-threads"},
+	var copyInfo = []copyConfig{
+		{From: "b2", To: "azure", Threads: 10, operationConfig: defaultSettings},
+		{From: "b2", To: "default-threads", operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(copyInfo, configFile.copyInfo) == false {
@@ -97,9 +101,9 @@ func TestValidConfigWithArray(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for pruneInfo
-	var pruneInfo = []map[string]string{
-		{"storage": "b2", "keep": "-keep 0:365 -keep 30:180 -keep 7:30 -keep 1:7"},
-		{"storage": "azure", "keep": "-keep 0:365 -keep 30:180 -keep 7:30 -keep 1:7"},
+	var pruneInfo = []pruneConfig{
+		{Storage: "b2", Keep: []string{"0:365", "30:180", "7:30", "1:7"}, All: true, operationConfig: defaultSettings},
+		{Storage: "azure", Keep: []string{"0:365", "30:180", "7:30", "1:7"}, All: true, operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(pruneInfo, configFile.pruneInfo) == false {
@@ -107,9 +111,9 @@ func TestValidConfigWithArray(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for checkInfo
-	var checkInfo = []map[string]string{
-		{"storage": "b2", "all": "true"},
-		{"storage": "azure"},
+	var checkInfo = []checkConfig{
+		{Storage: "b2", All: true, operationConfig: defaultSettings},
+		{Storage: "azure", operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(checkInfo, configFile.checkInfo) == false {
@@ -132,10 +136,10 @@ func TestValidConfig_NoCopySection(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for backupInfo
-	var backupInfo = []map[string]string{
-		{"name": "b2", "threads": "10"},
-		{"name": "azure-direct", "threads": "5"},
-		{"name": "default-threads"},
+	var backupInfo = []backupConfig{
+		{Name: "b2", Threads: 10, operationConfig: defaultSettings},
+		{Name: "azure-direct", Threads: 5, operationConfig: defaultSettings},
+		{Name: "default-threads", operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(backupInfo, configFile.backupInfo) == false {
@@ -143,16 +147,16 @@ func TestValidConfig_NoCopySection(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for copyInfo (this test has no Copy section)
-	var copyInfo []map[string]string
+	var copyInfo []copyConfig
 
 	if reflect.DeepEqual(copyInfo, configFile.copyInfo) == false {
 		t.Error("copyInfo should have been equal, expected:", copyInfo, ", received:", configFile.copyInfo)
 	}
 
 	// Verify results of the configuration file load for pruneInfo
-	var pruneInfo = []map[string]string{
-		{"storage": "b2", "keep": "-keep 0:365 -keep 30:180 -keep 7:30 -keep 1:7"},
-		{"storage": "azure", "keep": "-keep 0:365 -keep 30:180 -keep 7:30 -keep 1:7"},
+	var pruneInfo = []pruneConfig{
+		{Storage: "b2", Keep: []string{"0:365", "30:180", "7:30", "1:7"}, All: true, operationConfig: defaultSettings},
+		{Storage: "azure", Keep: []string{"0:365", "30:180", "7:30", "1:7"}, All: true, operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(pruneInfo, configFile.pruneInfo) == false {
@@ -160,9 +164,9 @@ func TestValidConfig_NoCopySection(t *testing.T) {
 	}
 
 	// Verify results of the configuration file load for checkInfo
-	var checkInfo = []map[string]string{
-		{"storage": "b2", "all": "true"},
-		{"storage": "azure"},
+	var checkInfo = []checkConfig{
+		{Storage: "b2", All: true, operationConfig: defaultSettings},
+		{Storage: "azure", operationConfig: defaultSettings},
 	}
 
 	if reflect.DeepEqual(checkInfo, configFile.checkInfo) == false {
@@ -197,3 +201,196 @@ func TestInvalidConfig_MissingStorageName(t *testing.T) {
 		t.Error("Invalid storage configuration error should have been returned")
 	}
 }
+
+func TestInvalidConfig_UnknownSetting(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("unknownSetting")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err == nil {
+		t.Error("Expected error for unknown storage setting, got nil")
+	}
+}
+
+func TestInvalidConfig_ScalarSection(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("scalarSection")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err == nil {
+		t.Error("Expected error for section that isn't a list, got nil")
+	}
+
+	v, err := readConfigLayer("test/assets/backupConfigs/scalarSection.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSection(v, "scalarSection", "storage"); err == nil || err.Error() != "invalid section: storage (must be a list of entries)" {
+		t.Errorf("Incorrect error for storage section, got %v", err)
+	}
+	if _, err := readSection(v, "scalarSection", "check"); err == nil || err.Error() != "invalid section: check.0 (must be a map of settings)" {
+		t.Errorf("Incorrect error for check section, got %v", err)
+	}
+}
+
+func TestValidConfig_Timeouts(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("timeouts")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err != nil {
+		t.Error(err)
+	}
+
+	if configFile.maxRuntime != 12*time.Hour {
+		t.Errorf("maxRuntime is incorrect, got '%s', expected '%s'", configFile.maxRuntime, 12*time.Hour)
+	}
+	if configFile.copyInfo[0].Timeout != 90*time.Minute {
+		t.Errorf("copy timeout is incorrect, got '%s', expected '%s'", configFile.copyInfo[0].Timeout, 90*time.Minute)
+	}
+}
+
+func TestInvalidConfig_Timeout(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("invalidTimeout")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err == nil {
+		t.Error("Invalid timeout error should have been returned")
+	}
+}
+
+func TestValidConfig_ContinueOnError(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("continueOnError")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err != nil {
+		t.Error(err)
+	}
+
+	if !configFile.continueOnError {
+		t.Error("continueOnError should be set from repository configuration")
+	}
+	if !continueOnError(configFile.backupInfo[0]) {
+		t.Error("continueOnError should be inherited from repository configuration")
+	}
+	if continueOnError(configFile.backupInfo[1]) {
+		t.Error("continueOnError should be overridden by storage configuration")
+	}
+}
+
+func TestValidConfig_Parallel(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("parallel")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err != nil {
+		t.Error(err)
+	}
+
+	if configFile.parallel != 2 {
+		t.Errorf("parallel is incorrect, got %d, expected %d", configFile.parallel, 2)
+	}
+}
+
+func TestValidConfig_Hooks(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("hooks")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err != nil {
+		t.Error(err)
+	}
+
+	expected := map[string]map[string]string{
+		"preJob":    {"command": "docker stop database", "onFailure": hookAbort},
+		"postJob":   {"command": "docker start database", "onFailure": hookWarn},
+		"preBackup": {"command": "./dump-database.sh", "directory": "/var/backups", "timeout": "10m", "onFailure": hookAbort},
+	}
+	if !reflect.DeepEqual(configFile.hooks, expected) {
+		t.Errorf("Hooks are incorrect, got %v, expected %v", configFile.hooks, expected)
+	}
+}
+
+func TestInvalidConfig_Hook(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("invalidHook")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err == nil {
+		t.Error("Expected error for invalid onFailure value, got nil")
+	}
+}
+
+func TestValidConfig_Credentials(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("credentials")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err != nil {
+		t.Error(err)
+	}
+
+	expected := map[string]map[string]string{
+		"b2": {
+			"password": "env:B2_PASSWORD",
+			"b2_id":    "file:/root/.duplicacy/b2-id",
+			"b2_key":   "cmd:pass show duplicacy/b2-key",
+		},
+		"azure": {"password": "cmd:secret-tool lookup duplicacy azure"},
+	}
+	if !reflect.DeepEqual(configFile.credentials, expected) {
+		t.Errorf("Credentials are incorrect, got %v, expected %v", configFile.credentials, expected)
+	}
+}
+
+func TestInvalidConfig_Credentials(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("invalidCredentials")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err == nil {
+		t.Error("Expected error for credentials of unknown storage, got nil")
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -37,9 +38,19 @@ var (
 	// Number of log files to retain
 	globalLogFileCount int
 
+	// Continue with remaining operations if an operation fails?
+	globalContinueOnError bool
+
+	// Maximum number of backup/copy operations to run at the same time
+	globalMaxParallel int
+
+	// Age after which a checkpoint left by a failed run is ignored
+	globalCheckpointMaxAge = defaultCheckpointMaxAge
+
 	// Notification publishers
 	onStartNotifiers   []Notifier
 	onSkipNotifiers    []Notifier
+	onDeferNotifiers   []Notifier
 	onSuccessNotifiers []Notifier
 	onFailureNotifiers []Notifier
 )
@@ -57,8 +68,10 @@ func loadGlobalConfig(storageDir string, cfgFile string) error {
 	// Validate global environment variables
 	//
 
-	// Don't validate path when running unit tests (invalid path is okay for testing)
-	if _, err = exec.LookPath(duplicacyPath); err != nil && !runningUnitTests {
+	// Don't validate path when running unit tests or simulating duplicacy
+	// (invalid path is okay for testing), for -doctor (which reports it), or
+	// for -show-config and -migrate-config (which don't run duplicacy)
+	if _, err = exec.LookPath(duplicacyPath); err != nil && !runningUnitTests && cmdSimulate == "" && !cmdDoctor && !cmdShowConfig && !cmdMigrateConfig {
 		return err
 	}
 
@@ -99,8 +112,16 @@ func setGlobalConfigVariables(storageDir string, cfgFile string) error {
 	globalLockDir = storageDir
 	globalLogDir = filepath.Join(storageDir, "log")
 	globalLogFileCount = 5
+	globalContinueOnError = false
+	globalMaxParallel = 1
+	globalCheckpointMaxAge = defaultCheckpointMaxAge
+	allConfigsOrder = []string{}
+	allConfigsParallel = 1
+	allConfigsNotifications = allConfigsNotifyBoth
+	globalSchedules = nil
 	onStartNotifiers = []Notifier{}
 	onSkipNotifiers = []Notifier{}
+	onDeferNotifiers = []Notifier{}
 	onSuccessNotifiers = []Notifier{}
 	onFailureNotifiers = []Notifier{}
 
@@ -120,6 +141,26 @@ func setGlobalConfigVariables(storageDir string, cfgFile string) error {
 
 	logMessage(nil, fmt.Sprint("Using global config: ", viper.ConfigFileUsed()))
 
+	// Expand variables (like "${HOME}") in any setting
+	if errs := expandConfigVariables(viper.GetViper()); len(errs) != 0 {
+		for _, err := range errs {
+			logError(nil, fmt.Sprint("Error: ", err))
+		}
+		return errors.New("unable to expand variables in global configuration")
+	}
+
+	// Resolve secret references (like "env:SMTP_PASSWORD") in any setting
+	if err := resolveConfigSecrets(viper.GetViper()); err != nil {
+		return err
+	}
+
+	// E-Mail settings from v1.4 and before are no longer used
+	for _, setting := range oldEmailSettings {
+		if viper.IsSet(setting.old) && !cmdMigrateConfig {
+			logError(nil, fmt.Sprintf("Warning: %s is no longer used (use email.%s, or migrate with -migrate-config)", setting.old, setting.new))
+		}
+	}
+
 	if configStr := viper.GetString("duplicacypath"); configStr != "" {
 		duplicacyPath = configStr
 	}
@@ -136,6 +177,58 @@ func setGlobalConfigVariables(storageDir string, cfgFile string) error {
 		globalLogFileCount = configInt
 	}
 
+	globalContinueOnError = viper.GetBool("continueonerror")
+
+	if configInt := viper.GetInt("maxparallel"); configInt > 0 {
+		globalMaxParallel = configInt
+	}
+
+	if configStr := viper.GetString("checkpointmaxage"); configStr != "" {
+		maxAge, err := time.ParseDuration(configStr)
+		if err != nil || maxAge <= 0 {
+			return fmt.Errorf("Invalid checkpointMaxAge \"%s\" (must be a duration, like 24h)", configStr)
+		}
+		globalCheckpointMaxAge = maxAge
+	}
+
+	// Settings for -all-configs
+	allConfigsOrder = viper.GetStringSlice("allConfigs.order")
+	if configInt := viper.GetInt("allConfigs.parallel"); configInt > 0 {
+		allConfigsParallel = configInt
+	}
+	if configStr := viper.GetString("allConfigs.notifications"); configStr != "" {
+		switch configStr {
+		case allConfigsNotifyBoth, allConfigsNotifyEach, allConfigsNotifySummary:
+			allConfigsNotifications = configStr
+		default:
+			return fmt.Errorf("Invalid allConfigs.notifications \"%s\" (must be %s, %s or %s)", configStr,
+				allConfigsNotifyBoth, allConfigsNotifyEach, allConfigsNotifySummary)
+		}
+	}
+
+	// Schedule for -daemon (entries name the repository configuration to run)
+	entries, sectionErr := readSection(viper.GetViper(), viper.ConfigFileUsed(), "schedule")
+	if sectionErr != nil {
+		logError(nil, fmt.Sprint("Error: ", sectionErr))
+		return errors.New("invalid schedule in global configuration")
+	}
+	for i, raw := range entries {
+		var schedule globalScheduleConfig
+		if errs := decodeSettings(fmt.Sprint("schedule.", i), raw, &schedule); len(errs) != 0 {
+			for _, err := range errs {
+				logError(nil, fmt.Sprint("Error: ", err))
+			}
+			return errors.New("invalid schedule in global configuration")
+		}
+		globalSchedules = append(globalSchedules, schedule)
+	}
+
+	// Notifications aren't sent when migrating configuration files (and the
+	// E-Mail settings may need to be migrated before they can be)
+	if cmdMigrateConfig {
+		return nil
+	}
+
 	var err error
 	// Configure notifiers for onStart notification
 	if configSlice := viper.GetStringSlice("notifications.onStart"); len(configSlice) > 0 {
@@ -153,6 +246,14 @@ func setGlobalConfigVariables(storageDir string, cfgFile string) error {
 		}
 	}
 
+	// Configure notifiers for onDefer notification
+	if configSlice := viper.GetStringSlice("notifications.onDefer"); len(configSlice) > 0 {
+		onDeferNotifiers, err = configureNotificationChannel(configSlice, "onDefer")
+		if err != nil {
+			return err
+		}
+	}
+
 	// Configure notifiers for onSuccess notification
 	if configSlice := viper.GetStringSlice("notifications.onSuccess"); len(configSlice) > 0 {
 		onSuccessNotifiers, err = configureNotificationChannel(configSlice, "onSuccess")
@@ -169,7 +270,7 @@ func setGlobalConfigVariables(storageDir string, cfgFile string) error {
 		}
 	}
 
-	if testNotificationsFlag && len(onStartNotifiers) == 0 && len(onSkipNotifiers) == 0 && len(onSuccessNotifiers) == 0 && len(onFailureNotifiers) == 0 {
+	if testNotificationsFlag && len(onStartNotifiers) == 0 && len(onSkipNotifiers) == 0 && len(onDeferNotifiers) == 0 && len(onSuccessNotifiers) == 0 && len(onFailureNotifiers) == 0 {
 		return errors.New("No notifiers are configured: Testing notifiers is not valid")
 	}
 
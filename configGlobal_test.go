@@ -237,3 +237,89 @@ func TestSendMailWithEnvioronmentPassword(t *testing.T) {
 		t.Errorf("email.authPassword should be '%s', but instead is '%s'", "xyzzy", emailAuthPassword)
 	}
 }
+
+func TestSendMailWithSecretReferences(t *testing.T) {
+	quietFlag = true
+	runningUnitTests = true
+	os.Unsetenv("DU_EMAIL_AUTH_PASSWORD")
+	os.Setenv("DU_TEST_SMTP_USERNAME", "donald.xyzzy@gmail.com")
+	os.Setenv("DU_TEST_SMTP_PASSWORD", "plugh")
+	defer func() {
+		quietFlag = false
+		runningUnitTests = false
+		os.Unsetenv("DU_TEST_SMTP_USERNAME")
+		os.Unsetenv("DU_TEST_SMTP_PASSWORD")
+	}()
+
+	err := loadGlobalConfig(".", "test/assets/globalConfigs/secretsConfig.yml")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Secret references are resolved without disturbing other settings
+	if emailAuthUsername != "donald.xyzzy@gmail.com" || emailAuthPassword != "plugh" {
+		t.Errorf("email credentials were not resolved, got '%s' and '%s'", emailAuthUsername, emailAuthPassword)
+	}
+	if emailServerHostname != "smtp.gmail.com" || emailServerPort != 465 {
+		t.Errorf("email settings were lost, got '%s' and %d", emailServerHostname, emailServerPort)
+	}
+
+	// An unresolvable secret is an error
+	os.Unsetenv("DU_TEST_SMTP_PASSWORD")
+	if err := loadGlobalConfig(".", "test/assets/globalConfigs/secretsConfig.yml"); err == nil {
+		t.Error("Unresolved secret error should have been returned")
+	}
+}
+
+func TestAllConfigsSettings(t *testing.T) {
+	quietFlag = true
+	runningUnitTests = true
+	defer func() {
+		quietFlag = false
+		runningUnitTests = false
+	}()
+
+	err := loadGlobalConfig(".", "test/assets/globalConfigs/allConfigsConfig.yml")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(allConfigsOrder) != 2 || allConfigsOrder[0] != "quicken" || allConfigsParallel != 3 || allConfigsNotifications != allConfigsNotifySummary {
+		t.Errorf("allConfigs settings were incorrect, got %v, %d and '%s'", allConfigsOrder, allConfigsParallel, allConfigsNotifications)
+	}
+
+	// Defaults are restored for a configuration without allConfigs settings
+	if err := loadGlobalConfig(".", "test/assets/globalConfigs/fullValidConfig.yml"); err != nil {
+		t.Error(err)
+	}
+	if len(allConfigsOrder) != 0 || allConfigsParallel != 1 || allConfigsNotifications != allConfigsNotifyBoth {
+		t.Errorf("allConfigs defaults were incorrect, got %v, %d and '%s'", allConfigsOrder, allConfigsParallel, allConfigsNotifications)
+	}
+}
+
+func TestGlobalSchedule(t *testing.T) {
+	quietFlag = true
+	runningUnitTests = true
+	defer func() {
+		quietFlag = false
+		runningUnitTests = false
+	}()
+
+	if err := loadGlobalConfig(".", "test/assets/globalConfigs/scheduleConfig.yml"); err != nil {
+		t.Fatal(err)
+	}
+	if len(globalSchedules) != 2 {
+		t.Fatalf("Incorrect number of schedule entries, got %d, expected 2", len(globalSchedules))
+	}
+	if schedule := globalSchedules[0]; schedule.Config != "documents" || schedule.Cron.String() != "0 2 * * *" || len(schedule.Operations) != 2 {
+		t.Errorf("Incorrect schedule entry, got %s %s %v", schedule.Config, schedule.Cron, schedule.Operations)
+	}
+	if schedule := globalSchedules[1]; schedule.Config != "photos" || schedule.Cron.String() != "@weekly" || schedule.Operations[1] != "check" {
+		t.Errorf("Incorrect schedule entry, got %s %s %v", schedule.Config, schedule.Cron, schedule.Operations)
+	}
+
+	// Schedule entries are validated
+	if err := loadGlobalConfig(".", "test/assets/globalConfigs/invalidScheduleConfig.yml"); err == nil {
+		t.Error("Invalid schedule error should have been returned")
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Look up the name of this host (for host specific override files); replaced
+// when running unit tests
+var hostname = os.Hostname
+
+// A layer of the repository configuration: files included by the configuration
+// file (include:), the configuration file itself, and the override file for this
+// host (like "quicken.myhost.yaml"). Later layers override earlier ones.
+type configLayer struct {
+	filename string
+	settings *viper.Viper
+}
+
+// Setting of the effective (merged) configuration, and where it came from
+type effectiveSetting struct {
+	name   string
+	value  string
+	source string
+}
+
+// Read the layers of a repository configuration, given the configuration file
+// itself (already read)
+func readConfigLayers(v *viper.Viper) ([]configLayer, error) {
+	layers, err := includeLayers(v.ConfigFileUsed(), v, []string{v.ConfigFileUsed()})
+	if err != nil {
+		return nil, err
+	}
+
+	if override := hostOverrideFile(v.ConfigFileUsed()); override != "" {
+		settings, err := readConfigLayer(override)
+		if err != nil {
+			return nil, err
+		}
+		overrideLayers, err := includeLayers(override, settings, []string{override})
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, overrideLayers...)
+	}
+
+	return layers, nil
+}
+
+// Layers for a configuration file: the files it includes (and the files they
+// include), followed by the file itself. Included files are relative to the
+// file that includes them, and may refer to variables. Chain is used to detect
+// files that include themselves.
+func includeLayers(filename string, settings *viper.Viper, chain []string) ([]configLayer, error) {
+	layers := []configLayer{}
+	for _, include := range settings.GetStringSlice("include") {
+		include, err := expandVariables(include, lookupVariable)
+		if err != nil {
+			return nil, fmt.Errorf("%s (in include of %s)", err, filename)
+		}
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filename), include)
+		}
+		if stringInSlice(include, chain) {
+			return nil, fmt.Errorf("configuration file %s includes itself (through %s)", include, filename)
+		}
+
+		included, err := readConfigLayer(include)
+		if err != nil {
+			return nil, fmt.Errorf("%s (included by %s)", err, filename)
+		}
+		includedLayers, err := includeLayers(include, included, append(chain, include))
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, includedLayers...)
+	}
+
+	return append(layers, configLayer{filename: filename, settings: settings}), nil
+}
+
+func readConfigLayer(filename string) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigFile(filename)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Names of this host used in override files (like "myhost.example.com" and "myhost")
+func hostOverrideNames() []string {
+	host, err := hostname()
+	if err != nil || host == "" {
+		return nil
+	}
+
+	return []string{host, strings.SplitN(host, ".", 2)[0]}
+}
+
+// Override file for this host, if any: for configuration file "quicken.yaml" on
+// host "myhost.example.com", this is "quicken.myhost.example.com.<ext>" or
+// "quicken.myhost.<ext>" (in the same directory), with any supported extension
+func hostOverrideFile(filename string) string {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	for _, name := range hostOverrideNames() {
+		for _, extension := range viper.SupportedExts {
+			override := base + "." + name + "." + extension
+			if _, err := os.Stat(override); err == nil {
+				return override
+			}
+		}
+	}
+
+	return ""
+}
+
+// Merge layers of the configuration (later layers override earlier ones). Maps
+// (like hooks and defaults) are merged; other values (including sections, which
+// are lists) are replaced.
+func mergeConfigLayers(layers []configLayer) (*viper.Viper, error) {
+	merged := viper.New()
+	for _, layer := range layers {
+		if err := merged.MergeConfigMap(layer.settings.AllSettings()); err != nil {
+			return nil, err
+		}
+	}
+	merged.AutomaticEnv()
+
+	return merged, nil
+}
+
+// File that each setting came from, keyed by the (lower case) name of the setting.
+// Names of enclosing maps are included (like "storage" for "storage.1.name"), and
+// refer to the last layer that set anything in them.
+func settingSources(layers []configLayer) map[string]string {
+	sources := map[string]string{}
+	for _, layer := range layers {
+		for _, key := range layer.settings.AllKeys() {
+			parts := strings.Split(key, ".")
+			for i := range parts {
+				sources[strings.Join(parts[:i+1], ".")] = filepath.Base(layer.filename)
+			}
+		}
+	}
+
+	return sources
+}
+
+// Settings in the defaults for a section (like "defaults.storage"), validating
+// that each setting is known for the section
+func sectionDefaults(v *viper.Viper, section string, t reflect.Type) (map[string]string, []error) {
+	defaults := map[string]string{}
+	errs := []error{}
+	settings := settingsOf(t)
+	for key, value := range v.GetStringMap("defaults." + section) {
+		if _, ok := findSetting(settings, key); !ok {
+			errs = append(errs, fmt.Errorf("unknown setting: %s%s", settingPath("defaults."+section, key), suggestSetting(settings, key)))
+			continue
+		}
+		defaults[key] = coerceToString(value)
+	}
+
+	return defaults, errs
+}
+
+// Validate the sections that defaults are given for
+func validateDefaults(v *viper.Viper) []error {
+	sections := settingSchemas([]string{"storage", "copy", "prune", "check"})
+
+	errs := []error{}
+	for key := range v.GetStringMap("defaults") {
+		if _, ok := findSetting(sections, key); !ok {
+			errs = append(errs, fmt.Errorf("unknown setting: defaults.%s%s", key, suggestSetting(sections, key)))
+		}
+	}
+
+	return errs
+}
+
+// Apply defaults to an entry of a section. Returns the settings that were taken
+// from the defaults.
+func applyDefaults(raw map[string]string, defaults map[string]string) map[string]bool {
+	specified := map[string]bool{}
+	for key := range raw {
+		specified[strings.ToLower(key)] = true
+	}
+
+	applied := map[string]bool{}
+	for key, value := range defaults {
+		if !specified[strings.ToLower(key)] {
+			raw[key] = value
+			applied[key] = true
+		}
+	}
+
+	return applied
+}
+
+// Record the effective settings of the top level (location "") or an entry of a
+// section (like "storage.0"), including defaults from the schema, for -show-config
+func effectiveSettings(location string, raw map[string]string, t reflect.Type, source func(key string) string) []effectiveSetting {
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	settings := settingsOf(t)
+	effective := []effectiveSetting{}
+	for _, key := range keys {
+		name := key
+		if setting, ok := findSetting(settings, key); ok {
+			name = setting.name
+		}
+		effective = append(effective, effectiveSetting{name: settingPath(location, name), value: raw[key], source: source(key)})
+	}
+
+	specified := settingSchemas(keys)
+	for _, setting := range settings {
+		if _, ok := findSetting(specified, setting.name); !ok && setting.defaultValue != "" {
+			effective = append(effective, effectiveSetting{name: settingPath(location, setting.name), value: setting.defaultValue, source: "(default)"})
+		}
+	}
+
+	return effective
+}
+
+// Settings (by name only), to look up names with findSetting
+func settingSchemas(names []string) []settingSchema {
+	settings := make([]settingSchema, len(names))
+	for i, name := range names {
+		settings[i] = settingSchema{name: name}
+	}
+
+	return settings
+}
+
+// Show the effective configuration, after merging all layers, and where each
+// setting came from (-show-config)
+func performShowConfig(w io.Writer) int {
+	fmt.Fprintln(w, "Configuration files (later files override earlier ones):")
+	for _, filename := range configFile.layerFiles {
+		fmt.Fprintln(w, "  "+filename)
+	}
+	fmt.Fprintln(w)
+
+	nameWidth, valueWidth := len("Setting"), len("Value")
+	for _, setting := range configFile.effective {
+		if len(setting.name) > nameWidth {
+			nameWidth = len(setting.name)
+		}
+		if value := redactSecrets(setting.value); len(value) > valueWidth {
+			valueWidth = len(value)
+		}
+	}
+
+	fmt.Fprintf(w, "%-*s  %-*s  %s\n", nameWidth, "Setting", valueWidth, "Value", "Source")
+	fmt.Fprintf(w, "%s  %s  %s\n", strings.Repeat("-", nameWidth), strings.Repeat("-", valueWidth), strings.Repeat("-", 6))
+	for _, setting := range configFile.effective {
+		fmt.Fprintf(w, "%-*s  %-*s  %s\n", nameWidth, setting.name, valueWidth, redactSecrets(setting.value), setting.source)
+	}
+
+	return 0
+}
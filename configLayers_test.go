@@ -0,0 +1,159 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Load a configuration (from test assets) as if running on the specified host
+func loadLayeredConfig(name string, host string) error {
+	quietFlag = true
+	hostname = func() (string, error) { return host, nil }
+	defer func() {
+		quietFlag = false
+		hostname = os.Hostname
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig(name)
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	return configFile.loadConfig(false, false)
+}
+
+func TestLayeredConfig(t *testing.T) {
+	if err := loadLayeredConfig("layered", "otherhost"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries inherit settings from the defaults (in the included file)
+	backupInfo := []backupConfig{
+		{Name: "b2", Threads: 4, operationConfig: defaultSettings},
+		{Name: "azure-direct", Threads: 8, operationConfig: defaultSettings},
+	}
+	if !reflect.DeepEqual(backupInfo, configFile.backupInfo) {
+		t.Error("backupInfo should have been equal, expected:", backupInfo, ", received:", configFile.backupInfo)
+	}
+	pruneInfo := []pruneConfig{
+		{Storage: "b2", Keep: []string{"0:365", "30:180", "7:30", "1:7"}, All: true, operationConfig: defaultSettings},
+	}
+	if !reflect.DeepEqual(pruneInfo, configFile.pruneInfo) {
+		t.Error("pruneInfo should have been equal, expected:", pruneInfo, ", received:", configFile.pruneInfo)
+	}
+	checkInfo := []checkConfig{
+		{Storage: "b2", All: true, operationConfig: defaultSettings},
+		{Storage: "azure", operationConfig: defaultSettings},
+	}
+	if !reflect.DeepEqual(checkInfo, configFile.checkInfo) {
+		t.Error("checkInfo should have been equal, expected:", checkInfo, ", received:", configFile.checkInfo)
+	}
+	if configFile.parallel != 2 {
+		t.Errorf("parallel was incorrect, got %d, expected 2", configFile.parallel)
+	}
+	if len(configFile.layerFiles) != 2 {
+		t.Errorf("configuration files were incorrect, got %v", configFile.layerFiles)
+	}
+}
+
+func TestLayeredConfig_HostOverride(t *testing.T) {
+	if err := loadLayeredConfig("layered", "testhost.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Override file for the host is merged on top (defaults are merged, not replaced)
+	if configFile.parallel != 3 {
+		t.Errorf("parallel was incorrect, got %d, expected 3", configFile.parallel)
+	}
+	if configFile.backupInfo[0].Threads != 2 || configFile.backupInfo[1].Threads != 8 {
+		t.Errorf("threads were incorrect, got %v", configFile.backupInfo)
+	}
+	if !configFile.checkInfo[0].All {
+		t.Errorf("defaults for check were lost, got %v", configFile.checkInfo)
+	}
+	if len(configFile.layerFiles) != 3 || !strings.HasSuffix(configFile.layerFiles[2], "layered.testhost.yml") {
+		t.Errorf("configuration files were incorrect, got %v", configFile.layerFiles)
+	}
+}
+
+func TestLayeredConfig_IncludeLoop(t *testing.T) {
+	err := loadLayeredConfig("includeLoop", "otherhost")
+	if err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Errorf("Expected include loop error, got %v", err)
+	}
+}
+
+func TestLayeredConfig_InvalidDefaults(t *testing.T) {
+	if err := loadLayeredConfig("invalidDefaults", "otherhost"); err == nil {
+		t.Error("Invalid defaults should have been reported")
+	}
+
+	v, err := readConfigLayer("test/assets/backupConfigs/invalidDefaults.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := validateDefaults(v)
+	if len(errs) != 1 || errs[0].Error() != "unknown setting: defaults.prnue (did you mean prune?)" {
+		t.Errorf("Incorrect errors for invalid sections, got %v", errs)
+	}
+	if _, errs := sectionDefaults(v, "storage", reflect.TypeOf(backupConfig{})); len(errs) != 1 || errs[0].Error() != "unknown setting: defaults.storage.thread (did you mean threads?)" {
+		t.Errorf("Incorrect errors for invalid defaults, got %v", errs)
+	}
+}
+
+func TestShowConfig(t *testing.T) {
+	if err := loadLayeredConfig("layered", "testhost"); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	if status := performShowConfig(&output); status != 0 {
+		t.Errorf("Expected exit status 0, got %d", status)
+	}
+
+	sources := map[string]string{}
+	lines := strings.Split(output.String(), "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "Setting ") {
+			continue
+		}
+		column := strings.Index(line, "Source")
+		for _, setting := range lines[i+2 : len(lines)-1] {
+			sources[strings.Fields(setting)[0]] = strings.TrimSpace(setting[column:])
+		}
+	}
+
+	expected := map[string]string{
+		"repository":           "layered.yml",
+		"parallel":             "layered.testhost.yml",
+		"storage.0.threads":    "layered.testhost.yml (defaults)",
+		"storage.1.threads":    "layered.yml",
+		"prune.0.keep":         "common.yml (defaults)",
+		"prune.0.all":          "(default)",
+		"check.1.all":          "layered.yml",
+		"storage.0.retryDelay": "(default)",
+	}
+	for name, source := range expected {
+		if sources[name] != source {
+			t.Errorf("Incorrect source for %s, got '%s', expected '%s'", name, sources[name], source)
+		}
+	}
+	if t.Failed() {
+		t.Log(output.String())
+	}
+}
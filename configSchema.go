@@ -0,0 +1,560 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings in the configuration are described by struct tags on the typed
+// configuration (see backupConfig and friends):
+//
+//	config:"threads"       Name of the setting in the configuration file
+//	required:"true"        The setting must be specified
+//	default:"1m"           Value if the setting isn't specified
+//	minimum:"1"            Smallest valid value (integers, numbers, and durations)
+//	pattern:"[0-9]+:[0-9]+" Valid values (strings, and each item of lists)
+//	description:"..."      Description of the setting (for the JSON Schema)
+//
+// The same descriptions are used to decode (and validate) settings, and to
+// generate the JSON Schema for configuration files (-print-schema).
+
+// Kinds of settings (determined by the type of the field)
+const (
+	kindString   = "string"
+	kindInteger  = "integer"
+	kindNumber   = "number"
+	kindBoolean  = "boolean"
+	kindDuration = "duration"
+	kindList     = "list"
+	kindRegexp   = "regexp"
+	kindCron     = "cron"
+	kindWindow   = "window"
+	kindRate     = "rate"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	regexpType   = reflect.TypeOf((*regexp.Regexp)(nil))
+	cronType     = reflect.TypeOf((*cronSchedule)(nil))
+	windowType   = reflect.TypeOf((*maintenanceWindow)(nil))
+	rateType     = reflect.TypeOf((*rateSchedule)(nil))
+)
+
+type settingSchema struct {
+	name         string
+	kind         string
+	required     bool
+	defaultValue string
+	minimum      string
+	pattern      string
+	description  string
+	index        []int // Index of the field (see reflect.Value.FieldByIndex)
+}
+
+// Settings of a typed configuration struct (including embedded structs)
+func settingsOf(t reflect.Type) []settingSchema {
+	settings := []settingSchema{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			for _, setting := range settingsOf(field.Type) {
+				setting.index = append([]int{i}, setting.index...)
+				settings = append(settings, setting)
+			}
+			continue
+		}
+
+		name := field.Tag.Get("config")
+		if name == "" {
+			continue
+		}
+		settings = append(settings, settingSchema{
+			name:         name,
+			kind:         settingKind(field.Type),
+			required:     field.Tag.Get("required") == "true",
+			defaultValue: field.Tag.Get("default"),
+			minimum:      field.Tag.Get("minimum"),
+			pattern:      field.Tag.Get("pattern"),
+			description:  field.Tag.Get("description"),
+			index:        []int{i},
+		})
+	}
+
+	return settings
+}
+
+func settingKind(t reflect.Type) string {
+	switch {
+	case t == durationType:
+		return kindDuration
+	case t == regexpType:
+		return kindRegexp
+	case t == cronType:
+		return kindCron
+	case t == windowType:
+		return kindWindow
+	case t == rateType:
+		return kindRate
+	case t.Kind() == reflect.Ptr:
+		return settingKind(t.Elem())
+	case t.Kind() == reflect.Int:
+		return kindInteger
+	case t.Kind() == reflect.Float64:
+		return kindNumber
+	case t.Kind() == reflect.Bool:
+		return kindBoolean
+	case t.Kind() == reflect.Slice:
+		return kindList
+	}
+
+	return kindString
+}
+
+// Decode settings into a typed configuration struct (target must be a pointer to
+// the struct). Location (like "storage.0") identifies an entry in a section, and
+// is empty for settings at the top level. Returns all problems found, like unknown
+// settings or invalid values.
+func decodeSettings(location string, raw map[string]string, target interface{}) []error {
+	value := reflect.ValueOf(target).Elem()
+	settings := settingsOf(value.Type())
+
+	errs := []error{}
+	for _, setting := range settings {
+		if setting.defaultValue != "" {
+			// Defaults are part of our own definitions, so they're always valid
+			setSetting(value.FieldByIndex(setting.index), setting, setting.defaultValue)
+		}
+	}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	specified := map[string]bool{}
+	for _, key := range keys {
+		setting, ok := findSetting(settings, key)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown setting: %s%s", settingPath(location, key), suggestSetting(settings, key)))
+			continue
+		}
+
+		specified[setting.name] = raw[key] != ""
+		if err := setSetting(value.FieldByIndex(setting.index), setting, raw[key]); err != nil {
+			errs = append(errs, fmt.Errorf("invalid setting: %s: %s (%s)", settingPath(location, setting.name), raw[key], err))
+		}
+	}
+
+	for _, setting := range settings {
+		if setting.required && !specified[setting.name] {
+			errs = append(errs, fmt.Errorf("missing mandatory setting: %s", settingPath(location, setting.name)))
+		}
+	}
+
+	return errs
+}
+
+// Full name of a setting (like "storage.0.threads")
+func settingPath(location string, name string) string {
+	if location == "" {
+		return name
+	}
+	return location + "." + name
+}
+
+// Find a setting by name. Viper doesn't preserve case of keys in all cases (like
+// the old numbered format of sections), so names aren't case sensitive.
+func findSetting(settings []settingSchema, key string) (settingSchema, bool) {
+	for _, setting := range settings {
+		if strings.EqualFold(setting.name, key) {
+			return setting, true
+		}
+	}
+
+	return settingSchema{}, false
+}
+
+// Suggestion for an unknown setting (like " (did you mean threads?)"), if one is close
+func suggestSetting(settings []settingSchema, key string) string {
+	best, bestDistance := "", 0
+	for _, setting := range settings {
+		distance := editDistance(strings.ToLower(key), strings.ToLower(setting.name))
+		if best == "" || distance < bestDistance {
+			best, bestDistance = setting.name, distance
+		}
+	}
+
+	if best == "" || bestDistance > 2 || bestDistance >= len(key)/2+1 {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Parse and validate the value of a setting, storing it in the field
+func setSetting(field reflect.Value, setting settingSchema, value string) error {
+	if field.Kind() == reflect.Ptr && field.Type() != regexpType && field.Type() != cronType && field.Type() != windowType && field.Type() != rateType {
+		field.Set(reflect.New(field.Type().Elem()))
+		field = field.Elem()
+	}
+
+	var pattern *regexp.Regexp
+	if setting.pattern != "" {
+		pattern = regexp.MustCompile("^(?:" + setting.pattern + ")$")
+	}
+
+	switch setting.kind {
+	case kindString:
+		if pattern != nil && !pattern.MatchString(value) {
+			return fmt.Errorf("must match %s", setting.pattern)
+		}
+		field.SetString(value)
+
+	case kindInteger:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		if minimum, err := strconv.Atoi(setting.minimum); err == nil && number < minimum {
+			return fmt.Errorf("must be at least %d", minimum)
+		}
+		field.SetInt(int64(number))
+
+	case kindNumber:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		if minimum, err := strconv.ParseFloat(setting.minimum, 64); err == nil && number < minimum {
+			return fmt.Errorf("must be at least %s", setting.minimum)
+		}
+		field.SetFloat(number)
+
+	case kindBoolean:
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		field.SetBool(flag)
+
+	case kindDuration:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration, like 30s, 90m or 6h")
+		}
+		if minimum, err := time.ParseDuration(setting.minimum); err == nil && duration < minimum {
+			return fmt.Errorf("must be at least %s", setting.minimum)
+		}
+		field.SetInt(int64(duration))
+
+	case kindList:
+		items := strings.FieldsFunc(value, isListSeparator)
+		for _, item := range items {
+			if pattern != nil && !pattern.MatchString(item) {
+				return fmt.Errorf("%s must match %s", item, setting.pattern)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+
+	case kindRegexp:
+		expression, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("invalid regular expression: %s", err)
+		}
+		field.Set(reflect.ValueOf(expression))
+
+	case kindCron:
+		schedule, err := parseCron(value)
+		if err != nil {
+			return fmt.Errorf("invalid cron expression: %s", err)
+		}
+		field.Set(reflect.ValueOf(schedule))
+
+	case kindWindow:
+		window, err := parseWindow(value)
+		if err != nil {
+			return fmt.Errorf("invalid maintenance window: %s", err)
+		}
+		field.Set(reflect.ValueOf(window))
+
+	case kindRate:
+		schedule, err := parseRateSchedule(value)
+		if err != nil {
+			return fmt.Errorf("invalid rate limit: %s", err)
+		}
+		field.Set(reflect.ValueOf(schedule))
+	}
+
+	return nil
+}
+
+// Settings of each entry in a section (for debug output), like "[{name=b2 threads=4}]".
+// Only settings with a value other than the zero value are shown.
+func formatSection(section interface{}) string {
+	entries := reflect.ValueOf(section)
+	formatted := make([]string, entries.Len())
+	for i := range formatted {
+		entry := entries.Index(i)
+		values := []string{}
+		for _, setting := range settingsOf(entry.Type()) {
+			field := entry.FieldByIndex(setting.index)
+			if field.IsZero() {
+				continue
+			}
+			if field.Kind() == reflect.Ptr && field.Type() != regexpType && field.Type() != cronType && field.Type() != windowType && field.Type() != rateType {
+				field = field.Elem()
+			}
+			value := fmt.Sprint(field.Interface())
+			if setting.kind == kindList {
+				value = strings.Join(field.Interface().([]string), " ")
+			}
+			values = append(values, setting.name+"="+value)
+		}
+		formatted[i] = "{" + strings.Join(values, " ") + "}"
+	}
+
+	return "[" + strings.Join(formatted, " ") + "]"
+}
+
+// Warn about settings at the top level of a configuration file that aren't known
+func unknownSettings(keys []string, known []string) []string {
+	settings := settingSchemas(known)
+
+	warnings := []string{}
+	for _, key := range keys {
+		if _, ok := findSetting(settings, key); !ok {
+			warnings = append(warnings, fmt.Sprintf("unknown setting: %s%s", key, suggestSetting(settings, key)))
+		}
+	}
+	sort.Strings(warnings)
+
+	return warnings
+}
+
+// JSON Schema for the settings of a typed configuration struct
+func settingsSchema(t reflect.Type, additional map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, setting := range settingsOf(t) {
+		property := map[string]interface{}{}
+		if setting.description != "" {
+			property["description"] = setting.description
+		}
+
+		switch setting.kind {
+		case kindInteger, kindNumber:
+			property["type"] = setting.kind
+			if minimum, err := strconv.ParseFloat(setting.minimum, 64); err == nil {
+				property["minimum"] = minimum
+			}
+			if value, err := strconv.ParseFloat(setting.defaultValue, 64); err == nil {
+				property["default"] = value
+			}
+		case kindBoolean:
+			property["type"] = kindBoolean
+			if value, err := strconv.ParseBool(setting.defaultValue); err == nil {
+				property["default"] = value
+			}
+		case kindDuration:
+			property["type"] = kindString
+			property["pattern"] = `^([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$`
+			if setting.defaultValue != "" {
+				property["default"] = setting.defaultValue
+			}
+		case kindList:
+			// Lists may be written as a string (items separated by spaces or commas)
+			item := map[string]interface{}{"type": kindString}
+			if setting.pattern != "" {
+				item["pattern"] = "^(" + setting.pattern + ")$"
+			}
+			property["anyOf"] = []interface{}{
+				map[string]interface{}{"type": kindString},
+				map[string]interface{}{"type": "array", "items": item},
+			}
+		case kindRegexp:
+			property["type"] = kindString
+			property["format"] = "regex"
+		case kindCron, kindWindow, kindRate:
+			property["type"] = kindString
+		default:
+			property["type"] = kindString
+			if setting.pattern != "" {
+				property["pattern"] = "^(" + setting.pattern + ")$"
+			}
+		}
+
+		properties[setting.name] = property
+		if setting.required {
+			required = append(required, setting.name)
+		}
+	}
+	for name, property := range additional {
+		properties[name] = property
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+// JSON Schema for repository configuration files
+func repositorySchema() map[string]interface{} {
+	section := func(t reflect.Type, description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"type":        "array",
+			"items":       settingsSchema(t, nil),
+		}
+	}
+
+	hook := map[string]interface{}{
+		"anyOf": []interface{}{
+			map[string]interface{}{"type": "string", "description": "Command line to run"},
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"command":   map[string]interface{}{"type": "string", "description": "Command line to run"},
+					"directory": map[string]interface{}{"type": "string", "description": "Working directory for the command"},
+					"timeout":   map[string]interface{}{"type": "string", "description": "Maximum run time for the command (i.e. 10m)"},
+					"onFailure": map[string]interface{}{"enum": []string{hookAbort, hookWarn, hookIgnore}, "default": hookAbort},
+				},
+				"required":             []string{"command"},
+				"additionalProperties": false,
+			},
+		},
+	}
+	hooks := map[string]interface{}{}
+	for _, name := range hookNames {
+		hooks[name] = hook
+	}
+
+	schema := settingsSchema(reflect.TypeOf(repositoryConfig{}), map[string]interface{}{
+		"storage":  section(reflect.TypeOf(backupConfig{}), "Storages to back up (duplicacy backup)"),
+		"copy":     section(reflect.TypeOf(copyConfig{}), "Storages to copy between (duplicacy copy)"),
+		"prune":    section(reflect.TypeOf(pruneConfig{}), "Storages to prune (duplicacy prune)"),
+		"check":    section(reflect.TypeOf(checkConfig{}), "Storages to check (duplicacy check)"),
+		"schedule": section(reflect.TypeOf(scheduleConfig{}), "When to perform operations (with -daemon)"),
+		"hooks": map[string]interface{}{
+			"description":          "Commands to run before/after the job and each operation",
+			"type":                 "object",
+			"properties":           hooks,
+			"additionalProperties": false,
+		},
+		"include": map[string]interface{}{
+			"description": "Files with settings shared by configurations (relative to this file)",
+			"anyOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+		},
+		"defaults": map[string]interface{}{
+			"description": "Settings for entries of each section that don't specify them",
+			"type":        "object",
+			"properties": map[string]interface{}{
+				"storage": sectionDefaultsSchema(reflect.TypeOf(backupConfig{})),
+				"copy":    sectionDefaultsSchema(reflect.TypeOf(copyConfig{})),
+				"prune":   sectionDefaultsSchema(reflect.TypeOf(pruneConfig{})),
+				"check":   sectionDefaultsSchema(reflect.TypeOf(checkConfig{})),
+			},
+			"additionalProperties": false,
+		},
+		"credentials": map[string]interface{}{
+			"description": "Credentials (secret references) for each storage",
+			"type":        "object",
+			"additionalProperties": map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": map[string]interface{}{"type": "string"},
+			},
+		},
+	})
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "duplicacy-util repository configuration"
+
+	// Required settings may be in included files instead
+	delete(schema, "required")
+	schema["if"] = map[string]interface{}{"not": map[string]interface{}{"required": []string{"include"}}}
+	schema["then"] = map[string]interface{}{"required": []string{"repository", "storage", "prune", "check"}}
+
+	return schema
+}
+
+// JSON Schema for the defaults of a section (nothing is required)
+func sectionDefaultsSchema(t reflect.Type) map[string]interface{} {
+	schema := settingsSchema(t, nil)
+	delete(schema, "required")
+	return schema
+}
+
+// Write the JSON Schema for repository configuration files (-print-schema)
+func printSchema(w io.Writer) error {
+	schema, err := json.MarshalIndent(repositorySchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(schema))
+	return err
+}
+
+// Items of lists may be separated by spaces or commas
+func isListSeparator(r rune) bool {
+	return r == ' ' || r == ','
+}
@@ -0,0 +1,127 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeSettings(t *testing.T) {
+	var info pruneConfig
+	raw := map[string]string{"storage": "b2", "keep": "0:365, 30:180", "threads": "4", "retryOn": "503"}
+	if errs := decodeSettings("prune.0", raw, &info); len(errs) != 0 {
+		t.Fatalf("Expected no errors, got %v", errs)
+	}
+
+	if info.Storage != "b2" || info.Threads != 4 || !reflect.DeepEqual(info.Keep, []string{"0:365", "30:180"}) {
+		t.Errorf("Incorrect settings, got %+v", info)
+	}
+	if !info.All || info.RetryDelay != time.Minute || info.RetryBackoff != 2 {
+		t.Errorf("Defaults were not applied, got %+v", info)
+	}
+	if info.RetryOn == nil || info.RetryOn.String() != "503" {
+		t.Errorf("Incorrect retryOn, got %v", info.RetryOn)
+	}
+}
+
+func TestDecodeSettings_Errors(t *testing.T) {
+	tests := []struct {
+		raw      map[string]string
+		expected string
+	}{
+		{map[string]string{"name": "b2", "thread": "10"}, "unknown setting: storage.0.thread (did you mean threads?)"},
+		{map[string]string{"name": "b2", "compression": "lz4"}, "unknown setting: storage.0.compression"},
+		{map[string]string{"name": "b2", "threads": "ten"}, "invalid setting: storage.0.threads: ten (must be an integer)"},
+		{map[string]string{"name": "b2", "threads": "0"}, "invalid setting: storage.0.threads: 0 (must be at least 1)"},
+		{map[string]string{"name": "b2", "vss": "yes please"}, "invalid setting: storage.0.vss: yes please (must be true or false)"},
+		{map[string]string{"name": "b2", "timeout": "0s"}, "invalid setting: storage.0.timeout: 0s (must be at least 1s)"},
+		{map[string]string{"name": "b2", "limitRate": "fast"}, "invalid setting: storage.0.limitRate: fast (invalid rate limit: invalid rate fast (like 2048, 512KB/s, 2MB/s, or unlimited))"},
+		{map[string]string{"threads": "4"}, "missing mandatory setting: storage.0.name"},
+	}
+
+	for _, test := range tests {
+		var info backupConfig
+		errs := decodeSettings("storage.0", test.raw, &info)
+		if len(errs) != 1 || errs[0].Error() != test.expected {
+			t.Errorf("Incorrect errors for %v, got %v, expected %q", test.raw, errs, test.expected)
+		}
+	}
+}
+
+func TestDecodeSettings_Pattern(t *testing.T) {
+	var info pruneConfig
+	errs := decodeSettings("prune.1", map[string]string{"storage": "b2", "keep": "0:365 30"}, &info)
+	expected := "invalid setting: prune.1.keep: 0:365 30 (30 must match [0-9]+:[0-9]+)"
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("Incorrect errors, got %v, expected %q", errs, expected)
+	}
+}
+
+func TestUnknownSettings(t *testing.T) {
+	warnings := unknownSettings([]string{"repository", "storage", "maxruntime", "prunes", "notifications"}, []string{"repository", "maxRuntime", "storage", "prune"})
+	expected := []string{
+		"unknown setting: notifications",
+		"unknown setting: prunes (did you mean prune?)",
+	}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Incorrect warnings, got %v, expected %v", warnings, expected)
+	}
+}
+
+func TestFormatSection(t *testing.T) {
+	section := []backupConfig{{Name: "b2", Threads: 4}, {Name: "azure", VSS: true, operationConfig: operationConfig{After: []string{"backup:b2"}}}}
+	expected := "[{name=b2 threads=4} {name=azure vss=true after=backup:b2}]"
+	if formatted := formatSection(section); formatted != expected {
+		t.Errorf("Incorrect formatted section, got %s, expected %s", formatted, expected)
+	}
+}
+
+func TestPrintSchema(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := printSchema(&buffer); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	var schema struct {
+		Then struct {
+			Required []string
+		}
+		Properties map[string]struct {
+			Items struct {
+				Required   []string
+				Properties map[string]map[string]interface{}
+			}
+		}
+	}
+	if err := json.Unmarshal(buffer.Bytes(), &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+
+	if strings.Join(schema.Then.Required, " ") != "repository storage prune check" {
+		t.Errorf("Incorrect required settings, got %v", schema.Then.Required)
+	}
+	storage := schema.Properties["storage"].Items
+	if strings.Join(storage.Required, " ") != "name" {
+		t.Errorf("Incorrect required storage settings, got %v", storage.Required)
+	}
+	if threads := storage.Properties["threads"]; threads["type"] != "integer" || threads["minimum"] != 1.0 {
+		t.Errorf("Incorrect schema for threads, got %v", threads)
+	}
+}
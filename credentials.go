@@ -0,0 +1,134 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	// Environment variables with resolved credentials (keyed by storage name).
+	// Secrets are resolved when first needed, and only once per job.
+	resolvedCredentials = map[string][]string{}
+	credentialsMutex    sync.Mutex
+)
+
+// Read the "credentials" section of the repository configuration: for each
+// storage, a map of duplicacy credential names (like "password" or "b2_key")
+// to secret references (see resolveSecret).
+func readCredentials(v *viper.Viper, config *configurationFile) (map[string]map[string]string, error) {
+	credentials := map[string]map[string]string{}
+
+	// Storages referenced by the configuration (viper doesn't preserve case of keys)
+	storages := map[string]string{}
+	for _, info := range config.backupInfo {
+		storages[strings.ToLower(info.Name)] = info.Name
+	}
+	for _, info := range config.copyInfo {
+		storages[strings.ToLower(info.From)] = info.From
+		storages[strings.ToLower(info.To)] = info.To
+	}
+	for _, info := range config.pruneInfo {
+		storages[strings.ToLower(info.Storage)] = info.Storage
+	}
+	for _, info := range config.checkInfo {
+		storages[strings.ToLower(info.Storage)] = info.Storage
+	}
+
+	for key := range v.GetStringMap("credentials") {
+		storage, ok := storages[strings.ToLower(key)]
+		if !ok {
+			return nil, fmt.Errorf("credentials defined for unknown storage: %s", key)
+		}
+
+		secrets := v.GetStringMapString("credentials." + key)
+		if len(secrets) == 0 {
+			return nil, fmt.Errorf("no credentials defined for storage: %s", storage)
+		}
+		credentials[storage] = secrets
+	}
+
+	return credentials, nil
+}
+
+// Name of the environment variable duplicacy reads for a credential of a storage
+// (i.e. DUPLICACY_B2_PASSWORD). Duplicacy doesn't use the storage name for the
+// default storage (i.e. DUPLICACY_PASSWORD).
+func credentialVariable(storage string, key string) string {
+	normalize := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return '_'
+		}, strings.ToUpper(s))
+	}
+
+	if storage == "default" {
+		return "DUPLICACY_" + normalize(key)
+	}
+
+	return "DUPLICACY_" + normalize(storage) + "_" + normalize(key)
+}
+
+// Forget resolved credentials (so they are resolved again for the next job)
+func resetCredentials() {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	resolvedCredentials = map[string][]string{}
+}
+
+// Environment variables with credentials for all storages used by an operation
+// (for copy, both the "from" and "to" storages)
+func credentialEnvironment(info operationInfo) ([]string, error) {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	env := []string{}
+	for _, storage := range operationStorages(info) {
+		secrets, ok := configFile.credentials[storage]
+		if !ok {
+			continue
+		}
+
+		if _, ok := resolvedCredentials[storage]; !ok {
+			keys := make([]string, 0, len(secrets))
+			for key := range secrets {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			variables := []string{}
+			for _, key := range keys {
+				value, err := resolveSecret(secrets[key])
+				if err != nil {
+					return nil, fmt.Errorf("unable to resolve %s for storage %s: %s", key, storage, err)
+				}
+				variables = append(variables, credentialVariable(storage, key)+"="+value)
+			}
+			resolvedCredentials[storage] = variables
+		}
+
+		env = append(env, resolvedCredentials[storage]...)
+	}
+
+	return env, nil
+}
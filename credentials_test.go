@@ -0,0 +1,99 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCredentialVariable(t *testing.T) {
+	tests := []struct {
+		storage  string
+		key      string
+		expected string
+	}{
+		{"b2", "password", "DUPLICACY_B2_PASSWORD"},
+		{"b2", "b2_key", "DUPLICACY_B2_B2_KEY"},
+		{"azure-direct", "azure_key", "DUPLICACY_AZURE_DIRECT_AZURE_KEY"},
+		{"default", "password", "DUPLICACY_PASSWORD"},
+	}
+
+	for _, test := range tests {
+		if variable := credentialVariable(test.storage, test.key); variable != test.expected {
+			t.Errorf("Incorrect variable, got %s, expected %s", variable, test.expected)
+		}
+	}
+}
+
+func TestCredentialEnvironment(t *testing.T) {
+	savedConfig := configFile
+	configFile = newConfigurationFile()
+	configFile.credentials = map[string]map[string]string{
+		"b2":    {"password": "env:DU_TEST_B2_PASSWORD", "b2_key": "secret-key"},
+		"azure": {"password": "env:DU_TEST_AZURE_PASSWORD"},
+	}
+	os.Setenv("DU_TEST_B2_PASSWORD", "b2-password")
+	os.Setenv("DU_TEST_AZURE_PASSWORD", "azure-password")
+	resetCredentials()
+	defer func() {
+		configFile = savedConfig
+		os.Unsetenv("DU_TEST_B2_PASSWORD")
+		os.Unsetenv("DU_TEST_AZURE_PASSWORD")
+		resetCredentials()
+	}()
+
+	// Copy needs credentials for both storages
+	env, err := credentialEnvironment(copyConfig{From: "b2", To: "azure"})
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	expected := []string{
+		"DUPLICACY_B2_B2_KEY=secret-key",
+		"DUPLICACY_B2_PASSWORD=b2-password",
+		"DUPLICACY_AZURE_PASSWORD=azure-password",
+	}
+	if strings.Join(env, " ") != strings.Join(expected, " ") {
+		t.Errorf("Incorrect environment, got %v, expected %v", env, expected)
+	}
+
+	// Secrets are only resolved once per job
+	os.Setenv("DU_TEST_B2_PASSWORD", "changed")
+	if env, _ = credentialEnvironment(pruneConfig{Storage: "b2"}); env[1] != "DUPLICACY_B2_PASSWORD=b2-password" {
+		t.Errorf("Secret resolved again, got %v", env)
+	}
+
+	// Storages without credentials don't add anything
+	if env, _ = credentialEnvironment(checkConfig{Storage: "gcd"}); len(env) != 0 {
+		t.Errorf("Expected empty environment, got %v", env)
+	}
+}
+
+func TestCredentialEnvironment_Unresolved(t *testing.T) {
+	savedConfig := configFile
+	configFile = newConfigurationFile()
+	configFile.credentials = map[string]map[string]string{"b2": {"password": "env:DU_TEST_MISSING_PASSWORD"}}
+	os.Unsetenv("DU_TEST_MISSING_PASSWORD")
+	resetCredentials()
+	defer func() {
+		configFile = savedConfig
+		resetCredentials()
+	}()
+
+	if _, err := credentialEnvironment(backupConfig{Name: "b2"}); err == nil {
+		t.Error("Expected error for unresolved secret, got nil")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule from a cron expression (like "0 * * * *"): minute, hour, day of month,
+// month, and day of week. Each field may be "*", a value, a range ("1-5"), or a
+// list of these ("1,15"), optionally with a step ("*/15" or "0-12/2"). Months and
+// days of the week may be given by name ("jan", "sun"). Like cron, if both day of
+// month and day of week are restricted, either one may match.
+type cronSchedule struct {
+	expression string
+	minutes    uint64
+	hours      uint64
+	days       uint64
+	months     uint64
+	weekdays   uint64
+	anyDay     bool
+	anyWeekday bool
+}
+
+// Shorthand for common schedules
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonthNames   = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronWeekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func parseCron(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if macro, ok := cronMacros[strings.ToLower(expression)]; ok {
+		fields = strings.Fields(macro)
+	}
+	if len(fields) != 5 {
+		return nil, errors.New("must have five fields (minute, hour, day of month, month, day of week)")
+	}
+
+	schedule := &cronSchedule{expression: expression, anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %s", err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %s", err)
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %s", err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("month: %s", err)
+	}
+	if schedule.weekdays, err = parseCronField(fields[4], 0, 7, cronWeekdayNames); err != nil {
+		return nil, fmt.Errorf("day of week: %s", err)
+	}
+
+	// Sunday may be 0 or 7
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+
+	if schedule.next(time.Now()).IsZero() {
+		return nil, errors.New("never matches any date")
+	}
+
+	return schedule, nil
+}
+
+// Parse a field of a cron expression into a set of values (as bits)
+func parseCronField(field string, minimum int, maximum int, names []string) (uint64, error) {
+	value := func(text string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(text, name) {
+				return i + minimum, nil
+			}
+		}
+		number, err := strconv.Atoi(text)
+		if err != nil || number < minimum || number > maximum {
+			return 0, fmt.Errorf("invalid value %s (must be %d-%d)", text, minimum, maximum)
+		}
+		return number, nil
+	}
+
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangeText, step := item, 1
+		if slash := strings.Index(item, "/"); slash >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[slash+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s", item)
+			}
+			rangeText = item[:slash]
+		}
+
+		first, last := minimum, maximum
+		if rangeText != "*" {
+			bounds := strings.SplitN(rangeText, "-", 2)
+			var err error
+			if first, err = value(bounds[0]); err != nil {
+				return 0, err
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step != 1 {
+				last = maximum
+			}
+			if last < first {
+				return 0, fmt.Errorf("invalid range %s", rangeText)
+			}
+		}
+
+		for i := first; i <= last; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+// Next time (after the specified time) that matches the schedule, or the zero
+// time if nothing matches within five years
+func (schedule *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case schedule.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !schedule.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case schedule.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case schedule.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (schedule *cronSchedule) matchesDay(t time.Time) bool {
+	day := schedule.days&(1<<uint(t.Day())) != 0
+	weekday := schedule.weekdays&(1<<uint(t.Weekday())) != 0
+	if schedule.anyDay || schedule.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+func (schedule *cronSchedule) String() string {
+	return schedule.expression
+}
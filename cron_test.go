@@ -0,0 +1,82 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{"0 2 * * *", ""},
+		{"*/15 8-18 * * mon-fri", ""},
+		{"0 0 1,15 jan-jun/2 *", ""},
+		{"@daily", ""},
+		{"0 0 * * 7", ""},
+		{"0 2 * *", "must have five fields (minute, hour, day of month, month, day of week)"},
+		{"60 * * * *", "minute: invalid value 60 (must be 0-59)"},
+		{"0 5-1 * * *", "hour: invalid range 5-1"},
+		{"*/0 * * * *", "minute: invalid step in */0"},
+		{"0 0 * foo *", "month: invalid value foo (must be 1-12)"},
+		{"0 0 30 feb *", "never matches any date"},
+	}
+
+	for _, test := range tests {
+		_, err := parseCron(test.expression)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("Unexpected error for %q: %s", test.expression, err)
+		case test.err != "" && (err == nil || err.Error() != test.err):
+			t.Errorf("Incorrect error for %q, got %v, expected %s", test.expression, err, test.err)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Friday, 10:07
+	after := time.Date(2020, time.May, 15, 10, 7, 30, 0, time.Local)
+	tests := []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"* * * * *", time.Date(2020, time.May, 15, 10, 8, 0, 0, time.Local)},
+		{"*/15 * * * *", time.Date(2020, time.May, 15, 10, 15, 0, 0, time.Local)},
+		{"0 2 * * *", time.Date(2020, time.May, 16, 2, 0, 0, 0, time.Local)},
+		{"30 9 * * mon-fri", time.Date(2020, time.May, 18, 9, 30, 0, 0, time.Local)},
+		{"0 0 * * 0", time.Date(2020, time.May, 17, 0, 0, 0, 0, time.Local)},
+		{"@weekly", time.Date(2020, time.May, 17, 0, 0, 0, 0, time.Local)},
+		{"@monthly", time.Date(2020, time.June, 1, 0, 0, 0, 0, time.Local)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.Local)},
+
+		// Restricted day of month and day of week: either may match
+		{"0 12 20 * fri", time.Date(2020, time.May, 15, 12, 0, 0, 0, time.Local)},
+		{"0 8 20 * mon", time.Date(2020, time.May, 18, 8, 0, 0, 0, time.Local)},
+	}
+
+	for _, test := range tests {
+		schedule, err := parseCron(test.expression)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", test.expression, err)
+			continue
+		}
+		if next := schedule.next(after); !next.Equal(test.expected) {
+			t.Errorf("Incorrect next time for %q, got %s, expected %s", test.expression, next, test.expected)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule entries of the global configuration
+var globalSchedules []globalScheduleConfig
+
+// An entry in the schedule section of the global configuration (like an entry in
+// a repository configuration, but naming the configuration to run)
+type globalScheduleConfig struct {
+	Config string `config:"config" required:"true" description:"Repository configuration to perform the operations for"`
+	scheduleConfig
+}
+
+// Longest time to wait before checking the schedule again (so changes to the
+// system clock, or the system sleeping, don't delay jobs for long)
+var daemonPollInterval = time.Minute
+
+// Operations on a repository configuration, performed on a schedule by -daemon
+type scheduledJob struct {
+	config     string
+	schedule   *cronSchedule
+	operations []string
+	next       time.Time
+
+	// When the configuration last changed (zero if it couldn't be loaded)
+	configured time.Time
+}
+
+func (job *scheduledJob) String() string {
+	return fmt.Sprintf("%s (%s)", job.config, strings.Join(job.operations, " "))
+}
+
+// Scheduled jobs from the global configuration and each repository configuration
+// (or just for the configuration specified with -f)
+func scheduledJobs() ([]*scheduledJob, error) {
+	jobs := []*scheduledJob{}
+	for _, schedule := range globalSchedules {
+		if cmdConfig == "" || schedule.Config == cmdConfig {
+			jobs = append(jobs, &scheduledJob{config: schedule.Config, schedule: schedule.Cron, operations: schedule.Operations})
+		}
+	}
+
+	names := []string{cmdConfig}
+	if cmdConfig == "" {
+		var err error
+		if names, err = findRepositoryConfigs(globalStorageDirectory); err != nil {
+			return nil, err
+		}
+	}
+
+	// Variables in the configuration (like ${CONFIG}) refer to the configuration being loaded
+	savedConfig := cmdConfig
+	defer func() { cmdConfig = savedConfig }()
+	configured := map[string]time.Time{}
+	for _, name := range names {
+		cmdConfig = name
+		config := newConfigurationFile()
+		config.setConfig(name)
+		if err := config.loadConfig(false, false); err != nil {
+			if savedConfig != "" {
+				return nil, fmt.Errorf("unable to load configuration %s", name)
+			}
+			logError(nil, fmt.Sprintf("Warning: ignoring schedule of configuration %s (unable to load it)", name))
+			continue
+		}
+		configured[name] = config.modTime()
+		for _, schedule := range config.scheduleInfo {
+			jobs = append(jobs, &scheduledJob{config: name, schedule: schedule.Cron, operations: schedule.Operations})
+		}
+	}
+	for _, job := range jobs {
+		job.configured = configured[job.config]
+	}
+
+	return jobs, nil
+}
+
+// Job that's due next (the first one listed, if several are due at the same time)
+func nextJob(jobs []*scheduledJob) *scheduledJob {
+	next := jobs[0]
+	for _, job := range jobs[1:] {
+		if job.next.Before(next.next) {
+			next = job
+		}
+	}
+
+	return next
+}
+
+// Run as a daemon (-daemon), performing operations as scheduled until interrupted.
+// Jobs are run one at a time, so a job that's due while another is running waits
+// for it to finish. If a job is still running when its next run is due, that run
+// is skipped. Runs missed while the daemon wasn't running are caught up.
+func performDaemon(ctx context.Context) (int, error) {
+	jobs, err := scheduledJobs()
+	if err != nil {
+		return 2, err
+	}
+	if len(jobs) == 0 {
+		return 2, errors.New("no schedules found (add a schedule section to the global or a repository configuration)")
+	}
+
+	logMessage(nil, fmt.Sprintf("duplicacy-util daemon starting, version: %s, Git Hash: %s", versionText, gitHash))
+	logMessage(nil, "")
+	logMessage(nil, fmt.Sprintf("  %-20s  %-20s  %-16s  %s", "Configuration", "Operations", "Schedule", "Next Run"))
+	now := timeNow()
+	for _, job := range jobs {
+		job.next = job.schedule.next(now)
+		logMessage(nil, fmt.Sprintf("  %-20s  %-20s  %-16s  %s", job.config, strings.Join(job.operations, " "), job.schedule, job.next.Format("01-02-2006 15:04")))
+	}
+	logMessage(nil, "")
+
+	// Runs missed while the daemon wasn't running are caught up (once) right away
+	for _, job := range jobs {
+		if due, missed := missedRun(job, now); missed {
+			logMessage(nil, fmt.Sprintf("Missed run of %s (due %s) will be caught up", job, due.Format("01-02-2006 15:04")))
+			job.next = due
+		}
+	}
+
+	for ctx.Err() == nil {
+		job := nextJob(jobs)
+		if wait := job.next.Sub(timeNow()); wait > 0 {
+			if wait > daemonPollInterval {
+				wait = daemonPollInterval
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		runScheduledJob(ctx, job)
+		job.next = job.schedule.next(timeNow())
+		if ctx.Err() == nil {
+			logMessage(nil, fmt.Sprintf("Next run of %s: %s", job, job.next.Format("01-02-2006 15:04")))
+		}
+	}
+
+	logMessage(nil, "duplicacy-util daemon stopping")
+	return 0, nil
+}
+
+// Perform the operations of a scheduled job, as duplicacy-util -f <config> would
+// (sending notifications as configured). If the job is run well after it was due
+// (job.next), it's a catch-up of a missed run. Returns the exit code.
+func runScheduledJob(ctx context.Context, job *scheduledJob) int {
+	// Nothing is carried over from the previous job
+	backupTable, copyTable, pruneTable, checkTable, mailBody = nil, nil, nil, nil, nil
+	cmdConfig = job.config
+	cmdBackup, cmdCopy, cmdPrune, cmdCheck = false, false, false, false
+	for _, operation := range job.operations {
+		switch operation {
+		case "backup":
+			cmdBackup = true
+		case "copy":
+			cmdCopy = true
+		case "prune":
+			cmdPrune = true
+		case "check":
+			cmdCheck = true
+		case "all":
+			cmdBackup, cmdCopy, cmdPrune, cmdCheck = true, true, true, true
+		}
+	}
+
+	logMessage(nil, fmt.Sprint("Starting scheduled job ", job))
+	if overdue := timeNow().Sub(job.next); !job.next.IsZero() && overdue > catchUpGrace {
+		catchUpNote = fmt.Sprintf("Catch-up of missed run (due %s, overdue by %s)", job.next.Format("01-02-2006 15:04"), getTimeDiffString(job.next, timeNow()))
+		defer func() { catchUpNote = "" }()
+		logMessage(nil, catchUpNote)
+	}
+	configFile = newConfigurationFile()
+	configFile.setConfig(cmdConfig)
+
+	var returnStatus int
+	var err error
+	switch {
+	case configFile.loadConfig(verboseFlag, debugFlag) != nil:
+		// Errors were already logged; there's nobody watching, so notify of them
+		returnStatus, err = 1, fmt.Errorf("unable to load configuration %s", job.config)
+	case cmdDryRun:
+		returnStatus = performDryRun(ctx)
+	default:
+		returnStatus, err = obtainLock(ctx)
+	}
+
+	returnStatus = reportResult(returnStatus, err)
+	logMessage(nil, fmt.Sprintf("Finished scheduled job %s (exit code %d)", job, returnStatus))
+	return returnStatus
+}
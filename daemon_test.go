@@ -0,0 +1,185 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const daemonTestConfig = `repository: .
+storage:
+  - name: b2
+prune:
+  - storage: b2
+    keep: "0:7"
+check:
+  - storage: b2
+`
+
+// Notifier that counts failure notifications
+type failureNotifier struct {
+	count *int
+}
+
+func (failureNotifier) NotifyOfStart() error   { return nil }
+func (failureNotifier) NotifyOfSkip() error    { return nil }
+func (failureNotifier) NotifyOfDefer() error   { return nil }
+func (failureNotifier) NotifyOfSuccess() error { return nil }
+
+func (notifier failureNotifier) NotifyOfFailure() error {
+	*notifier.count++
+	return nil
+}
+
+func (failureNotifier) NotifyOfSummary([]configResult, bool) error { return nil }
+
+// Storage directory with configurations for daemon tests (photos is scheduled,
+// documents is scheduled in the global configuration, and broken is invalid)
+func setupDaemon(t *testing.T) func() {
+	cleanup := setupAllConfigs(t, map[string]string{
+		"photos.yaml":    daemonTestConfig + "schedule:\n  - cron: \"0 2 * * *\"\n    operations: backup copy\n",
+		"documents.yaml": daemonTestConfig,
+		"broken.yaml":    "repository: .\nstorage:\n  - name: b2\nschedule:\n  - cron: \"@daily\"\n    operations: all\n",
+	})
+
+	savedConfig, savedConfigFile, savedMailBody := cmdConfig, configFile, mailBody
+	documents, _ := parseCron("30 1 * * *")
+	cmdConfig = ""
+	globalSchedules = []globalScheduleConfig{{Config: "documents", scheduleConfig: scheduleConfig{Cron: documents, Operations: []string{"prune"}}}}
+	quietFlag = true
+
+	return func() {
+		cleanup()
+		cmdConfig, configFile, mailBody = savedConfig, savedConfigFile, savedMailBody
+		cmdBackup, cmdCopy, cmdPrune, cmdCheck, cmdDryRun = false, false, false, false, false
+		globalSchedules = nil
+		quietFlag = false
+	}
+}
+
+func TestScheduledJobs(t *testing.T) {
+	defer setupDaemon(t)()
+
+	jobs, err := scheduledJobs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	found := []string{}
+	for _, job := range jobs {
+		found = append(found, job.String()+" at "+job.schedule.String())
+	}
+	expected := "documents (prune) at 30 1 * * *, photos (backup copy) at 0 2 * * *"
+	if strings.Join(found, ", ") != expected {
+		t.Errorf("Incorrect jobs, got %s, expected %s", strings.Join(found, ", "), expected)
+	}
+	for _, job := range jobs {
+		if job.configured.IsZero() {
+			t.Errorf("Configuration time of %s wasn't set", job)
+		}
+	}
+
+	// Only jobs for the configuration specified with -f
+	cmdConfig = "photos"
+	if jobs, err = scheduledJobs(); err != nil || len(jobs) != 1 || jobs[0].config != "photos" {
+		t.Errorf("Incorrect jobs for photos, got %v (%v)", jobs, err)
+	}
+	if cmdConfig != "photos" {
+		t.Errorf("Configuration was not restored, got %s", cmdConfig)
+	}
+
+	// An invalid configuration specified with -f is an error
+	cmdConfig = "broken"
+	if _, err = scheduledJobs(); err == nil {
+		t.Error("Expected error for invalid configuration")
+	}
+}
+
+func TestRunScheduledJob(t *testing.T) {
+	defer setupDaemon(t)()
+	savedFailureNotifiers := onFailureNotifiers
+	defer func() { onFailureNotifiers = savedFailureNotifiers }()
+	failures := 0
+	onFailureNotifiers = []Notifier{failureNotifier{&failures}}
+	cmdDryRun = true
+
+	mailBody = []string{"left over from a previous job"}
+	job := &scheduledJob{config: "photos", operations: []string{"all"}}
+	if status := runScheduledJob(context.Background(), job); status != 0 {
+		t.Errorf("Incorrect status, got %d, expected 0", status)
+	}
+	if cmdConfig != "photos" || !cmdBackup || !cmdCopy || !cmdPrune || !cmdCheck {
+		t.Errorf("Incorrect options for job, got %s %v %v %v %v", cmdConfig, cmdBackup, cmdCopy, cmdPrune, cmdCheck)
+	}
+	output := strings.Join(mailBody, "\n")
+	if strings.Contains(output, "left over") || !strings.Contains(output, "Dry run") || failures != 0 {
+		t.Errorf("Incorrect output for job (%d failures):\n%s", failures, output)
+	}
+
+	// A configuration that can no longer be loaded is a failure (and is notified)
+	job = &scheduledJob{config: "broken", operations: []string{"backup"}}
+	if status := runScheduledJob(context.Background(), job); status != 1 || failures != 1 {
+		t.Errorf("Incorrect result for invalid configuration, got %d (%d failures)", status, failures)
+	}
+}
+
+func TestPerformDaemon(t *testing.T) {
+	defer setupDaemon(t)()
+	cmdConfig = "photos"
+	cmdDryRun = true
+
+	// The job is due as soon as the daemon starts; the daemon is stopped once the job is done
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Date(2020, time.May, 15, 1, 59, 30, 0, time.Local)
+	calls := 0
+	timeNow = func() time.Time {
+		calls++
+		switch {
+		case calls == 1:
+			return start
+		case calls > 2:
+			cancel()
+		}
+		return start.Add(time.Minute)
+	}
+	savedPollInterval := daemonPollInterval
+	daemonPollInterval = time.Millisecond
+	defer func() {
+		timeNow = time.Now
+		daemonPollInterval = savedPollInterval
+	}()
+
+	status, err := performDaemon(ctx)
+	if status != 0 || err != nil {
+		t.Errorf("Incorrect result, got %d (%v)", status, err)
+	}
+	output := strings.Join(mailBody, "\n")
+	if !strings.Contains(output, "Finished scheduled job photos (backup copy) (exit code 0)") || !strings.Contains(output, "daemon stopping") {
+		t.Errorf("Incorrect output for daemon:\n%s", output)
+	}
+}
+
+func TestPerformDaemon_NoSchedules(t *testing.T) {
+	defer setupDaemon(t)()
+	cmdConfig = "documents"
+	globalSchedules = nil
+
+	if status, err := performDaemon(context.Background()); status != 2 || err == nil {
+		t.Errorf("Expected error without schedules, got %d (%v)", status, err)
+	}
+}
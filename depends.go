@@ -0,0 +1,157 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	// Results of steps performed so far in this run (keyed by step name)
+	stepResults = map[string]string{}
+
+	// Steps running in parallel record their results concurrently
+	stepResultsMutex sync.Mutex
+)
+
+// Name of a step, as used in "after" settings and in the report. Steps are named
+// like "backup:b2", "copy:b2:azure", "prune:azure", or "check:azure".
+func stepName(info operationInfo) string {
+	switch info := info.(type) {
+	case backupConfig:
+		return "backup:" + info.Name
+	case copyConfig:
+		return "copy:" + info.From + ":" + info.To
+	case pruneConfig:
+		return "prune:" + info.Storage
+	case checkConfig:
+		return "check:" + info.Storage
+	}
+
+	return ""
+}
+
+// Build the dependency graph for all steps in the configuration. Implicitly:
+//   - A copy depends on the backup to the storage it copies from,
+//   - A prune depends on the backup (and copies) to the storage being pruned,
+//   - A check depends on the prune of the storage being checked.
+//
+// Additional dependencies may be added with "after" (a list of step names).
+// Since steps are performed in order, a step may only depend on earlier steps.
+func buildDependencyGraph(config *configurationFile) (map[string][]string, error) {
+	steps := []operationInfo{}
+	for _, info := range config.backupInfo {
+		steps = append(steps, info)
+	}
+	for _, info := range config.copyInfo {
+		steps = append(steps, info)
+	}
+	for _, info := range config.pruneInfo {
+		steps = append(steps, info)
+	}
+	for _, info := range config.checkInfo {
+		steps = append(steps, info)
+	}
+
+	// Position of each step (to validate that "after" only refers to earlier steps)
+	position := map[string]int{}
+	for i, info := range steps {
+		if _, ok := position[stepName(info)]; !ok {
+			position[stepName(info)] = i
+		}
+	}
+
+	graph := map[string][]string{}
+	addDependency := func(name string, prerequisite string) {
+		if _, ok := position[prerequisite]; !ok || prerequisite == name {
+			return
+		}
+		for _, existing := range graph[name] {
+			if existing == prerequisite {
+				return
+			}
+		}
+		graph[name] = append(graph[name], prerequisite)
+	}
+
+	for i, info := range steps {
+		name := stepName(info)
+
+		switch info := info.(type) {
+		case copyConfig:
+			addDependency(name, "backup:"+info.From)
+		case pruneConfig:
+			addDependency(name, "backup:"+info.Storage)
+			for _, copyInfo := range config.copyInfo {
+				if copyInfo.To == info.Storage {
+					addDependency(name, stepName(copyInfo))
+				}
+			}
+		case checkConfig:
+			addDependency(name, "prune:"+info.Storage)
+		}
+
+		for _, prerequisite := range info.settings().After {
+			index, ok := position[prerequisite]
+			if !ok {
+				return nil, fmt.Errorf("%s: after refers to unknown step %q", name, prerequisite)
+			}
+			if index >= i {
+				return nil, fmt.Errorf("%s: after refers to %q, which is not performed earlier", name, prerequisite)
+			}
+			addDependency(name, prerequisite)
+		}
+	}
+
+	return graph, nil
+}
+
+// Reset results of steps (at the start of a run)
+func resetStepResults() {
+	stepResultsMutex.Lock()
+	defer stepResultsMutex.Unlock()
+
+	stepResults = map[string]string{}
+}
+
+// Record the result of a step (used to decide if dependent steps may run)
+func recordStepResult(info operationInfo, status string) {
+	stepResultsMutex.Lock()
+	defer stepResultsMutex.Unlock()
+
+	stepResults[stepName(info)] = status
+}
+
+// Return the name of a prerequisite step that did not succeed (or "" if the step
+// can run). Prerequisites not performed in this run (or that completed in a prior
+// attempt) are considered to be satisfied.
+func failedPrerequisite(info operationInfo) string {
+	stepResultsMutex.Lock()
+	defer stepResultsMutex.Unlock()
+
+	for _, prerequisite := range configFile.dependencies[stepName(info)] {
+		if status, ok := stepResults[prerequisite]; ok && status != statusSuccess && status != statusSkipped {
+			return prerequisite
+		}
+	}
+
+	return ""
+}
+
+// Status for a step not performed because a prerequisite did not succeed
+func dependencyStatus(prerequisite string) string {
+	return fmt.Sprintf("Not run (%s did not succeed)", prerequisite)
+}
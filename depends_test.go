@@ -0,0 +1,93 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func dependencyTestConfig() *configurationFile {
+	config := newConfigurationFile()
+	config.backupInfo = []backupConfig{{Name: "b2"}, {Name: "azure-direct"}}
+	config.copyInfo = []copyConfig{{From: "b2", To: "azure"}}
+	config.pruneInfo = []pruneConfig{{Storage: "b2"}, {Storage: "azure"}}
+	config.checkInfo = []checkConfig{{Storage: "b2"}, {Storage: "azure", operationConfig: operationConfig{After: []string{"check:b2"}}}}
+	return config
+}
+
+func TestBuildDependencyGraph(t *testing.T) {
+	graph, err := buildDependencyGraph(dependencyTestConfig())
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	expected := map[string][]string{
+		"copy:b2:azure": {"backup:b2"},
+		"prune:b2":      {"backup:b2"},
+		"prune:azure":   {"copy:b2:azure"},
+		"check:b2":      {"prune:b2"},
+		"check:azure":   {"prune:azure", "check:b2"},
+	}
+	if !reflect.DeepEqual(graph, expected) {
+		t.Errorf("Dependency graph is incorrect, got %v, expected %v", graph, expected)
+	}
+}
+
+func TestBuildDependencyGraph_InvalidAfter(t *testing.T) {
+	tests := []string{"backup:nosuchstorage", "check:azure", "check:b2"}
+
+	for _, after := range tests {
+		config := dependencyTestConfig()
+		config.checkInfo[0].After = []string{after}
+		if _, err := buildDependencyGraph(config); err == nil {
+			t.Errorf("Expected error for after: %s", after)
+		}
+	}
+}
+
+func TestFailedPrerequisite(t *testing.T) {
+	savedConfig := configFile
+	configFile = dependencyTestConfig()
+	defer func() {
+		configFile = savedConfig
+		resetStepResults()
+	}()
+
+	var err error
+	if configFile.dependencies, err = buildDependencyGraph(configFile); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	resetStepResults()
+	recordStepResult(configFile.backupInfo[0], statusFailed)
+	recordStepResult(configFile.backupInfo[1], statusSuccess)
+
+	// Copy from b2 can't run since the backup to b2 failed
+	if prerequisite := failedPrerequisite(configFile.copyInfo[0]); prerequisite != "backup:b2" {
+		t.Errorf("Incorrect failed prerequisite for copy, got %q, expected %q", prerequisite, "backup:b2")
+	}
+
+	// Pruning azure depends on the copy (which hasn't run yet)
+	if prerequisite := failedPrerequisite(configFile.pruneInfo[1]); prerequisite != "" {
+		t.Errorf("Incorrect failed prerequisite for prune, got %q, expected none", prerequisite)
+	}
+
+	// Steps skipped due to a checkpoint count as successful
+	recordStepResult(configFile.pruneInfo[0], statusSkipped)
+	if prerequisite := failedPrerequisite(configFile.checkInfo[0]); prerequisite != "" {
+		t.Errorf("Incorrect failed prerequisite for check, got %q, expected none", prerequisite)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Results of a doctor check
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+type doctorCheck struct {
+	name    string
+	status  string
+	details string
+}
+
+// A storage defined in the repository's .duplicacy/preferences file (written by
+// "duplicacy init" and "duplicacy add")
+type duplicacyPreference struct {
+	Name           string            `json:"name"`
+	SnapshotID     string            `json:"id"`
+	StorageURL     string            `json:"storage"`
+	Encrypted      bool              `json:"encrypted"`
+	NoBackup       bool              `json:"no_backup"`
+	NoSavePassword bool              `json:"no_save_password"`
+	Keys           map[string]string `json:"keys"`
+}
+
+// Maximum time to wait for "duplicacy -version"
+var doctorVersionTimeout = 30 * time.Second
+
+// Directory with duplicacy preferences for a repository. Normally, this is the
+// .duplicacy directory; if the repository was initialized with -pref-dir,
+// .duplicacy is a file containing the location of the directory.
+func preferencesDirectory(repoDir string) (string, error) {
+	prefDir := filepath.Join(repoDir, ".duplicacy")
+	info, err := os.Stat(prefDir)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return prefDir, nil
+	}
+
+	content, err := ioutil.ReadFile(prefDir)
+	if err != nil {
+		return "", err
+	}
+	location := strings.TrimSpace(string(content))
+	if !filepath.IsAbs(location) {
+		location = filepath.Join(repoDir, location)
+	}
+	return location, nil
+}
+
+// Read the storages defined in the preferences file of a repository
+func readPreferences(prefDir string) ([]duplicacyPreference, error) {
+	content, err := ioutil.ReadFile(filepath.Join(prefDir, "preferences"))
+	if err != nil {
+		return nil, err
+	}
+
+	var preferences []duplicacyPreference
+	if err := json.Unmarshal(content, &preferences); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", filepath.Join(prefDir, "preferences"), err)
+	}
+	return preferences, nil
+}
+
+// Check the configuration (and the environment it runs in) for common problems,
+// writing a table of results (-doctor). Returns the exit code: 1 if any check
+// failed, 0 otherwise.
+func performDoctor(ctx context.Context, w io.Writer) int {
+	checks := []doctorCheck{}
+	checks = append(checks, doctorDuplicacy(ctx))
+	checks = append(checks, doctorDirectory("Lock directory", globalLockDir))
+	checks = append(checks, doctorDirectory("Log directory", globalLogDir))
+	checks = append(checks, doctorStorages()...)
+
+	nameWidth := len("Check")
+	for _, check := range checks {
+		if len(check.name) > nameWidth {
+			nameWidth = len(check.name)
+		}
+	}
+
+	fmt.Fprintf(w, "%-*s  %-6s  %s\n", nameWidth, "Check", "Status", "Details")
+	fmt.Fprintf(w, "%s  %s  %s\n", strings.Repeat("-", nameWidth), strings.Repeat("-", 6), strings.Repeat("-", 7))
+	returnStatus, counts := 0, map[string]int{}
+	for _, check := range checks {
+		fmt.Fprintf(w, "%-*s  %-6s  %s\n", nameWidth, check.name, check.status, check.details)
+		counts[check.status]++
+		if check.status == doctorFail {
+			returnStatus = 1
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed\n", counts[doctorPass], counts[doctorWarn], counts[doctorFail])
+
+	return returnStatus
+}
+
+// Check that duplicacy runs (and report its version)
+func doctorDuplicacy(ctx context.Context) doctorCheck {
+	check := doctorCheck{name: "Duplicacy binary"}
+
+	versionCtx, cancel := context.WithTimeout(ctx, doctorVersionTimeout)
+	defer cancel()
+
+	version := ""
+	err := executor(versionCtx, duplicacyPath, []string{"-version"}, "", nil, func(line string) {
+		if version == "" && strings.TrimSpace(line) != "" && !strings.HasPrefix(line, stderrPrefix) {
+			version = strings.TrimSpace(line)
+		}
+	})
+	if err != nil {
+		check.status, check.details = doctorFail, fmt.Sprintf("unable to run %s: %s", duplicacyPath, err)
+		return check
+	}
+
+	version = strings.TrimPrefix(version, "duplicacy version ")
+	check.status, check.details = doctorPass, fmt.Sprintf("%s (version %s)", duplicacyPath, version)
+	return check
+}
+
+// Check that a directory is writable (by creating and removing a file)
+func doctorDirectory(name string, directory string) doctorCheck {
+	check := doctorCheck{name: name}
+
+	file, err := ioutil.TempFile(directory, ".duplicacy-util-doctor-")
+	if err != nil {
+		check.status, check.details = doctorFail, fmt.Sprintf("%s is not writable: %s", directory, err)
+		return check
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	check.status, check.details = doctorPass, directory
+	return check
+}
+
+// Check that storages used by the configuration are defined by the repository,
+// and that passwords are available for encrypted storages
+func doctorStorages() []doctorCheck {
+	prefDir, err := preferencesDirectory(configFile.repoDir)
+	var preferences []duplicacyPreference
+	if err == nil {
+		preferences, err = readPreferences(prefDir)
+	}
+	if err != nil {
+		return []doctorCheck{{"Preferences", doctorFail, fmt.Sprintf("unable to read duplicacy preferences: %s", err)}}
+	}
+
+	checks := []doctorCheck{{"Preferences", doctorPass, fmt.Sprintf("%d storages defined in %s", len(preferences), filepath.Join(prefDir, "preferences"))}}
+
+	known := make([]settingSchema, len(preferences))
+	byName := map[string]duplicacyPreference{}
+	for i, preference := range preferences {
+		known[i] = settingSchema{name: preference.Name}
+		byName[preference.Name] = preference
+	}
+
+	// Each storage used by a step is defined (storage names are case sensitive)
+	steps := []operationInfo{}
+	steps = append(steps, configFile.backupOperations()...)
+	steps = append(steps, configFile.copyOperations()...)
+	for _, info := range configFile.pruneInfo {
+		steps = append(steps, info)
+	}
+	for _, info := range configFile.checkInfo {
+		steps = append(steps, info)
+	}
+	used := []string{}
+	for _, info := range steps {
+		check := doctorCheck{name: "Storage for " + stepName(info), status: doctorPass}
+		missing := []string{}
+		for _, storage := range operationStorages(info) {
+			used = append(used, storage)
+			if _, ok := byName[storage]; !ok {
+				missing = append(missing, storage+" is not defined"+suggestSetting(known, storage))
+			}
+		}
+
+		switch info := info.(type) {
+		case backupConfig:
+			if preference, ok := byName[info.Name]; ok && preference.NoBackup {
+				missing = append(missing, info.Name+" does not allow backups (no_backup is set)")
+			}
+		case copyConfig:
+			if len(missing) == 0 {
+				check.status, check.details = doctorCopyCompatible(byName[info.From], byName[info.To])
+			}
+		}
+
+		if len(missing) != 0 {
+			check.status, check.details = doctorFail, strings.Join(missing, "; ")
+		} else if check.details == "" {
+			check.details = "defined in preferences"
+		}
+		checks = append(checks, check)
+	}
+
+	// Each encrypted storage has a password that duplicacy can use without a prompt
+	checked := map[string]bool{}
+	for _, storage := range used {
+		preference, ok := byName[storage]
+		if !ok || checked[storage] {
+			continue
+		}
+		checked[storage] = true
+		status, details := doctorPassword(prefDir, preference)
+		checks = append(checks, doctorCheck{"Password for " + storage, status, details})
+	}
+
+	return checks
+}
+
+// Is a password available for a storage (see GetPassword in duplicacy)?
+func doctorPassword(prefDir string, preference duplicacyPreference) (string, string) {
+	if !preference.Encrypted {
+		return doctorPass, "storage is not encrypted"
+	}
+
+	passwordID := "password"
+	if preference.Name != "default" {
+		passwordID = preference.Name + "_password"
+	}
+
+	if _, ok := configFile.credentials[preference.Name]["password"]; ok {
+		return doctorPass, "password in credentials"
+	}
+	if os.Getenv(credentialVariable(preference.Name, "password")) != "" {
+		return doctorPass, "password in environment variable " + credentialVariable(preference.Name, "password")
+	}
+	if preference.Keys["password"] != "" || preference.Keys[passwordID] != "" {
+		return doctorPass, "password in preferences"
+	}
+	if preference.NoSavePassword {
+		return doctorFail, "no saved password (no_save_password is set); add the password to credentials"
+	}
+
+	// On Windows, duplicacy saves passwords in a keyring file in the preferences
+	// directory (elsewhere, the keyring is managed by the operating system)
+	if content, err := ioutil.ReadFile(filepath.Join(prefDir, "keyring")); err == nil {
+		var keyring map[string]interface{}
+		if json.Unmarshal(content, &keyring) == nil && keyring[passwordID] != nil {
+			return doctorPass, "password in keyring"
+		}
+	}
+
+	return doctorWarn, "no saved password found (unless it is in the system keyring, duplicacy will prompt for it)"
+}
+
+// Can storages be copied between? Duplicacy requires the destination to have been
+// added as copy-compatible with the source ("duplicacy add -copy"), so both use
+// the same chunking parameters. This can only be verified for local storages
+// that aren't encrypted (otherwise, the storage config can't be read).
+func doctorCopyCompatible(from duplicacyPreference, to duplicacyPreference) (string, string) {
+	if from.Name == to.Name {
+		return doctorFail, "copy from a storage to itself"
+	}
+
+	fromConfig, fromErr := readStorageConfig(from)
+	toConfig, toErr := readStorageConfig(to)
+	if fromErr != nil || toErr != nil {
+		return doctorPass, "defined in preferences (copy compatibility not verified)"
+	}
+
+	for _, key := range []string{"average-chunk-size", "max-chunk-size", "min-chunk-size", "chunk-seed", "hash-key", "id-key"} {
+		if fmt.Sprint(fromConfig[key]) != fmt.Sprint(toConfig[key]) {
+			return doctorFail, fmt.Sprintf("%s is not copy-compatible with %s (%s differs); add it with \"duplicacy add -copy %s\"", to.Name, from.Name, key, from.Name)
+		}
+	}
+
+	return doctorPass, "defined in preferences (copy-compatible)"
+}
+
+// Read the config file of a local storage that isn't encrypted
+func readStorageConfig(preference duplicacyPreference) (map[string]interface{}, error) {
+	if preference.Encrypted {
+		return nil, fmt.Errorf("storage %s is encrypted", preference.Name)
+	}
+
+	location := strings.TrimPrefix(preference.StorageURL, "file://")
+	if !filepath.IsAbs(location) {
+		return nil, fmt.Errorf("storage %s is not local", preference.Name)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(location, "config"))
+	if err != nil {
+		return nil, err
+	}
+
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,157 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const doctorTestPreferences = `[
+    {"name": "default", "id": "repo", "storage": "b2://bucket", "encrypted": true, "keys": {"password": "secret"}},
+    {"name": "azure", "id": "repo", "storage": "azure://container", "encrypted": true},
+    {"name": "offsite", "id": "repo", "storage": "sftp://host/backups", "encrypted": true, "no_save_password": true},
+    {"name": "local", "id": "repo", "storage": "/nonexistent/storage", "encrypted": false}
+]`
+
+// Repository (with a preferences file) and configuration for doctor tests
+func setupDoctor(t *testing.T) func() {
+	savedConfig, savedLockDir, savedLogDir := configFile, globalLockDir, globalLogDir
+	directory, err := ioutil.TempDir("", "doctor")
+	if err != nil {
+		t.Fatalf("Error creating repository directory: %s", err)
+	}
+	os.Mkdir(filepath.Join(directory, ".duplicacy"), 0700)
+	if err := ioutil.WriteFile(filepath.Join(directory, ".duplicacy", "preferences"), []byte(doctorTestPreferences), 0600); err != nil {
+		t.Fatalf("Error writing preferences: %s", err)
+	}
+
+	configFile = newConfigurationFile()
+	configFile.repoDir = directory
+	globalLockDir, globalLogDir = directory, directory
+	execCommand = fakeExecCommand
+
+	return func() {
+		os.RemoveAll(directory)
+		configFile, globalLockDir, globalLogDir = savedConfig, savedLockDir, savedLogDir
+		execCommand = exec.Command
+	}
+}
+
+// Doctor results (without the header and summary), keyed by check
+func doctorResults(t *testing.T) (int, map[string]string) {
+	var output bytes.Buffer
+	status := performDoctor(context.Background(), &output)
+
+	results := map[string]string{}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	column := strings.Index(lines[0], "Status")
+	for _, line := range lines[2 : len(lines)-2] {
+		results[strings.TrimSpace(line[:column])] = strings.Join(strings.Fields(line[column:]), " ")
+	}
+	return status, results
+}
+
+func TestPerformDoctor(t *testing.T) {
+	defer setupDoctor(t)()
+	configFile.backupInfo = []backupConfig{{Name: "default"}, {Name: "Azure"}}
+	configFile.copyInfo = []copyConfig{{From: "default", To: "local"}}
+	configFile.pruneInfo = []pruneConfig{{Storage: "offsite"}}
+	configFile.checkInfo = []checkConfig{{Storage: "default"}}
+
+	status, results := doctorResults(t)
+	if status != 1 {
+		t.Errorf("Incorrect status, got %d, expected %d", status, 1)
+	}
+
+	expected := map[string]string{
+		"Duplicacy binary":               "pass duplicacy (version 2.7.2 (175ADB))",
+		"Storage for backup:default":     "pass defined in preferences",
+		"Storage for backup:Azure":       "fail Azure is not defined (did you mean azure?)",
+		"Storage for copy:default:local": "pass defined in preferences (copy compatibility not verified)",
+		"Storage for prune:offsite":      "pass defined in preferences",
+		"Password for default":           "pass password in preferences",
+		"Password for local":             "pass storage is not encrypted",
+		"Password for offsite":           "fail no saved password (no_save_password is set); add the password to credentials",
+	}
+	for name, result := range expected {
+		if results[name] != result {
+			t.Errorf("Incorrect result for %s, got %q, expected %q", name, results[name], result)
+		}
+	}
+	if !strings.HasPrefix(results["Lock directory"], "pass") || !strings.HasPrefix(results["Log directory"], "pass") {
+		t.Errorf("Directories should be writable, got %v", results)
+	}
+}
+
+func TestPerformDoctor_Passwords(t *testing.T) {
+	defer setupDoctor(t)()
+	configFile.backupInfo = []backupConfig{{Name: "azure"}, {Name: "offsite"}}
+	configFile.credentials = map[string]map[string]string{"offsite": {"password": "env:OFFSITE_PASSWORD"}}
+
+	status, results := doctorResults(t)
+	if status != 0 {
+		t.Errorf("Incorrect status, got %d, expected %d", status, 0)
+	}
+	if !strings.HasPrefix(results["Password for azure"], "warn no saved password found") {
+		t.Errorf("Expected warning for azure password, got %q", results["Password for azure"])
+	}
+	if results["Password for offsite"] != "pass password in credentials" {
+		t.Errorf("Incorrect result for offsite password, got %q", results["Password for offsite"])
+	}
+}
+
+func TestPerformDoctor_NoPreferences(t *testing.T) {
+	defer setupDoctor(t)()
+	os.RemoveAll(filepath.Join(configFile.repoDir, ".duplicacy"))
+
+	status, results := doctorResults(t)
+	if status != 1 || !strings.HasPrefix(results["Preferences"], "fail unable to read duplicacy preferences") {
+		t.Errorf("Expected failure for missing preferences, got %d %v", status, results)
+	}
+}
+
+func TestDoctorCopyCompatible(t *testing.T) {
+	directory, err := ioutil.TempDir("", "doctor")
+	if err != nil {
+		t.Fatalf("Error creating storage directory: %s", err)
+	}
+	defer os.RemoveAll(directory)
+
+	configs := map[string]string{
+		"one":   `{"average-chunk-size": 4194304, "chunk-seed": "6475706c6963616379"}`,
+		"two":   `{"average-chunk-size": 4194304, "chunk-seed": "6475706c6963616379"}`,
+		"three": `{"average-chunk-size": 1048576, "chunk-seed": "6475706c6963616379"}`,
+	}
+	preferences := map[string]duplicacyPreference{}
+	for name, config := range configs {
+		os.Mkdir(filepath.Join(directory, name), 0700)
+		ioutil.WriteFile(filepath.Join(directory, name, "config"), []byte(config), 0600)
+		preferences[name] = duplicacyPreference{Name: name, StorageURL: filepath.Join(directory, name)}
+	}
+
+	if status, details := doctorCopyCompatible(preferences["one"], preferences["two"]); status != doctorPass {
+		t.Errorf("Expected compatible storages, got %s %s", status, details)
+	}
+	if status, details := doctorCopyCompatible(preferences["one"], preferences["three"]); status != doctorFail {
+		t.Errorf("Expected incompatible storages, got %s %s", status, details)
+	}
+}
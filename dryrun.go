@@ -0,0 +1,149 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Show the commands that would be run for the requested operations. Nothing is
+// executed (the lock isn't taken, and log files aren't rotated) unless duplicacy
+// should show what backups and prunes would do (-dry-run-duplicacy), in which
+// case duplicacy is run with its own -dry-run option.
+func performDryRun(ctx context.Context) int {
+	logMessage(nil, fmt.Sprint("Dry run; commands would be run in directory ", configFile.repoDir))
+	if configFile.parallel > 1 && (cmdBackup || cmdCopy) {
+		logMessage(nil, fmt.Sprintf("Backups and copies would be run up to %d at a time", configFile.parallel))
+	}
+
+	step := 0
+	showHook := func(name string) {
+		if hook, ok := configFile.hooks[name]; ok {
+			directory := ""
+			if hook["directory"] != "" {
+				directory = fmt.Sprint(" (in directory ", hook["directory"], ")")
+			}
+			logMessage(nil, fmt.Sprintf("      %s hook: %s%s", name, redactSecrets(hook["command"]), directory))
+		}
+	}
+	showOperation := func(operation int, info operationInfo, cmdArgs []string) {
+		step++
+		showHook(hookName("pre", operation))
+		cmdArgs = append(cmdArgs, limitRateArguments(info, timeNow())...)
+		logMessage(nil, fmt.Sprintf("  %2d: %s", step, redactSecrets(commandLine(duplicacyPath, cmdArgs))))
+		if reason := deferralReason(info); reason != "" {
+			logMessage(nil, fmt.Sprint("      would be deferred: ", reason))
+		}
+		showHook(hookName("post", operation))
+	}
+
+	showHook(hookName("pre", checkpointNone))
+	if cmdBackup {
+		for _, backupInfo := range configFile.backupInfo {
+			cmdArgs, _ := backupArguments(backupInfo)
+			showOperation(checkpointBackup, backupInfo, cmdArgs)
+		}
+	}
+	if cmdCopy {
+		for _, copyInfo := range configFile.copyInfo {
+			cmdArgs, _ := copyArguments(copyInfo)
+			showOperation(checkpointCopy, copyInfo, cmdArgs)
+		}
+	}
+	if cmdPrune {
+		for _, pruneInfo := range configFile.pruneInfo {
+			cmdArgs, _ := pruneArguments(pruneInfo)
+			showOperation(checkpointPrune, pruneInfo, cmdArgs)
+		}
+	}
+	if cmdCheck {
+		for _, checkInfo := range configFile.checkInfo {
+			cmdArgs, _ := checkArguments(checkInfo)
+			showOperation(checkpointCheck, checkInfo, cmdArgs)
+		}
+	}
+	showHook(hookName("post", checkpointNone))
+
+	if !cmdDryRunDuplicacy {
+		return 0
+	}
+
+	// Ask duplicacy what backups and prunes would do
+	returnStatus := 0
+	if cmdBackup {
+		for _, backupInfo := range configFile.backupInfo {
+			cmdArgs, description := backupArguments(backupInfo)
+			if err := dryRunDuplicacy(ctx, backupInfo, cmdArgs, description, func(line string) bool {
+				return strings.HasPrefix(line, "Files:") || strings.HasPrefix(line, "All chunks:")
+			}); err != nil {
+				returnStatus = 500
+			}
+		}
+	}
+	if cmdPrune {
+		for _, pruneInfo := range configFile.pruneInfo {
+			cmdArgs, description := pruneArguments(pruneInfo)
+			if err := dryRunDuplicacy(ctx, pruneInfo, cmdArgs, description, func(line string) bool {
+				return strings.HasPrefix(line, "Deleting snapshot") || strings.Contains(line, "would be deleted")
+			}); err != nil {
+				returnStatus = 500
+			}
+		}
+	}
+
+	return returnStatus
+}
+
+// Run duplicacy with the -dry-run option, showing the lines of output that
+// summarize what would be done
+func dryRunDuplicacy(ctx context.Context, info operationInfo, cmdArgs []string, description string, summary func(string) bool) error {
+	logMessage(nil, fmt.Sprint(description, " (dry run)"))
+
+	env, err := credentialEnvironment(info)
+	if err != nil {
+		logError(nil, fmt.Sprint("Error: ", err))
+		return err
+	}
+
+	lines := 0
+	cmdArgs = append(cmdArgs, "-dry-run")
+	_, err = executeDuplicacy(ctx, nil, info, cmdArgs, env, func(line string) {
+		if summary(line) || strings.HasPrefix(line, stderrPrefix) {
+			logMessage(nil, fmt.Sprint("  ", line))
+			lines++
+		}
+	})
+	if err == nil && lines == 0 {
+		logMessage(nil, "  Nothing to do")
+	}
+
+	return err
+}
+
+// Command line (for display), quoting arguments as needed
+func commandLine(cmdName string, cmdArgs []string) string {
+	words := []string{cmdName}
+	for _, arg := range cmdArgs {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'") {
+			arg = strconv.Quote(arg)
+		}
+		words = append(words, arg)
+	}
+
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,106 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOperationArguments(t *testing.T) {
+	tests := []struct {
+		info     operationInfo
+		expected []string
+	}{
+		{backupConfig{Name: "b2"},
+			[]string{"backup", "-storage", "b2", "-stats"}},
+		{backupConfig{Name: "b2", Threads: 10, VSS: true, VSSTimeout: 60, operationConfig: operationConfig{Quote: "-hash -limit-rate 100"}},
+			[]string{"backup", "-storage", "b2", "-stats", "-threads", "10", "-vss", "-vss-timeout", "60", "-hash", "-limit-rate", "100"}},
+		{copyConfig{From: "b2", To: "azure", Threads: 5},
+			[]string{"copy", "-from", "b2", "-to", "azure", "-threads", "5"}},
+		{pruneConfig{Storage: "b2", Keep: []string{"0:365", "30:180"}, All: true},
+			[]string{"prune", "-storage", "b2", "-keep", "0:365", "-keep", "30:180", "-all"}},
+		{pruneConfig{Storage: "b2", Keep: []string{"0:365"}},
+			[]string{"prune", "-storage", "b2", "-keep", "0:365"}},
+		{checkConfig{Storage: "b2", All: true, operationConfig: operationConfig{Quote: "-stats"}},
+			[]string{"check", "-storage", "b2", "-all", "-stats"}},
+	}
+
+	for _, test := range tests {
+		var cmdArgs []string
+		switch info := test.info.(type) {
+		case backupConfig:
+			cmdArgs, _ = backupArguments(info)
+		case copyConfig:
+			cmdArgs, _ = copyArguments(info)
+		case pruneConfig:
+			cmdArgs, _ = pruneArguments(info)
+		case checkConfig:
+			cmdArgs, _ = checkArguments(info)
+		}
+
+		if !reflect.DeepEqual(cmdArgs, test.expected) {
+			t.Errorf("Incorrect %s arguments, got %v, expected %v", stepName(test.info), cmdArgs, test.expected)
+		}
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	result := commandLine("duplicacy", []string{"backup", "-storage", "my storage", ""})
+	expected := `duplicacy backup -storage "my storage" ""`
+	if result != expected {
+		t.Errorf("Incorrect command line, got %s, expected %s", result, expected)
+	}
+}
+
+func TestPerformDryRun(t *testing.T) {
+	savedConfig := configFile
+	savedMailBody := mailBody
+	configFile = newConfigurationFile()
+	configFile.repoDir = "/home/user/repository"
+	configFile.backupInfo = []backupConfig{{Name: "b2", Threads: 10}}
+	configFile.copyInfo = []copyConfig{{From: "b2", To: "azure"}}
+	configFile.pruneInfo = []pruneConfig{{Storage: "azure", Keep: []string{"0:365"}, All: true}}
+	configFile.checkInfo = []checkConfig{{Storage: "azure"}}
+	configFile.hooks = map[string]map[string]string{"preBackup": {"command": "./dump.sh", "onFailure": hookAbort}}
+	cmdBackup, cmdCopy, cmdPrune, cmdCheck = true, true, true, false
+	loggingSystemDisplayTime = false
+	quietFlag = true
+	mailBody = []string{}
+	defer func() {
+		configFile = savedConfig
+		mailBody = savedMailBody
+		cmdBackup, cmdCopy, cmdPrune, cmdCheck = false, false, false, false
+		loggingSystemDisplayTime = true
+		quietFlag = false
+	}()
+
+	if status := performDryRun(context.Background()); status != 0 {
+		t.Errorf("Incorrect status, got %d, expected %d", status, 0)
+	}
+
+	expected := []string{
+		"Dry run; commands would be run in directory /home/user/repository",
+		"      preBackup hook: ./dump.sh",
+		"   1: duplicacy backup -storage b2 -stats -threads 10",
+		"   2: duplicacy copy -from b2 -to azure",
+		"   3: duplicacy prune -storage azure -keep 0:365 -all",
+	}
+	if strings.Join(mailBody, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Incorrect dry run output, got:\n%s\nexpected:\n%s", strings.Join(mailBody, "\n"), strings.Join(expected, "\n"))
+	}
+}
@@ -15,13 +15,16 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/gofrs/flock"
@@ -40,6 +43,42 @@ var (
 	cmdCheck  bool
 	cmdPrune  bool
 
+	// Checkpoint handling (resume from a prior failed run, or start over)
+	cmdResume  bool
+	cmdRestart bool
+
+	// Show commands that would be run (optionally, ask duplicacy what it would do)
+	cmdDryRun          bool
+	cmdDryRunDuplicacy bool
+
+	// Scenario file to replay instead of running duplicacy
+	cmdSimulate string
+
+	// Write the JSON Schema for repository configuration files
+	cmdPrintSchema bool
+
+	// Check the configuration against the repository (and environment)
+	cmdDoctor bool
+
+	// Show the effective configuration (after merging includes and overrides)
+	cmdShowConfig bool
+
+	// Migrate configuration files from old formats (only check them with -dry-run)
+	cmdMigrateConfig bool
+
+	// Run every repository configuration (and don't send notifications, used for
+	// each configuration when only a summary notification is wanted)
+	cmdAllConfigs bool
+	cmdNoNotify   bool
+
+	// Run as a daemon, performing operations as scheduled (until interrupted), or
+	// install the schedule in the system scheduler instead
+	cmdDaemon          bool
+	cmdInstallSchedule string
+
+	// Perform scheduled operations that were missed (once)
+	cmdCatchUp bool
+
 	testNotificationsFlag bool
 
 	debugFlag   bool
@@ -54,6 +93,8 @@ var (
 	// Mail message body to send upon completion
 	backupTable []backupRevision
 	copyTable   []copyRevision
+	pruneTable  []operationRevision
+	checkTable  []operationRevision
 	mailBody    []string
 
 	// Create configuration object to load configuration file
@@ -74,6 +115,10 @@ func init() {
 	flag.StringVar(&cmdConfig, "f", "", "Configuration file for storage definitions (must be specified)")
 	flag.StringVar(&cmdGlobalConfig, "g", "", "Global configuration file name")
 	flag.StringVar(&cmdStorageDir, "sd", "", "Full path to storage directory for configuration/log files")
+	flag.BoolVar(&cmdAllConfigs, "all-configs", false, "Run every repository configuration in the storage directory (or its conf.d directory)")
+	flag.BoolVar(&cmdDaemon, "daemon", false, "Run as a daemon, performing operations as scheduled (until interrupted)")
+	flag.BoolVar(&cmdCatchUp, "catch-up", false, "Perform scheduled operations whose last run was missed (once)")
+	flag.StringVar(&cmdInstallSchedule, "install-schedule", "", "Install the schedule for systemd, cron, launchd or windows (with -dry-run, only show it)")
 
 	flag.BoolVar(&cmdAll, "a", false, "Perform all duplicacy operations (backup, copy, purge, check)")
 	flag.BoolVar(&cmdBackup, "backup", false, "Perform duplicacy backup operation")
@@ -81,7 +126,21 @@ func init() {
 	flag.BoolVar(&cmdCheck, "check", false, "Perform duplicacy check operation")
 	flag.BoolVar(&cmdPrune, "prune", false, "Perform duplicacy prune operation")
 
+	flag.BoolVar(&cmdResume, "resume", false, "Resume from checkpoint of a previous failed run (even if configuration changed)")
+	flag.BoolVar(&cmdRestart, "restart", false, "Ignore checkpoint of a previous failed run and start from the beginning")
+
+	flag.BoolVar(&cmdDryRun, "dry-run", false, "Show the duplicacy commands that would be run, without running them")
+	flag.BoolVar(&cmdDryRunDuplicacy, "dry-run-duplicacy", false, "Like -dry-run, but also run duplicacy backup/prune with -dry-run (implies -dry-run)")
+
+	flag.StringVar(&cmdSimulate, "simulate", "", "Simulate duplicacy by replaying the scenario in the specified file")
+
+	flag.BoolVar(&cmdPrintSchema, "print-schema", false, "Write the JSON Schema for repository configuration files")
+	flag.BoolVar(&cmdDoctor, "doctor", false, "Check the configuration against the repository's duplicacy preferences")
+	flag.BoolVar(&cmdShowConfig, "show-config", false, "Show the effective configuration, and where each setting came from")
+	flag.BoolVar(&cmdMigrateConfig, "migrate-config", false, "Migrate configuration files from old formats (with -dry-run, only check them)")
+
 	flag.BoolVar(&testNotificationsFlag, "tn", false, "Test notifications")
+	flag.BoolVar(&cmdNoNotify, "no-notify", false, "Don't send any notifications")
 
 	flag.BoolVar(&debugFlag, "d", false, "Enable debug output (implies verbose)")
 	flag.BoolVar(&quietFlag, "q", false, "Quiet operations (generate output only in case of error)")
@@ -91,6 +150,9 @@ func init() {
 
 // Generic output routine to generate output to screen (and E-Mail) - Allow output writer
 func logFMessage(w io.Writer, logger *log.Logger, message string) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
 	if logger != nil {
 		logger.Println(message)
 	}
@@ -99,7 +161,11 @@ func logFMessage(w io.Writer, logger *log.Logger, message string) {
 	if !loggingSystemDisplayTime {
 		text = message
 	}
-	mailBody = append(mailBody, text)
+	if section, ok := logSections[logger]; ok {
+		section.mail = append(section.mail, text)
+	} else {
+		mailBody = append(mailBody, text)
+	}
 
 	if !quietFlag {
 		if w == os.Stdout && loggingSystemDisplayTime {
@@ -132,7 +198,7 @@ func main() {
 	// to processor so we can capture as much as possible via E-Mail
 	// if so configured.
 
-	if flag.NArg() != 0 {
+	if flag.NArg() != 0 && flag.Arg(0) != "secrets" {
 		logError(nil, fmt.Sprint("Error: Unrecognized arguments specified on command line: ", flag.Args()))
 		os.Exit(2)
 	}
@@ -143,12 +209,27 @@ func main() {
 		os.Exit(0)
 	}
 
+	// If the configuration schema was requested, write it and exit
+	if cmdPrintSchema {
+		if err := printSchema(os.Stdout); err != nil {
+			logError(nil, fmt.Sprintf("Error: %s", err))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Determine the location of the global storage directory
 	globalStorageDirectory, err = getStorageDirectory(cmdStorageDir)
 	if err != nil {
 		os.Exit(2)
 	}
 
+	// Manage the secrets vault (before loading the global configuration, which
+	// may refer to secrets in the vault)
+	if flag.Arg(0) == "secrets" {
+		os.Exit(performSecretsCommand(flag.Args()[1:], os.Stdin, os.Stdout))
+	}
+
 	// Parse the global configuration file, if any
 	if err := loadGlobalConfig(globalStorageDirectory, cmdGlobalConfig); err != nil {
 		quietFlag = false
@@ -156,8 +237,23 @@ func main() {
 		os.Exit(2)
 	}
 
+	// If we're interrupted, cancel operations (terminating duplicacy gracefully)
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
 	// Perform our backup operations
-	returnStatus, err := processArguments()
+	returnStatus, err := processArguments(ctx)
+	os.Exit(reportResult(returnStatus, err))
+}
+
+// Report the result of processing: if it didn't succeed, log the error and notify
+// that it was skipped or failed. Returns the exit code.
+func reportResult(returnStatus int, err error) int {
 	if err != nil {
 		// Note that after this "if" test, err is no longer important;
 		// we'll reuse that for email status to set failure exit code
@@ -168,6 +264,11 @@ func main() {
 			logError(nil, fmt.Sprintf("Warning: %s", err))
 			err = notifyOfSkip()
 
+		case 6201:
+			// Notify that remaining operations have been deferred (not a failure)
+			logError(nil, fmt.Sprintf("Warning: %s", err))
+			err = notifyOfDefer()
+
 		default:
 			// Notify that the backup process has failed
 			logError(nil, fmt.Sprintf("Error: %s", err))
@@ -179,10 +280,10 @@ func main() {
 		}
 	}
 
-	os.Exit(returnStatus)
+	return returnStatus
 }
 
-func processArguments() (int, error) {
+func processArguments(ctx context.Context) (int, error) {
 
 	if cmdAll {
 		cmdBackup, cmdCopy, cmdPrune, cmdCheck = true, true, true, true
@@ -190,6 +291,9 @@ func processArguments() (int, error) {
 	if debugFlag {
 		verboseFlag = true
 	}
+	if cmdDryRunDuplicacy {
+		cmdDryRun = true
+	}
 
 	// Verbose overrides quiet
 	if verboseFlag && quietFlag {
@@ -202,6 +306,11 @@ func processArguments() (int, error) {
 		logError(nil, "Notice: Quiet mode refused; a failure notifier should be configured")
 	}
 
+	// Notifications may be suppressed entirely
+	if cmdNoNotify {
+		onStartNotifiers, onSkipNotifiers, onDeferNotifiers, onSuccessNotifiers, onFailureNotifiers = nil, nil, nil, nil, nil
+	}
+
 	// Handle request to test Notifications
 	// if testmailFlag is set; only email notifications will be tested
 	if testNotificationsFlag {
@@ -212,10 +321,61 @@ func processArguments() (int, error) {
 		return 0, nil
 	}
 
+	// Migrate configuration files (global configuration, and -f or -all-configs), if requested
+	if cmdMigrateConfig {
+		filenames, err := migrationFiles()
+		if err != nil {
+			return 2, err
+		}
+		return performMigrateConfig(filenames, cmdDryRun, os.Stdout), nil
+	}
+
+	// Run as a daemon, performing operations as scheduled, if requested
+	if cmdDaemon {
+		if cmdAllConfigs {
+			return 2, errors.New("Options -daemon and -all-configs are mutually exclusive")
+		}
+		return performDaemon(ctx)
+	}
+
+	// Catch up runs of scheduled operations that were missed, if requested
+	if cmdCatchUp {
+		if cmdAllConfigs {
+			return 2, errors.New("Options -catch-up and -all-configs are mutually exclusive")
+		}
+		if cmdBackup || cmdCopy || cmdPrune || cmdCheck {
+			return 2, errors.New("Option -catch-up performs the scheduled operations (don't specify operations)")
+		}
+		return performCatchUp(ctx)
+	}
+
+	// Install the schedule in the system scheduler (or show it, for a dry run), if requested
+	if cmdInstallSchedule != "" {
+		if cmdAllConfigs {
+			return 2, errors.New("Options -install-schedule and -all-configs are mutually exclusive")
+		}
+		return performInstallSchedule(ctx, cmdInstallSchedule, cmdDryRun, os.Stdout)
+	}
+
+	// Run every configuration (each by a separate duplicacy-util process), if requested
+	if cmdAllConfigs {
+		if cmdConfig != "" {
+			return 2, errors.New("Options -all-configs and -f are mutually exclusive")
+		}
+		if !cmdBackup && !cmdCopy && !cmdPrune && !cmdCheck && !cmdDoctor && !cmdShowConfig {
+			return 1, errors.New("No operations to perform (specify -backup, -copy, -prune, -check, or -a (all))")
+		}
+		return performAllConfigs(ctx)
+	}
+
 	if cmdConfig == "" {
 		return 2, errors.New("Mandatory parameter -f is not specified (must be specified)")
 	}
 
+	if cmdResume && cmdRestart {
+		return 2, errors.New("Options -resume and -restart are mutually exclusive")
+	}
+
 	// Parse the configuration file and check for errors
 	// (Errors are printed to stderr as well as returned)
 	configFile.setConfig(cmdConfig)
@@ -223,20 +383,45 @@ func processArguments() (int, error) {
 		return 1, nil
 	}
 
+	// Check the configuration rather than performing operations, if requested
+	if cmdDoctor {
+		return performDoctor(ctx, os.Stdout), nil
+	}
+
+	// Show the configuration rather than performing operations, if requested
+	if cmdShowConfig {
+		return performShowConfig(os.Stdout), nil
+	}
+
 	// Everything is loaded; make sure we hae something to do
 	if !cmdBackup && !cmdCopy && !cmdPrune && !cmdCheck {
 		return 1, errors.New("No operations to perform (specify -backup, -copy, -prune, -check, or -a (all))")
 	}
 
+	// Replay a scenario rather than running duplicacy, if requested
+	if cmdSimulate != "" {
+		runner, err := newSimulatedRunner(cmdSimulate)
+		if err != nil {
+			return 2, err
+		}
+		duplicacyRunner = runner
+		logMessage(nil, fmt.Sprint("Simulating duplicacy with scenario: ", cmdSimulate))
+	}
+
+	// For a dry run, just show what would be done (no lock, log files, or notifications)
+	if cmdDryRun {
+		return performDryRun(ctx), nil
+	}
+
 	// Perform processing. Note that int is returned for two reasons:
 	// 1. We need to know the proper exit code
 	// 2. We want defer statements to execute, so we can't use os.Exit here
 
 	logMessage(nil, fmt.Sprintf("duplicacy-util starting, version: %s, Git Hash: %s", versionText, gitHash))
-	return obtainLock()
+	return obtainLock(ctx)
 }
 
-func obtainLock() (int, error) {
+func obtainLock(ctx context.Context) (int, error) {
 	// Obtain a lock to make sure we don't overlap operations against a configuration
 	lockfile := filepath.Join(globalLockDir, cmdConfig+".lock")
 	fileLock := flock.New(lockfile)
@@ -257,7 +442,17 @@ func obtainLock() (int, error) {
 	defer fileLock.Unlock()
 
 	// Perform operations (backup or whatever)
-	if err := performBackup(); err != nil {
+	if err := performBackup(ctx); err != nil {
+		var prompt *promptError
+		if errors.As(err, &prompt) {
+			return 510, fmt.Errorf("backup failed: %s", prompt)
+		}
+		if errors.Is(err, errOperationsFailed) {
+			return 501, errors.New("backup completed with failures, check the logs for details")
+		}
+		if errors.Is(err, errDeferred) {
+			return 6201, errors.New("backup deferred until the next maintenance window")
+		}
 		return 500, errors.New("backup failed, check the logs for details")
 	}
 
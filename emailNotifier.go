@@ -42,27 +42,55 @@ type EmailNotifier struct{}
 // NotifyOfStart is triggered when backup process starts
 func (notifier EmailNotifier) NotifyOfStart() error {
 	subject := fmt.Sprintf("duplicacy-util: Backup started for configuration %s", cmdConfig)
+	if catchUpNote != "" {
+		subject += " (catch-up)"
+	}
 	return notifier.email(subject, []string{}, mailBody)
 }
 
 // NotifyOfSuccess is triggered when backup successfully finishes
 func (notifier EmailNotifier) NotifyOfSuccess() error {
-	subject := fmt.Sprintf("duplicacy-util: Backup results for configuration %s (success)", cmdConfig)
+	subject := fmt.Sprintf("duplicacy-util: Backup results for configuration %s (%s)", cmdConfig, subjectStatus("success"))
 	return notifier.email(subject, htmlGenerateBody(), mailBody)
 }
 
 // NotifyOfSkip is triggered when a backup is skipped (if already running)
 func (notifier EmailNotifier) NotifyOfSkip() error {
-	subject := fmt.Sprintf("duplicacy-util: Backup results for configuration %s (skipped)", cmdConfig)
+	subject := fmt.Sprintf("duplicacy-util: Backup results for configuration %s (%s)", cmdConfig, subjectStatus("skipped"))
+	return notifier.email(subject, htmlGenerateBody(), mailBody)
+}
+
+// NotifyOfDefer is triggered when remaining operations are deferred (outside the
+// maintenance window)
+func (notifier EmailNotifier) NotifyOfDefer() error {
+	subject := fmt.Sprintf("duplicacy-util: Backup results for configuration %s (%s)", cmdConfig, subjectStatus("deferred"))
 	return notifier.email(subject, htmlGenerateBody(), mailBody)
 }
 
 // NotifyOfFailure is triggered when a failure occurred during backup
 func (notifier EmailNotifier) NotifyOfFailure() error {
-	subject := fmt.Sprintf("duplicacy-util: Backup results for configuration %s (FAILURE)", cmdConfig)
+	subject := fmt.Sprintf("duplicacy-util: Backup results for configuration %s (%s)", cmdConfig, subjectStatus("FAILURE"))
 	return notifier.email(subject, htmlGenerateBody(), mailBody)
 }
 
+// NotifyOfSummary is triggered when all configurations have been run (-all-configs)
+func (notifier EmailNotifier) NotifyOfSummary(results []configResult, failed bool) error {
+	status := "success"
+	if failed {
+		status = "FAILURE"
+	}
+	subject := fmt.Sprintf("duplicacy-util: Results for %d configurations (%s)", len(results), status)
+	return notifier.email(subject, htmlGenerateSummaryBody(results), mailBody)
+}
+
+// Status for the subject (noting if the run was a catch-up of a missed run)
+func subjectStatus(status string) string {
+	if catchUpNote != "" {
+		return status + ", catch-up"
+	}
+	return status
+}
+
 // Email notification and return error if something went wrong
 func (EmailNotifier) email(subject string, bodyHTML []string, bodyText []string) error {
 	if err := sendMailMessage(subject, bodyHTML, bodyText); err != nil {
@@ -82,6 +110,7 @@ func useNewConfigStyle() {
 	emailAuthUsername = viper.GetString("email.authUsername")
 
 	// Allow environment variable DU_EMAIL_AUTH_PASSWORD to override authPassword in configuration
+	// (authPassword may also be a secret reference, like "env:SMTP_PASSWORD")
 	if emailAuthPassword = os.Getenv("DU_EMAIL_AUTH_PASSWORD"); emailAuthPassword == "" {
 		emailAuthPassword = viper.GetString("email.authPassword")
 	}
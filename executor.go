@@ -15,10 +15,18 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"io"
+	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Prefix for output lines written by the command to stderr (rather than stdout)
+const stderrPrefix = "[stderr] "
+
 var execCommand = exec.Command
 
 /*
@@ -28,22 +36,140 @@ func executorStdout(cmdName string, cmdArgs []string) (stdOut []byte, err error)
 }
 */
 
-func executor(cmdName string, cmdArgs []string, defDir string, output func(string)) error {
+// Execute a command, passing each line of output to the output function. Any
+// environment variables in env (like "NAME=value") are added to the environment.
+func executor(ctx context.Context, cmdName string, cmdArgs []string, defDir string, env []string, output func(string)) error {
+	return execute(ctx, cmdName, cmdArgs, defDir, env, output, false)
+}
+
+// Execute duplicacy (like executor). If duplicacy prompts for input, it is
+// terminated and a *promptError returned. Only duplicacy is checked for prompts;
+// other commands (like hooks) may well mention keyrings or ask questions in
+// their output.
+func duplicacyExecutor(ctx context.Context, cmdName string, cmdArgs []string, defDir string, env []string, output func(string)) error {
+	return execute(ctx, cmdName, cmdArgs, defDir, env, output, true)
+}
+
+func execute(ctx context.Context, cmdName string, cmdArgs []string, defDir string, env []string, output func(string), detectPrompts bool) error {
 	cmd := execCommand(cmdName, cmdArgs...)
 	cmd.Dir = defDir
+	if len(env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, env...)
+	}
+	setProcessGroup(cmd)
+
+	// Never pass along our input (under cron, who knows what that is); a command
+	// that tries to read input gets end of file
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer stdin.Close()
+	cmd.Stdin = stdin
+
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
 	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
 	if err = cmd.Start(); err != nil {
 		return err
 	}
 
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		output(scanner.Text())
+	// If the context is cancelled (timeout or shutdown) before the command exits,
+	// ask the process tree to terminate, then kill it if it doesn't go quietly
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			terminateProcessTree(cmd, false)
+			select {
+			case <-done:
+			case <-time.After(terminateGracePeriod):
+				terminateProcessTree(cmd, true)
+			}
+		case <-done:
+		}
+	}()
+
+	// If the command prompts for input, terminate it right away (rather than
+	// waiting for it to give up, or hang)
+	var prompted *promptError
+	var promptOnce sync.Once
+	checkPrompt := func(text string) {
+		if !detectPrompts {
+			return
+		}
+		if prompt := detectPrompt(text); prompt != nil {
+			promptOnce.Do(func() {
+				prompted = prompt
+				terminateProcessTree(cmd, true)
+			})
+		}
+	}
+
+	// Both streams are read concurrently, so serialize calls to output(). Lines
+	// from stderr are tagged so the log and notifications show where they came from.
+	// Prompts don't end with a newline, so partial lines are checked for prompts.
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	emit := func(line string) {
+		mutex.Lock()
+		output(line)
+		mutex.Unlock()
+	}
+	scanStream := func(stream io.Reader, prefix string) {
+		defer wg.Done()
+		buffer := make([]byte, 4096)
+		pending := ""
+		for {
+			n, err := stream.Read(buffer)
+			pending += string(buffer[:n])
+			for {
+				i := strings.IndexByte(pending, '\n')
+				if i < 0 {
+					break
+				}
+				line := strings.TrimSuffix(pending[:i], "\r")
+				emit(prefix + line)
+				checkPrompt(line)
+				pending = pending[i+1:]
+			}
+
+			if pending != "" {
+				checkPrompt(pending)
+			}
+			if err != nil {
+				if pending != "" {
+					emit(prefix + pending)
+				}
+				return
+			}
+		}
 	}
 
+	wg.Add(2)
+	go scanStream(stdout, "")
+	go scanStream(stderr, stderrPrefix)
+	wg.Wait()
+
 	err = cmd.Wait()
+	close(done)
+
+	if prompted != nil {
+		return prompted
+	}
+
+	// Report cancellation rather than the resulting (less useful) exit status
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
 	return err
 }
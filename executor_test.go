@@ -15,11 +15,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Testing code which invokes os/exec is a little tricky (you need to set up
@@ -44,7 +48,7 @@ func TestRunExecutor(t *testing.T) {
 
 	execCommand = fakeExecCommand
 	defer func() { execCommand = exec.Command }()
-	err := executor(duplicacyPath, []string{"-some", "-fake", "-args"}, configFile.repoDir, anon)
+	err := executor(context.Background(), duplicacyPath, []string{"-some", "-fake", "-args"}, configFile.repoDir, nil, anon)
 	if err != nil {
 		t.Errorf("Expected nil error, got %#v", err)
 	}
@@ -56,12 +60,144 @@ func TestRunExecutor(t *testing.T) {
 	}
 }
 
+// Output written to stderr should be passed along (tagged) with stdout output
+func TestRunExecutor_Stderr(t *testing.T) {
+	outputArray := []string{}
+	anon := func(s string) { outputArray = append(outputArray, s) }
+
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+	err := executor(context.Background(), duplicacyPath, []string{"-stderr"}, configFile.repoDir, nil, anon)
+	if err == nil {
+		t.Errorf("Expected error from failed command, got nil")
+	}
+
+	// Streams are read concurrently, so order between streams is not guaranteed
+	sort.Strings(outputArray)
+	expectedOutput := "This is the expected\n" + stderrPrefix + "panic: something bad happened\n"
+	actualOutput := strings.Join(outputArray, "\n") + "\n"
+	if actualOutput != expectedOutput {
+		t.Errorf("result was incorrect, got '%s', expected '%s'.", actualOutput, expectedOutput)
+	}
+}
+
+// A command that runs past its deadline should be terminated
+func TestRunExecutor_Timeout(t *testing.T) {
+	anon := func(s string) {}
+
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	startTime := time.Now()
+	err := executor(ctx, duplicacyPath, []string{"-sleep"}, configFile.repoDir, nil, anon)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded error, got %#v", err)
+	}
+	if elapsed := time.Since(startTime); elapsed > 10*time.Second {
+		t.Errorf("Command was not terminated promptly (took %s)", elapsed)
+	}
+}
+
+// A command that prompts for input (with no newline) should be terminated at once
+func TestRunExecutor_Prompt(t *testing.T) {
+	outputArray := []string{}
+	anon := func(s string) { outputArray = append(outputArray, s) }
+
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	startTime := time.Now()
+	err := duplicacyExecutor(context.Background(), duplicacyPath, []string{"-prompt"}, configFile.repoDir, nil, anon)
+	var prompt *promptError
+	if !errors.As(err, &prompt) {
+		t.Fatalf("Expected prompt error, got %#v", err)
+	}
+	if prompt.credential != "storage password (password)" {
+		t.Errorf("Incorrect credential, got '%s'", prompt.credential)
+	}
+	if elapsed := time.Since(startTime); elapsed > 10*time.Second {
+		t.Errorf("Command was not terminated promptly (took %s)", elapsed)
+	}
+
+	expectedOutput := "Storage set to b2://bucket\nEnter storage password:\n"
+	actualOutput := strings.Join(outputArray, "\n") + "\n"
+	if actualOutput != expectedOutput {
+		t.Errorf("result was incorrect, got '%s', expected '%s'.", actualOutput, expectedOutput)
+	}
+}
+
+// Commands other than duplicacy (like hooks) aren't checked for prompts
+func TestRunExecutor_NoPromptDetection(t *testing.T) {
+	anon := func(s string) {}
+
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err := executor(ctx, "hook", []string{"-prompt"}, configFile.repoDir, nil, anon)
+	var prompt *promptError
+	if errors.As(err, &prompt) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded error (no prompt detection), got %#v", err)
+	}
+}
+
 // TestExecutorHelperProcess isn't a real test; it's a helper process for TestRunExecutor*
 func TestExecutorHelperProcess(t *testing.T) {
 	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
 		return
 	}
-	// some code here to check arguments perhaps?
+	for _, arg := range os.Args {
+		if arg == "-sleep" {
+			time.Sleep(30 * time.Second)
+			os.Exit(0)
+		}
+		if arg == "-prompt" {
+			// Wait for a response that never comes (like reading from a terminal)
+			fmt.Fprintf(os.Stdout, "Storage set to b2://bucket\nEnter storage password:")
+			time.Sleep(30 * time.Second)
+			os.Exit(0)
+		}
+		if arg == "-version" {
+			fmt.Fprintf(os.Stdout, "duplicacy version 2.7.2 (175ADB)\n")
+			os.Exit(0)
+		}
+		if arg == "-env" {
+			for _, variable := range os.Environ() {
+				if strings.HasPrefix(variable, "DUPLICACY_UTIL_") {
+					fmt.Fprintln(os.Stdout, variable)
+				}
+			}
+			os.Exit(0)
+		}
+		if arg == "failing-config" || arg == "partial-config" {
+			// Run of duplicacy-util (with -all-configs) that fails
+			fmt.Fprintf(os.Stdout, "Running %s\n", arg)
+			if arg == "partial-config" {
+				os.Exit(501)
+			}
+			os.Exit(500)
+		}
+		if arg == "prompting-config" {
+			// Run of duplicacy-util (with -all-configs) where duplicacy prompted
+			fmt.Fprintf(os.Stdout, "Enter the keyring password:\nDo you want to continue? [y/n]")
+			os.Exit(510)
+		}
+		if arg == "-fail" {
+			fmt.Fprintf(os.Stdout, "Something went wrong\n")
+			os.Exit(1)
+		}
+		if arg == "-stderr" {
+			fmt.Fprintf(os.Stdout, "This is the expected\n")
+			fmt.Fprintf(os.Stderr, "panic: something bad happened\n")
+			os.Exit(2)
+		}
+	}
+
 	fmt.Fprintf(os.Stdout, "This is the expected\noutput\n")
 	os.Exit(0)
 }
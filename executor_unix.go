@@ -0,0 +1,48 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Time to wait after SIGTERM before forcibly killing a cancelled command
+var terminateGracePeriod = 30 * time.Second
+
+// Run the command in its own process group so the whole process tree can be signalled
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Terminate the process tree: SIGTERM is sent first, SIGKILL if force is set
+func terminateProcessTree(cmd *exec.Cmd, force bool) error {
+	signal := syscall.SIGTERM
+	if force {
+		signal = syscall.SIGKILL
+	}
+
+	// Negative PID signals every process in the process group
+	return syscall.Kill(-cmd.Process.Pid, signal)
+}
+
+// Command (and arguments) to run a command line with the shell
+func shellCommand(command string) (string, []string) {
+	return "/bin/sh", []string{"-c", command}
+}
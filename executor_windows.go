@@ -0,0 +1,44 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// The process tree is killed right away (see terminateProcessTree), so this only
+// allows for taskkill itself before trying again
+var terminateGracePeriod = 5 * time.Second
+
+// Windows has no process groups in the POSIX sense; taskkill /T handles the tree
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// Terminate the process tree. Without /F, taskkill only asks windows to close,
+// which does nothing for console processes without a window (like duplicacy), so
+// the tree is always killed forcibly.
+func terminateProcessTree(cmd *exec.Cmd, force bool) error {
+	return exec.Command("taskkill", "/F", "/T", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
+
+// Command (and arguments) to run a command line with the command interpreter
+func shellCommand(command string) (string, []string) {
+	return "cmd", []string{"/C", command}
+}
@@ -0,0 +1,233 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Hooks that may be defined in the "hooks" section of the repository configuration
+var hookNames = []string{
+	"preJob", "postJob",
+	"preBackup", "postBackup",
+	"preCopy", "postCopy",
+	"prePrune", "postPrune",
+	"preCheck", "postCheck",
+}
+
+// Failure policies for hooks (onFailure setting)
+const (
+	hookAbort  = "abort"
+	hookWarn   = "warn"
+	hookIgnore = "ignore"
+)
+
+// Error returned when a hook ran past its own timeout. It doesn't wrap
+// context.DeadlineExceeded, so the operation the hook runs around isn't
+// reported as timed out.
+type hookTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *hookTimeoutError) Error() string {
+	return fmt.Sprint("timed out after ", e.timeout)
+}
+
+// Read the "hooks" section of the repository configuration. A hook is either a
+// command line, or a map with command, directory, timeout and onFailure settings.
+func readHooks(v *viper.Viper) (map[string]map[string]string, error) {
+	hooks := map[string]map[string]string{}
+
+	for key := range v.GetStringMap("hooks") {
+		name := ""
+		for _, hookName := range hookNames {
+			if strings.EqualFold(key, hookName) {
+				name = hookName
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("unknown hook: hooks.%s", key)
+		}
+
+		hook := map[string]string{}
+		if command, ok := v.Get("hooks." + key).(string); ok {
+			hook["command"] = command
+		} else {
+			// Viper doesn't preserve case of keys in maps
+			for setting, value := range v.GetStringMapString("hooks." + key) {
+				switch setting {
+				case "command", "directory", "timeout":
+					hook[setting] = value
+				case "onfailure":
+					hook["onFailure"] = value
+				default:
+					return nil, fmt.Errorf("unknown setting for hook %s: %s", name, setting)
+				}
+			}
+		}
+
+		if hook["command"] == "" {
+			return nil, fmt.Errorf("missing mandatory hook field: %s.command", name)
+		}
+		if timeout, ok := hook["timeout"]; ok {
+			if duration, err := time.ParseDuration(timeout); err != nil || duration <= 0 {
+				return nil, fmt.Errorf("invalid %s timeout value: %s", name, timeout)
+			}
+		}
+		switch hook["onFailure"] {
+		case "":
+			hook["onFailure"] = hookAbort
+		case hookAbort, hookWarn, hookIgnore:
+		default:
+			return nil, fmt.Errorf("invalid %s onFailure value: %s (must be abort, warn, or ignore)", name, hook["onFailure"])
+		}
+
+		hooks[name] = hook
+	}
+
+	return hooks, nil
+}
+
+// Name of the pre/post hook for an operation (checkpointNone for the job)
+func hookName(prefix string, operation int) string {
+	if operation == checkpointNone {
+		return prefix + "Job"
+	}
+
+	name := checkpointName(operation)
+	return prefix + strings.ToUpper(name[:1]) + name[1:]
+}
+
+// Environment variables describing the operation for a hook
+func hookEnvironment(operation int, info operationInfo, status string) []string {
+	env := []string{"DUPLICACY_UTIL_CONFIG=" + cmdConfig}
+
+	switch info := info.(type) {
+	case nil:
+		env = append(env, "DUPLICACY_UTIL_OPERATION=job")
+	case copyConfig:
+		env = append(env, "DUPLICACY_UTIL_OPERATION=copy", "DUPLICACY_UTIL_STORAGE="+info.To,
+			"DUPLICACY_UTIL_FROM_STORAGE="+info.From)
+	default:
+		env = append(env, "DUPLICACY_UTIL_OPERATION="+checkpointName(operation), "DUPLICACY_UTIL_STORAGE="+operationStorages(info)[0])
+	}
+
+	if status != "" {
+		env = append(env, "DUPLICACY_UTIL_STATUS="+status)
+	}
+
+	return env
+}
+
+// Run a hook (if it is defined). Output from the hook is saved in the log (and
+// E-Mail). Returns an error if the hook failed and its onFailure setting is abort.
+func runHook(ctx context.Context, logger *log.Logger, name string, operation int, info operationInfo, status string) error {
+	hook, ok := configFile.hooks[name]
+	if !ok {
+		return nil
+	}
+
+	logMessage(logger, fmt.Sprintf("Running %s hook: %s", name, hook["command"]))
+
+	var hookCtx context.Context
+	var cancel context.CancelFunc
+	timeout, _ := time.ParseDuration(hook["timeout"])
+	if timeout > 0 {
+		hookCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		hookCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	directory := hook["directory"]
+	if directory == "" {
+		directory = configFile.repoDir
+	}
+
+	// Hooks aren't checked for prompts (only duplicacy is)
+	cmdName, cmdArgs := shellCommand(hook["command"])
+	err := executor(hookCtx, cmdName, cmdArgs, directory, hookEnvironment(operation, info, status), func(line string) {
+		logMessage(logger, fmt.Sprint("  ", line))
+	})
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		err = &hookTimeoutError{timeout: timeout}
+	}
+
+	switch hook["onFailure"] {
+	case hookIgnore:
+		logger.Printf("  %s hook failed (ignored): %s", name, err)
+		return nil
+	case hookWarn:
+		logError(logger, fmt.Sprintf("  Warning: %s hook failed: %s", name, err))
+		return nil
+	}
+
+	logError(logger, fmt.Sprintf("  Error: %s hook failed: %s", name, err))
+	return fmt.Errorf("%s hook failed: %w", name, err)
+}
+
+// Execute duplicacy for an operation (with credentials for its storages), along
+// with the pre/post hooks for the operation. The post hook is run (with the status
+// of the operation) even if the pre hook or duplicacy failed, so it can undo what
+// the pre hook did.
+func executeOperation(ctx context.Context, logger *log.Logger, operation int, info operationInfo, cmdArgs []string, output func(string)) (int, error) {
+	attempts, err := 0, runHook(ctx, logger, hookName("pre", operation), operation, info, "")
+
+	var env []string
+	if err == nil {
+		if env, err = credentialEnvironment(info); err != nil {
+			logError(logger, fmt.Sprint("Error: ", err))
+		}
+	}
+	if err == nil {
+		attempts, err = executeDuplicacy(ctx, logger, info, cmdArgs, env, output)
+	}
+
+	// There's no input for a prompt, so the credential must be configured
+	var prompt *promptError
+	if errors.As(err, &prompt) {
+		if prompt.storage == "" {
+			prompt.storage = strings.Join(operationStorages(info), " or ")
+		}
+		logError(logger, fmt.Sprint("Error: ", prompt, " (configure credentials for the storage)"))
+
+		resultsMutex.Lock()
+		if promptFailure == nil {
+			promptFailure = prompt
+		}
+		resultsMutex.Unlock()
+	}
+
+	status := statusSuccess
+	if err != nil {
+		status = failureStatus(err)
+	}
+	if hookErr := runHook(ctx, logger, hookName("post", operation), operation, info, status); err == nil {
+		err = hookErr
+	}
+
+	return attempts, err
+}
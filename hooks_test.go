@@ -0,0 +1,150 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func hookTestSetup(hooks map[string]map[string]string) func() {
+	savedConfig := configFile
+	savedMailBody := mailBody
+	configFile = newConfigurationFile()
+	configFile.hooks = hooks
+	cmdConfig = "hooktest"
+	quietFlag = true
+	mailBody = []string{}
+	execCommand = fakeExecCommand
+
+	return func() {
+		configFile = savedConfig
+		mailBody = savedMailBody
+		quietFlag = false
+		execCommand = exec.Command
+	}
+}
+
+func TestHookName(t *testing.T) {
+	tests := []struct {
+		prefix    string
+		operation int
+		expected  string
+	}{
+		{"pre", checkpointNone, "preJob"},
+		{"post", checkpointBackup, "postBackup"},
+		{"pre", checkpointCopy, "preCopy"},
+		{"post", checkpointPrune, "postPrune"},
+		{"pre", checkpointCheck, "preCheck"},
+	}
+
+	for _, test := range tests {
+		if name := hookName(test.prefix, test.operation); name != test.expected {
+			t.Errorf("Incorrect hook name, got %q, expected %q", name, test.expected)
+		}
+	}
+}
+
+func TestRunHook_Environment(t *testing.T) {
+	defer hookTestSetup(map[string]map[string]string{
+		"postCopy": {"command": "-env", "onFailure": hookAbort},
+	})()
+
+	logger := log.New(&bytes.Buffer{}, "", 0)
+	info := copyConfig{From: "b2", To: "azure"}
+	if err := runHook(context.Background(), logger, "postCopy", checkpointCopy, info, statusSuccess); err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+
+	// Hook output (the environment variables) should be in the E-Mail body
+	output := []string{}
+	for _, line := range mailBody[1:] {
+		fields := strings.Fields(line)
+		output = append(output, fields[len(fields)-1])
+	}
+	sort.Strings(output)
+	expected := []string{
+		"DUPLICACY_UTIL_CONFIG=hooktest",
+		"DUPLICACY_UTIL_FROM_STORAGE=b2",
+		"DUPLICACY_UTIL_OPERATION=copy",
+		"DUPLICACY_UTIL_STATUS=Success",
+		"DUPLICACY_UTIL_STORAGE=azure",
+	}
+	if strings.Join(output, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Incorrect hook output, got %v, expected %v", output, expected)
+	}
+}
+
+func TestRunHook_OnFailure(t *testing.T) {
+	tests := []struct {
+		onFailure string
+		failed    bool
+	}{
+		{hookAbort, true},
+		{hookWarn, false},
+		{hookIgnore, false},
+	}
+
+	for _, test := range tests {
+		restore := hookTestSetup(map[string]map[string]string{
+			"preJob": {"command": "-fail", "onFailure": test.onFailure},
+		})
+
+		logger := log.New(&bytes.Buffer{}, "", 0)
+		err := runHook(context.Background(), logger, "preJob", checkpointNone, nil, "")
+		if (err != nil) != test.failed {
+			t.Errorf("Incorrect result for onFailure %s, got %v", test.onFailure, err)
+		}
+		restore()
+	}
+}
+
+func TestRunHook_NotDefined(t *testing.T) {
+	defer hookTestSetup(map[string]map[string]string{})()
+
+	logger := log.New(&bytes.Buffer{}, "", 0)
+	if err := runHook(context.Background(), logger, "preBackup", checkpointBackup, backupConfig{Name: "b2"}, ""); err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+	if len(mailBody) != 0 {
+		t.Errorf("Expected no output for undefined hook, got %v", mailBody)
+	}
+}
+
+func TestRunHook_Timeout(t *testing.T) {
+	defer hookTestSetup(map[string]map[string]string{
+		"preBackup": {"command": "-prompt", "timeout": "200ms", "onFailure": hookAbort},
+	})()
+
+	// Output that looks like a prompt doesn't terminate a hook; the timeout does
+	logger := log.New(&bytes.Buffer{}, "", 0)
+	err := runHook(context.Background(), logger, "preBackup", checkpointBackup, backupConfig{Name: "b2"}, "")
+	var prompt *promptError
+	var hookTimeout *hookTimeoutError
+	if errors.As(err, &prompt) || !errors.As(err, &hookTimeout) {
+		t.Errorf("Expected hook to time out, got %v", err)
+	}
+
+	// The hook timed out, not the operation
+	if errors.Is(err, context.DeadlineExceeded) || failureStatus(err) != statusHookTimedOut {
+		t.Errorf("Expected hook timeout status, got %q (%v)", failureStatus(err), err)
+	}
+}
@@ -0,0 +1,604 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// System schedulers that -install-schedule generates entries for
+const (
+	schedulerSystemd = "systemd"
+	schedulerCron    = "cron"
+	schedulerLaunchd = "launchd"
+	schedulerWindows = "windows"
+)
+
+// Location of the duplicacy-util binary (for the scheduled command)
+var installExecutable = os.Executable
+
+// Task Scheduler can have many triggers for a task, but a schedule needing more
+// than this is better run with -daemon
+const maxWindowsTriggers = 48
+
+// An entry for the system scheduler: a unit file, crontab fragment, plist or task
+type schedulerEntry struct {
+	filename string
+	contents string
+}
+
+// Generate (and unless -dry-run, install) entries for the system scheduler for
+// every scheduled job (-install-schedule). With -dry-run, the entries are just
+// written to w.
+func performInstallSchedule(ctx context.Context, scheduler string, dryRun bool, w io.Writer) (int, error) {
+	jobs, err := scheduledJobs()
+	if err != nil {
+		return 2, err
+	}
+	if len(jobs) == 0 {
+		return 2, errors.New("no schedules found (add a schedule section to the global or a repository configuration)")
+	}
+
+	executable, err := installExecutable()
+	if err != nil {
+		return 2, err
+	}
+	entries, err := schedulerEntries(scheduler, jobs, executable)
+	if err != nil {
+		return 2, err
+	}
+
+	if dryRun {
+		for i, entry := range entries {
+			if i != 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "# %s\n%s", entry.filename, entry.contents)
+		}
+		return 0, nil
+	}
+
+	switch scheduler {
+	case schedulerSystemd:
+		err = installSystemd(ctx, entries)
+	case schedulerCron:
+		err = installCron(ctx, jobs, entries[0])
+	case schedulerLaunchd:
+		err = installLaunchd(ctx, entries)
+	case schedulerWindows:
+		err = installWindows(ctx, entries)
+	}
+	if err != nil {
+		return 2, err
+	}
+
+	return 0, nil
+}
+
+// Entries for the system scheduler (each running the jobs' operations as
+// duplicacy-util -f <config> would, with the current -sd and -g options)
+func schedulerEntries(scheduler string, jobs []*scheduledJob, executable string) ([]schedulerEntry, error) {
+	entries := []schedulerEntry{}
+	names := map[string]bool{}
+	cronLines := []string{}
+	for _, job := range jobs {
+		// Name of the job in the scheduler, like duplicacy-util-photos-backup-copy
+		name := "duplicacy-util-" + job.config + "-" + strings.Join(job.operations, "-")
+		for i := 2; names[name]; i++ {
+			name = fmt.Sprintf("duplicacy-util-%s-%s-%d", job.config, strings.Join(job.operations, "-"), i)
+		}
+		names[name] = true
+
+		args, err := scheduledArguments(job)
+		if err != nil {
+			return nil, err
+		}
+
+		switch scheduler {
+		case schedulerSystemd:
+			entries = append(entries,
+				schedulerEntry{name + ".service", systemdService(job, executable, args)},
+				schedulerEntry{name + ".timer", systemdTimer(job)})
+		case schedulerCron:
+			cronLines = append(cronLines, fmt.Sprintf("%s %s", job.schedule, commandLine(executable, args)))
+		case schedulerLaunchd:
+			entries = append(entries, schedulerEntry{"com." + name + ".plist", launchdPlist("com."+name, job, executable, args)})
+		case schedulerWindows:
+			task, err := windowsTask(job, executable, args)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, schedulerEntry{name + ".xml", task})
+		default:
+			return nil, fmt.Errorf("unknown scheduler %s (must be %s, %s, %s or %s)", scheduler,
+				schedulerSystemd, schedulerCron, schedulerLaunchd, schedulerWindows)
+		}
+	}
+
+	if scheduler == schedulerCron {
+		entries = append(entries, schedulerEntry{"crontab", strings.Join(cronLines, "\n") + "\n"})
+	}
+
+	return entries, nil
+}
+
+// Arguments for duplicacy-util to perform the operations of a job
+func scheduledArguments(job *scheduledJob) ([]string, error) {
+	args := []string{}
+	if cmdStorageDir != "" {
+		directory, err := filepath.Abs(cmdStorageDir)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-sd", directory)
+	}
+	if cmdGlobalConfig != "" {
+		filename, err := filepath.Abs(cmdGlobalConfig)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-g", filename)
+	}
+	args = append(args, "-f", job.config)
+	for _, operation := range job.operations {
+		if operation == "all" {
+			operation = "a"
+		}
+		args = append(args, "-"+operation)
+	}
+
+	return args, nil
+}
+
+// Values (in the range minimum to maximum) in a field of a cron schedule, or nil
+// if every value is included
+func cronValues(bits uint64, minimum int, maximum int) []int {
+	values := []int{}
+	for i := minimum; i <= maximum; i++ {
+		if bits&(1<<uint(i)) != 0 {
+			values = append(values, i)
+		}
+	}
+	if len(values) == maximum-minimum+1 {
+		return nil
+	}
+
+	return values
+}
+
+// Days of the month and days of the week a schedule is restricted to (nil if not
+// restricted). If either is true, a day matches if it's in either list (as with
+// cron, when both are restricted); otherwise it must be in both.
+func cronDays(schedule *cronSchedule) (days []int, weekdays []int, either bool) {
+	days = cronValues(schedule.days, 1, 31)
+	weekdays = cronValues(schedule.weekdays, 0, 6)
+	if !schedule.anyDay && !schedule.anyWeekday {
+		if days == nil || weekdays == nil {
+			return nil, nil, false
+		}
+		return days, weekdays, true
+	}
+
+	return days, weekdays, false
+}
+
+// Values from cronValues, with every value (minimum to maximum) for nil
+func orAll(values []int, minimum int, maximum int) []int {
+	if values != nil {
+		return values
+	}
+	for i := minimum; i <= maximum; i++ {
+		values = append(values, i)
+	}
+	return values
+}
+
+// Values joined by separator (after formatting each), or "*" for nil
+func joinValues(values []int, format func(int) string, separator string) string {
+	if values == nil {
+		return "*"
+	}
+	items := make([]string, len(values))
+	for i, value := range values {
+		items[i] = format(value)
+	}
+	return strings.Join(items, separator)
+}
+
+var systemdWeekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+func systemdService(job *scheduledJob, executable string, args []string) string {
+	return fmt.Sprintf(`[Unit]
+Description=duplicacy-util %s
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, job, commandLine(executable, args))
+}
+
+func systemdTimer(job *scheduledJob) string {
+	schedule := job.schedule
+	twoDigits := func(value int) string { return fmt.Sprintf("%02d", value) }
+	calendar := func(days []int, weekdays []int) string {
+		spec := fmt.Sprintf("*-%s-%s %s:%s:00",
+			joinValues(cronValues(schedule.months, 1, 12), twoDigits, ","),
+			joinValues(days, twoDigits, ","),
+			joinValues(cronValues(schedule.hours, 0, 23), twoDigits, ","),
+			joinValues(cronValues(schedule.minutes, 0, 59), twoDigits, ","))
+		if weekdays != nil {
+			spec = joinValues(weekdays, func(value int) string { return systemdWeekdays[value] }, ",") + " " + spec
+		}
+		return "OnCalendar=" + spec + "\n"
+	}
+
+	days, weekdays, either := cronDays(schedule)
+	onCalendar := calendar(days, weekdays)
+	if either {
+		onCalendar = calendar(days, nil) + calendar(nil, weekdays)
+	}
+
+	return fmt.Sprintf(`# Schedule: %s
+[Unit]
+Description=Timer for duplicacy-util %s
+
+[Timer]
+%s
+[Install]
+WantedBy=timers.target
+`, schedule, job, onCalendar)
+}
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;").Replace(s)
+}
+
+func launchdPlist(label string, job *scheduledJob, executable string, args []string) string {
+	schedule := job.schedule
+
+	// Each interval has the fields that are restricted (others match any value);
+	// an interval is needed for each combination of restricted values
+	intervals := []map[string]int{{}}
+	combine := func(intervals []map[string]int, key string, values []int) []map[string]int {
+		if values == nil {
+			return intervals
+		}
+		combined := []map[string]int{}
+		for _, interval := range intervals {
+			for _, value := range values {
+				next := map[string]int{key: value}
+				for k, v := range interval {
+					next[k] = v
+				}
+				combined = append(combined, next)
+			}
+		}
+		return combined
+	}
+	intervals = combine(intervals, "Month", cronValues(schedule.months, 1, 12))
+	intervals = combine(intervals, "Hour", cronValues(schedule.hours, 0, 23))
+	intervals = combine(intervals, "Minute", cronValues(schedule.minutes, 0, 59))
+	days, weekdays, either := cronDays(schedule)
+	if either {
+		intervals = append(combine(intervals, "Day", days), combine(intervals, "Weekday", weekdays)...)
+	} else {
+		intervals = combine(combine(intervals, "Day", days), "Weekday", weekdays)
+	}
+
+	var plist strings.Builder
+	plist.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+        <key>Label</key>
+        <string>` + xmlEscape(label) + `</string>
+        <key>ProgramArguments</key>
+        <array>
+`)
+	for _, arg := range append([]string{executable}, args...) {
+		plist.WriteString("                <string>" + xmlEscape(arg) + "</string>\n")
+	}
+	plist.WriteString(`        </array>
+        <key>RunAtLoad</key>
+        <false/>
+        <key>StartCalendarInterval</key>
+        <array>
+`)
+	for _, interval := range intervals {
+		plist.WriteString("                <dict>\n")
+		keys := []string{}
+		for key := range interval {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			plist.WriteString(fmt.Sprintf("                        <key>%s</key>\n                        <integer>%d</integer>\n", key, interval[key]))
+		}
+		plist.WriteString("                </dict>\n")
+	}
+	plist.WriteString(`        </array>
+</dict>
+</plist>
+`)
+
+	return plist.String()
+}
+
+var windowsWeekdays = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+var windowsMonths = []string{"", "January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+
+// Command line argument for Windows (quoted if necessary)
+func windowsArgument(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	return `"` + strings.Replace(arg, `"`, `\"`, -1) + `"`
+}
+
+func windowsTask(job *scheduledJob, executable string, args []string) (string, error) {
+	schedule := job.schedule
+
+	// Times of the day (in minutes since midnight). If they're evenly spaced
+	// through the day, one trigger (repeated through the day) is enough.
+	times := []int{}
+	for hour := 0; hour < 24; hour++ {
+		for minute := 0; minute < 60; minute++ {
+			if schedule.hours&(1<<uint(hour)) != 0 && schedule.minutes&(1<<uint(minute)) != 0 {
+				times = append(times, hour*60+minute)
+			}
+		}
+	}
+	repetition := ""
+	if len(times) > 1 {
+		interval := times[1] - times[0]
+		evenly := interval*len(times) == 24*60
+		for i := 1; i < len(times) && evenly; i++ {
+			evenly = times[i]-times[i-1] == interval
+		}
+		if evenly {
+			times = times[:1]
+			repetition = fmt.Sprintf("<Repetition><Interval>PT%dM</Interval><Duration>P1D</Duration></Repetition>", interval)
+		}
+	}
+
+	// Task Scheduler can't require both a day of the month and a day of the week
+	months := cronValues(schedule.months, 1, 12)
+	days, weekdays, either := cronDays(schedule)
+	if days != nil && weekdays != nil && !either {
+		return "", fmt.Errorf("schedule %s (for %s) can't be represented for Task Scheduler (both day of month and day of week are required)", schedule, job)
+	}
+
+	monthList := "<Months>" + joinValues(orAll(months, 1, 12), func(month int) string { return "<" + windowsMonths[month] + " />" }, "") + "</Months>"
+	schedules := []string{}
+	if days != nil || (weekdays == nil && months != nil) {
+		schedules = append(schedules, "<ScheduleByMonth><DaysOfMonth>"+joinValues(orAll(days, 1, 31), func(day int) string {
+			return "<Day>" + strconv.Itoa(day) + "</Day>"
+		}, "")+"</DaysOfMonth>"+monthList+"</ScheduleByMonth>")
+	}
+	if weekdays != nil {
+		daysOfWeek := "<DaysOfWeek>" + joinValues(weekdays, func(weekday int) string { return "<" + windowsWeekdays[weekday] + " />" }, "") + "</DaysOfWeek>"
+		if months == nil {
+			schedules = append(schedules, "<ScheduleByWeek><WeeksInterval>1</WeeksInterval>"+daysOfWeek+"</ScheduleByWeek>")
+		} else {
+			schedules = append(schedules, "<ScheduleByMonthDayOfWeek><Weeks><Week>1</Week><Week>2</Week><Week>3</Week><Week>4</Week><Week>Last</Week></Weeks>"+daysOfWeek+monthList+"</ScheduleByMonthDayOfWeek>")
+		}
+	}
+	if len(schedules) == 0 {
+		schedules = append(schedules, "<ScheduleByDay><DaysInterval>1</DaysInterval></ScheduleByDay>")
+	}
+
+	if len(times)*len(schedules) > maxWindowsTriggers {
+		return "", fmt.Errorf("schedule %s (for %s) needs too many triggers for Task Scheduler (use -daemon instead)", schedule, job)
+	}
+
+	date := timeNow().Format("2006-01-02")
+	var triggers strings.Builder
+	for _, minutes := range times {
+		for _, days := range schedules {
+			triggers.WriteString(fmt.Sprintf("    <CalendarTrigger>\n      <StartBoundary>%sT%02d:%02d:00</StartBoundary>\n", date, minutes/60, minutes%60))
+			if repetition != "" {
+				triggers.WriteString("      " + repetition + "\n")
+			}
+			triggers.WriteString("      " + days + "\n    </CalendarTrigger>\n")
+		}
+	}
+
+	arguments := make([]string, len(args))
+	for i, arg := range args {
+		arguments[i] = windowsArgument(arg)
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Description>duplicacy-util %s</Description>
+  </RegistrationInfo>
+  <Triggers>
+%s  </Triggers>
+  <Settings>
+    <MultipleInstancesPolicy>IgnoreNew</MultipleInstancesPolicy>
+    <ExecutionTimeLimit>PT0S</ExecutionTimeLimit>
+  </Settings>
+  <Actions>
+    <Exec>
+      <Command>%s</Command>
+      <Arguments>%s</Arguments>
+    </Exec>
+  </Actions>
+</Task>
+`, xmlEscape(job.String()), triggers.String(), xmlEscape(executable), xmlEscape(strings.Join(arguments, " "))), nil
+}
+
+// Run a command for installing scheduler entries, logging its output
+func runInstallCommand(ctx context.Context, command string, args ...string) error {
+	logMessage(nil, fmt.Sprint("Running: ", commandLine(command, args)))
+	return executor(ctx, command, args, "", nil, func(line string) {
+		logMessage(nil, "  "+strings.TrimPrefix(line, stderrPrefix))
+	})
+}
+
+// Install systemd units (for the user), and enable the timers
+func installSystemd(ctx context.Context, entries []schedulerEntry) error {
+	home, err := homedir.Dir()
+	if err != nil {
+		return err
+	}
+	directory := filepath.Join(home, ".config", "systemd", "user")
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+
+	timers := []string{}
+	for _, entry := range entries {
+		filename := filepath.Join(directory, entry.filename)
+		if err := ioutil.WriteFile(filename, []byte(entry.contents), 0644); err != nil {
+			return err
+		}
+		logMessage(nil, fmt.Sprint("Wrote ", filename))
+		if strings.HasSuffix(entry.filename, ".timer") {
+			timers = append(timers, entry.filename)
+		}
+	}
+
+	if err := runInstallCommand(ctx, "systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	return runInstallCommand(ctx, "systemctl", append([]string{"--user", "enable", "--now"}, timers...)...)
+}
+
+// Lines of a crontab with the entries for each configuration (between marker
+// comments) replaced
+func replaceCrontabEntries(crontab []string, jobs []*scheduledJob, fragment string) []string {
+	configs := []string{}
+	lines := map[string][]string{}
+	for i, line := range strings.Split(strings.TrimSuffix(fragment, "\n"), "\n") {
+		config := jobs[i].config
+		if lines[config] == nil {
+			configs = append(configs, config)
+		}
+		lines[config] = append(lines[config], line)
+	}
+
+	replaced := []string{}
+	skipping := ""
+	for _, line := range crontab {
+		switch {
+		case skipping != "":
+			if line == "# END duplicacy-util "+skipping {
+				skipping = ""
+			}
+		case strings.HasPrefix(line, "# BEGIN duplicacy-util ") && lines[strings.TrimPrefix(line, "# BEGIN duplicacy-util ")] != nil:
+			skipping = strings.TrimPrefix(line, "# BEGIN duplicacy-util ")
+		default:
+			replaced = append(replaced, line)
+		}
+	}
+	for _, config := range configs {
+		replaced = append(replaced, "# BEGIN duplicacy-util "+config)
+		replaced = append(replaced, lines[config]...)
+		replaced = append(replaced, "# END duplicacy-util "+config)
+	}
+
+	return replaced
+}
+
+// Install the entries in the user's crontab (replacing entries from a previous install)
+func installCron(ctx context.Context, jobs []*scheduledJob, entry schedulerEntry) error {
+	// There may not be a crontab yet (which crontab -l reports as an error)
+	crontab := []string{}
+	executor(ctx, "crontab", []string{"-l"}, "", nil, func(line string) {
+		if !strings.HasPrefix(line, stderrPrefix) {
+			crontab = append(crontab, line)
+		}
+	})
+
+	file, err := ioutil.TempFile("", "crontab")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(strings.Join(replaceCrontabEntries(crontab, jobs, entry.contents), "\n") + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return runInstallCommand(ctx, "crontab", file.Name())
+}
+
+// Install launchd agents (for the user), and load them
+func installLaunchd(ctx context.Context, entries []schedulerEntry) error {
+	home, err := homedir.Dir()
+	if err != nil {
+		return err
+	}
+	directory := filepath.Join(home, "Library", "LaunchAgents")
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		filename := filepath.Join(directory, entry.filename)
+		if err := ioutil.WriteFile(filename, []byte(entry.contents), 0644); err != nil {
+			return err
+		}
+		logMessage(nil, fmt.Sprint("Wrote ", filename))
+
+		// The agent may already be loaded (from a previous install)
+		executor(ctx, "launchctl", []string{"unload", filename}, "", nil, func(string) {})
+		if err := runInstallCommand(ctx, "launchctl", "load", filename); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create (or replace) tasks in Task Scheduler (in the duplicacy-util folder)
+func installWindows(ctx context.Context, entries []schedulerEntry) error {
+	for _, entry := range entries {
+		file, err := ioutil.TempFile("", "task*.xml")
+		if err != nil {
+			return err
+		}
+		_, err = file.WriteString(entry.contents)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			name := `duplicacy-util\` + strings.TrimSuffix(entry.filename, ".xml")
+			err = runInstallCommand(ctx, "schtasks", "/Create", "/TN", name, "/XML", file.Name(), "/F")
+		}
+		os.Remove(file.Name())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
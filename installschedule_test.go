@@ -0,0 +1,170 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Scheduled job (for tests of scheduler entries)
+func testScheduledJob(t *testing.T, expression string, operations ...string) *scheduledJob {
+	schedule, err := parseCron(expression)
+	if err != nil {
+		t.Fatalf("Unexpected error for %q: %s", expression, err)
+	}
+	return &scheduledJob{config: "photos", schedule: schedule, operations: operations}
+}
+
+func TestScheduledArguments(t *testing.T) {
+	savedStorageDir, savedGlobalConfig := cmdStorageDir, cmdGlobalConfig
+	defer func() { cmdStorageDir, cmdGlobalConfig = savedStorageDir, savedGlobalConfig }()
+	cmdStorageDir, cmdGlobalConfig = "test/assets", ""
+
+	args, err := scheduledArguments(testScheduledJob(t, "@daily", "backup", "all"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	directory, _ := filepath.Abs("test/assets")
+	expected := []string{"-sd", directory, "-f", "photos", "-backup", "-a"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Incorrect arguments, got %v, expected %v", args, expected)
+	}
+}
+
+func TestSystemdTimer(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"0 2 * * *", "OnCalendar=*-*-* 02:00:00\n"},
+		{"*/15 8-10 * 1,7 mon-fri", "OnCalendar=Mon,Tue,Wed,Thu,Fri *-01,07-* 08,09,10:00,15,30,45:00\n"},
+		{"30 4 1,15 * sun", "OnCalendar=*-*-01,15 04:30:00\nOnCalendar=Sun *-*-* 04:30:00\n"},
+	}
+
+	for _, test := range tests {
+		timer := systemdTimer(testScheduledJob(t, test.expression, "backup"))
+		if !strings.Contains(timer, "\n[Timer]\n"+test.expected+"\n") {
+			t.Errorf("Incorrect timer for %q, got:\n%s", test.expression, timer)
+		}
+	}
+}
+
+func TestLaunchdPlist(t *testing.T) {
+	plist := launchdPlist("com.duplicacy-util-photos-backup", testScheduledJob(t, "0 3,15 * * *", "backup"), "/usr/local/bin/duplicacy-util", []string{"-f", "photos & more"})
+	if strings.Count(plist, "<key>Hour</key>") != 2 || strings.Count(plist, "<key>Minute</key>") != 2 || strings.Contains(plist, "Weekday") {
+		t.Errorf("Incorrect calendar intervals, got:\n%s", plist)
+	}
+	if !strings.Contains(plist, "<string>photos &amp; more</string>") {
+		t.Errorf("Arguments were not escaped, got:\n%s", plist)
+	}
+
+	// Day of month or day of week
+	plist = launchdPlist("label", testScheduledJob(t, "0 0 1 * mon,fri", "check"), "duplicacy-util", nil)
+	if strings.Count(plist, "<key>Day</key>") != 1 || strings.Count(plist, "<key>Weekday</key>") != 2 || strings.Count(plist, "<dict>") != 4 {
+		t.Errorf("Incorrect calendar intervals, got:\n%s", plist)
+	}
+}
+
+func TestWindowsTask(t *testing.T) {
+	timeNow = func() time.Time { return time.Date(2020, time.May, 15, 10, 0, 0, 0, time.Local) }
+	defer func() { timeNow = time.Now }()
+
+	task, err := windowsTask(testScheduledJob(t, "*/30 * * * mon", "backup"), `C:\Program Files\duplicacy-util.exe`, []string{"-sd", `C:\Backup Files`, "-f", "photos", "-backup"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, expected := range []string{
+		"<StartBoundary>2020-05-15T00:00:00</StartBoundary>",
+		"<Repetition><Interval>PT30M</Interval><Duration>P1D</Duration></Repetition>",
+		"<ScheduleByWeek><WeeksInterval>1</WeeksInterval><DaysOfWeek><Monday /></DaysOfWeek></ScheduleByWeek>",
+		`<Command>C:\Program Files\duplicacy-util.exe</Command>`,
+		`<Arguments>-sd &quot;C:\Backup Files&quot; -f photos -backup</Arguments>`,
+	} {
+		if !strings.Contains(task, expected) {
+			t.Errorf("Task is missing %s, got:\n%s", expected, task)
+		}
+	}
+
+	if task, _ = windowsTask(testScheduledJob(t, "0 1,12 1 jan,jul *", "prune"), "duplicacy-util.exe", nil); strings.Count(task, "<CalendarTrigger>") != 2 ||
+		!strings.Contains(task, "<ScheduleByMonth><DaysOfMonth><Day>1</Day></DaysOfMonth><Months><January /><July /></Months></ScheduleByMonth>") {
+		t.Errorf("Incorrect triggers, got:\n%s", task)
+	}
+
+	if _, err = windowsTask(testScheduledJob(t, "5,10,20 * * * *", "backup"), "duplicacy-util.exe", nil); err == nil {
+		t.Error("Expected error for too many triggers")
+	}
+	if _, err = windowsTask(testScheduledJob(t, "0 0 1-7 * */1", "backup"), "duplicacy-util.exe", nil); err != nil {
+		t.Errorf("Unexpected error (day of week matches every day): %s", err)
+	}
+}
+
+func TestReplaceCrontabEntries(t *testing.T) {
+	jobs := []*scheduledJob{{config: "photos"}, {config: "photos"}, {config: "music"}}
+	crontab := []string{
+		"MAILTO=someone@example.com",
+		"# BEGIN duplicacy-util photos",
+		"0 1 * * * duplicacy-util -f photos -a",
+		"# END duplicacy-util photos",
+		"# BEGIN duplicacy-util documents",
+		"0 2 * * * duplicacy-util -f documents -a",
+		"# END duplicacy-util documents",
+	}
+
+	replaced := replaceCrontabEntries(crontab, jobs, "0 3 * * * photos backup\n@weekly photos prune\n@daily music backup\n")
+	expected := []string{
+		"MAILTO=someone@example.com",
+		"# BEGIN duplicacy-util documents",
+		"0 2 * * * duplicacy-util -f documents -a",
+		"# END duplicacy-util documents",
+		"# BEGIN duplicacy-util photos",
+		"0 3 * * * photos backup",
+		"@weekly photos prune",
+		"# END duplicacy-util photos",
+		"# BEGIN duplicacy-util music",
+		"@daily music backup",
+		"# END duplicacy-util music",
+	}
+	if !reflect.DeepEqual(replaced, expected) {
+		t.Errorf("Incorrect crontab, got:\n%s\nexpected:\n%s", strings.Join(replaced, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestPerformInstallSchedule(t *testing.T) {
+	defer setupDaemon(t)()
+	installExecutable = func() (string, error) { return "/usr/local/bin/duplicacy-util", nil }
+	defer func() { installExecutable = os.Executable }()
+
+	var output bytes.Buffer
+	if status, err := performInstallSchedule(context.Background(), schedulerCron, true, &output); status != 0 || err != nil {
+		t.Fatalf("Unexpected result: %d (%v)", status, err)
+	}
+	expected := "# crontab\n" +
+		"30 1 * * * /usr/local/bin/duplicacy-util -f documents -prune\n" +
+		"0 2 * * * /usr/local/bin/duplicacy-util -f photos -backup -copy\n"
+	if output.String() != expected {
+		t.Errorf("Incorrect output, got:\n%s\nexpected:\n%s", output.String(), expected)
+	}
+
+	if status, err := performInstallSchedule(context.Background(), "anacron", true, &output); status != 2 || err == nil {
+		t.Errorf("Expected error for unknown scheduler, got %d (%v)", status, err)
+	}
+}
@@ -0,0 +1,463 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// Old (v1.4) E-Mail settings in the global configuration, and their names in
+// the email section
+var oldEmailSettings = []struct{ old, new string }{
+	{"emailFromAddress", "fromAddress"},
+	{"emailToAddress", "toAddress"},
+	{"emailServerHostname", "serverHostname"},
+	{"emailServerPort", "serverPort"},
+	{"emailAuthUsername", "authUsername"},
+	{"emailAuthPassword", "authPassword"},
+}
+
+// Settings at the top level of a configuration file being migrated. Files are
+// rewritten in the format they were written in. Only YAML files keep the order
+// of settings (JSON and TOML files are written with settings sorted by name).
+type migrationDocument struct {
+	format string
+	keys   []string
+	values map[string]interface{}
+}
+
+// Migrate configuration files from old formats (-migrate-config): numbered
+// entries in sections of repository configurations (v1.5), and E-Mail settings
+// at the top level of the global configuration (v1.4). Changes are shown as a
+// diff, and the original file is saved with a ".bak" extension. With checkOnly,
+// no files are changed. Returns the exit code: 1 if (with checkOnly) any file
+// needs to be migrated, 2 if a file couldn't be migrated, and 0 otherwise.
+func performMigrateConfig(filenames []string, checkOnly bool, w io.Writer) int {
+	returnStatus := 0
+	for _, filename := range filenames {
+		migrated, err := migrateConfigFile(filename, checkOnly, w)
+		switch {
+		case err != nil:
+			logError(nil, fmt.Sprintf("Error: unable to migrate %s: %s", filename, err))
+			returnStatus = 2
+		case migrated && checkOnly && returnStatus == 0:
+			returnStatus = 1
+		}
+	}
+
+	return returnStatus
+}
+
+// Configuration files to migrate: the global configuration file (if any), and
+// the repository configuration (-f), or all of them (-all-configs)
+func migrationFiles() ([]string, error) {
+	filenames := []string{}
+	if filename := viper.ConfigFileUsed(); filename != "" {
+		filenames = append(filenames, filename)
+	}
+
+	names := []string{}
+	if cmdConfig != "" {
+		names = append(names, cmdConfig)
+	}
+	if cmdAllConfigs {
+		configs, err := findRepositoryConfigs(globalStorageDirectory)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, configs...)
+	}
+	for _, name := range names {
+		v := repositoryConfigViper(name)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, v.ConfigFileUsed())
+	}
+
+	if len(filenames) == 0 {
+		return nil, errors.New("no configuration files to migrate (specify -f or -all-configs)")
+	}
+	return filenames, nil
+}
+
+// Migrate a single configuration file. Returns true if the file needed to be migrated.
+func migrateConfigFile(filename string, checkOnly bool, w io.Writer) (bool, error) {
+	original, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	doc, err := decodeMigrationDocument(strings.TrimPrefix(filepath.Ext(filename), "."), original)
+	if err != nil {
+		return false, err
+	}
+
+	changes := append(migrateSections(doc), migrateEmailSettings(doc)...)
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "%s: up to date\n", filename)
+		return false, nil
+	}
+
+	migrated, err := encodeMigrationDocument(doc)
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Fprintf(w, "%s: needs to be migrated:\n", filename)
+	for _, change := range changes {
+		fmt.Fprintln(w, "  "+change)
+	}
+	fmt.Fprintln(w)
+	for _, line := range unifiedDiff(filename+" (original)", filename+" (migrated)", splitLines(string(original)), splitLines(string(migrated))) {
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w)
+
+	if checkOnly {
+		return true, nil
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return true, err
+	}
+	if err := ioutil.WriteFile(filename+".bak", original, info.Mode()); err != nil {
+		return true, err
+	}
+	if err := ioutil.WriteFile(filename, migrated, info.Mode()); err != nil {
+		return true, err
+	}
+	fmt.Fprintf(w, "%s: migrated (original saved as %s.bak)\n", filename, filename)
+
+	return true, nil
+}
+
+func decodeMigrationDocument(format string, data []byte) (*migrationDocument, error) {
+	doc := &migrationDocument{format: strings.ToLower(format), values: map[string]interface{}{}}
+	switch doc.format {
+	case "yaml", "yml":
+		var settings yaml.MapSlice
+		if err := yaml.Unmarshal(data, &settings); err != nil {
+			return nil, err
+		}
+		for _, item := range settings {
+			key := fmt.Sprint(item.Key)
+			doc.keys = append(doc.keys, key)
+			doc.values[key] = item.Value
+		}
+		return doc, nil
+
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc.values); err != nil {
+			return nil, err
+		}
+
+	case "toml":
+		tree, err := toml.LoadBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		doc.values = tree.ToMap()
+
+	default:
+		return nil, fmt.Errorf("unsupported format: %s (only YAML, JSON and TOML files can be migrated)", format)
+	}
+
+	for key := range doc.values {
+		doc.keys = append(doc.keys, key)
+	}
+	sort.Strings(doc.keys)
+
+	return doc, nil
+}
+
+func encodeMigrationDocument(doc *migrationDocument) ([]byte, error) {
+	switch doc.format {
+	case "yaml", "yml":
+		settings := yaml.MapSlice{}
+		for _, key := range doc.keys {
+			settings = append(settings, yaml.MapItem{Key: key, Value: doc.values[key]})
+		}
+		return yaml.Marshal(settings)
+
+	case "json":
+		data, err := json.MarshalIndent(doc.values, "", "    ")
+		return append(data, '\n'), err
+	}
+
+	tree, err := toml.TreeFromMap(doc.values)
+	if err != nil {
+		return nil, err
+	}
+	data, err := tree.ToTomlString()
+	return []byte(data), err
+}
+
+// Insert a setting before an existing setting
+func (doc *migrationDocument) insert(before string, key string, value interface{}) {
+	for i, existing := range doc.keys {
+		if existing == before {
+			doc.keys = append(doc.keys[:i], append([]string{key}, doc.keys[i:]...)...)
+			break
+		}
+	}
+	doc.values[key] = value
+}
+
+func (doc *migrationDocument) remove(key string) {
+	for i, existing := range doc.keys {
+		if existing == key {
+			doc.keys = append(doc.keys[:i], doc.keys[i+1:]...)
+			break
+		}
+	}
+	delete(doc.values, key)
+}
+
+// Find a setting (names of settings aren't case sensitive)
+func (doc *migrationDocument) find(name string) (string, bool) {
+	for _, key := range doc.keys {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Convert sections with numbered entries (like "storage: {1: ..., 2: ...}") to lists
+func migrateSections(doc *migrationDocument) []string {
+	changes := []string{}
+	for _, section := range []string{"storage", "copy", "prune", "check"} {
+		key, ok := doc.find(section)
+		if !ok {
+			continue
+		}
+		entries, ok := numberedEntries(doc.values[key])
+		if !ok {
+			continue
+		}
+
+		doc.values[key] = entries
+		changes = append(changes, fmt.Sprintf("%s: numbered entries converted to a list", key))
+	}
+
+	return changes
+}
+
+// Entries of a section in the old format (a map keyed by number), in order
+func numberedEntries(section interface{}) ([]interface{}, bool) {
+	items := map[int]interface{}{}
+	add := func(key interface{}, value interface{}) bool {
+		number, err := strconv.Atoi(fmt.Sprint(key))
+		items[number] = value
+		return err == nil
+	}
+
+	switch typed := section.(type) {
+	case yaml.MapSlice:
+		for _, item := range typed {
+			if !add(item.Key, item.Value) {
+				return nil, false
+			}
+		}
+	case map[string]interface{}:
+		for key, value := range typed {
+			if !add(key, value) {
+				return nil, false
+			}
+		}
+	default:
+		return nil, false
+	}
+
+	numbers := []int{}
+	for number := range items {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	entries := []interface{}{}
+	for _, number := range numbers {
+		entries = append(entries, items[number])
+	}
+
+	return entries, len(entries) > 0
+}
+
+// Move old E-Mail settings (like "emailFromAddress") to the email section. Settings
+// already in the email section take precedence.
+func migrateEmailSettings(doc *migrationDocument) []string {
+	changes := []string{}
+	for _, setting := range oldEmailSettings {
+		key, ok := doc.find(setting.old)
+		if !ok {
+			continue
+		}
+
+		// The email section takes the place of the first old setting
+		emailKey, ok := doc.find("email")
+		if !ok {
+			emailKey = "email"
+			if doc.format == "yaml" || doc.format == "yml" {
+				doc.insert(key, emailKey, yaml.MapSlice{})
+			} else {
+				doc.insert(key, emailKey, map[string]interface{}{})
+			}
+		}
+
+		value := doc.values[key]
+		switch email := doc.values[emailKey].(type) {
+		case yaml.MapSlice:
+			if !mapSliceHas(email, setting.new) {
+				doc.values[emailKey] = append(email, yaml.MapItem{Key: setting.new, Value: value})
+			}
+		case map[string]interface{}:
+			if _, ok := email[setting.new]; !ok {
+				email[setting.new] = value
+			}
+		}
+		doc.remove(key)
+		changes = append(changes, fmt.Sprintf("%s: moved to email.%s", key, setting.new))
+	}
+
+	return changes
+}
+
+func mapSliceHas(slice yaml.MapSlice, key string) bool {
+	for _, item := range slice {
+		if strings.EqualFold(fmt.Sprint(item.Key), key) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitLines(text string) []string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Differences between two versions of a file, as a unified diff (with three
+// lines of context)
+func unifiedDiff(fromName string, toName string, from []string, to []string) []string {
+	// Longest common subsequence of lines (from each position to the end)
+	common := make([][]int, len(from)+1)
+	for i := range common {
+		common[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	// Edits to turn one into the other (with line numbers in each, from 0)
+	type edit struct {
+		kind     byte
+		line     string
+		fromLine int
+		toLine   int
+	}
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			edits = append(edits, edit{' ', from[i], i, j})
+			i, j = i+1, j+1
+		case j == len(to) || (i < len(from) && common[i+1][j] >= common[i][j+1]):
+			edits = append(edits, edit{'-', from[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', to[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+	diff := []string{"--- " + fromName, "+++ " + toName}
+	for start := 0; start < len(edits); {
+		// Find the next change, and the end of changes close to it
+		first := start
+		for first < len(edits) && edits[first].kind == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		last := first
+		for next := first; next < len(edits) && next <= last+2*context; next++ {
+			if edits[next].kind != ' ' {
+				last = next
+			}
+		}
+
+		begin, end := first-context, last+context+1
+		if begin < start {
+			begin = start
+		}
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		fromCount, toCount := 0, 0
+		lines := []string{}
+		for _, e := range edits[begin:end] {
+			if e.kind != '+' {
+				fromCount++
+			}
+			if e.kind != '-' {
+				toCount++
+			}
+			lines = append(lines, string(e.kind)+e.line)
+		}
+		diff = append(diff, fmt.Sprintf("@@ -%s +%s @@", diffRange(edits[begin].fromLine, fromCount), diffRange(edits[begin].toLine, toCount)))
+		diff = append(diff, lines...)
+
+		start = end
+	}
+
+	return diff
+}
+
+// Range of lines in a hunk of a unified diff (like "3,7")
+func diffRange(line int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
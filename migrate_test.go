@@ -0,0 +1,201 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// Directory with a copy of a configuration file to migrate
+func setupMigration(t *testing.T, name string, contents string) (string, func()) {
+	directory, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	filename := filepath.Join(directory, name)
+	if err := ioutil.WriteFile(filename, []byte(contents), 0600); err != nil {
+		t.Fatalf("Error writing %s: %s", name, err)
+	}
+
+	return filename, func() { os.RemoveAll(directory) }
+}
+
+func TestMigrateConfig_NumberedKeys(t *testing.T) {
+	original, err := ioutil.ReadFile("test/assets/backupConfigs/numberedKeys.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, cleanup := setupMigration(t, "numberedKeys.yml", string(original))
+	defer cleanup()
+
+	var output bytes.Buffer
+	if status := performMigrateConfig([]string{filename}, false, &output); status != 0 {
+		t.Fatalf("Expected exit status 0, got %d:\n%s", status, output.String())
+	}
+
+	// Original is saved, and the migrated file has the same entries (as a list)
+	if saved, err := ioutil.ReadFile(filename + ".bak"); err != nil || !bytes.Equal(saved, original) {
+		t.Errorf("Original file was not saved (%v)", err)
+	}
+	before, after := viper.New(), viper.New()
+	before.SetConfigFile("test/assets/backupConfigs/numberedKeys.yml")
+	after.SetConfigFile(filename)
+	if err := before.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := after.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if after.IsSet("storage.1") {
+		t.Error("Migrated file still has numbered entries")
+	}
+	quietFlag = true
+	defer func() { quietFlag = false }()
+	for _, section := range []string{"storage", "copy", "prune", "check"} {
+		expected, _ := readSection(before, "numberedKeys", section)
+		actual, _ := readSection(after, "numberedKeys", section)
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Section %s was incorrect, got %v, expected %v", section, actual, expected)
+		}
+	}
+
+	// Migrating again changes nothing
+	output.Reset()
+	if status := performMigrateConfig([]string{filename}, true, &output); status != 0 || !strings.Contains(output.String(), "up to date") {
+		t.Errorf("Expected file to be up to date, got status %d:\n%s", status, output.String())
+	}
+}
+
+func TestMigrateConfig_CheckOnly(t *testing.T) {
+	contents := "emailFromAddress: from@example.com\nemailServerPort: 465\nlogfilecount: 3\n"
+	filename, cleanup := setupMigration(t, "duplicacy-util.yaml", contents)
+	defer cleanup()
+
+	var output bytes.Buffer
+	if status := performMigrateConfig([]string{filename}, true, &output); status != 1 {
+		t.Errorf("Expected exit status 1, got %d", status)
+	}
+	if current, _ := ioutil.ReadFile(filename); string(current) != contents {
+		t.Error("File was changed when only checking")
+	}
+	if _, err := os.Stat(filename + ".bak"); err == nil {
+		t.Error("Backup file was written when only checking")
+	}
+
+	expected := []string{
+		"  emailFromAddress: moved to email.fromAddress",
+		"  emailServerPort: moved to email.serverPort",
+		"-emailFromAddress: from@example.com",
+		"-emailServerPort: 465",
+		"+email:",
+		"+  fromAddress: from@example.com",
+		"+  serverPort: 465",
+		" logfilecount: 3",
+	}
+	for _, line := range expected {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Errorf("Output is missing '%s':\n%s", line, output.String())
+		}
+	}
+}
+
+func TestMigrateConfig_JSON(t *testing.T) {
+	contents := `{"emailToAddress": "to@example.com", "email": {"toAddress": "new@example.com", "serverPort": 587}, "logfilecount": 3}`
+	filename, cleanup := setupMigration(t, "duplicacy-util.json", contents)
+	defer cleanup()
+
+	var output bytes.Buffer
+	if status := performMigrateConfig([]string{filename}, false, &output); status != 0 {
+		t.Fatalf("Expected exit status 0, got %d:\n%s", status, output.String())
+	}
+
+	// Settings already in the email section take precedence
+	v := viper.New()
+	v.SetConfigFile(filename)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if v.IsSet("emailToAddress") || v.GetString("email.toAddress") != "new@example.com" || v.GetInt("email.serverPort") != 587 || v.GetInt("logfilecount") != 3 {
+		t.Errorf("Migrated settings were incorrect, got %v", v.AllSettings())
+	}
+}
+
+func TestMigrateConfig_TOML(t *testing.T) {
+	contents := "repository = \".\"\n\n[storage.2]\nname = \"azure\"\n\n[storage.1]\nname = \"b2\"\nthreads = 10\n"
+	filename, cleanup := setupMigration(t, "numbered.toml", contents)
+	defer cleanup()
+
+	var output bytes.Buffer
+	if status := performMigrateConfig([]string{filename}, false, &output); status != 0 {
+		t.Fatalf("Expected exit status 0, got %d:\n%s", status, output.String())
+	}
+
+	v := viper.New()
+	v.SetConfigFile(filename)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	quietFlag = true
+	defer func() { quietFlag = false }()
+	expected := []map[string]string{{"name": "b2", "threads": "10"}, {"name": "azure"}}
+	if storage, _ := readSection(v, "numbered", "storage"); !reflect.DeepEqual(storage, expected) {
+		t.Errorf("Migrated storage was incorrect, got %v, expected %v", storage, expected)
+	}
+}
+
+func TestMigrateConfig_UnsupportedFormat(t *testing.T) {
+	filename, cleanup := setupMigration(t, "config.hcl", "repository = \".\"\n")
+	defer cleanup()
+
+	quietFlag = true
+	defer func() { quietFlag = false }()
+	var output bytes.Buffer
+	if status := performMigrateConfig([]string{filename}, false, &output); status != 2 {
+		t.Errorf("Expected exit status 2, got %d", status)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	from := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}
+	to := []string{"a", "B", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"}
+
+	expected := []string{
+		"--- old",
+		"+++ new",
+		"@@ -1,5 +1,5 @@",
+		" a",
+		"-b",
+		"+B",
+		" c",
+		" d",
+		" e",
+		"@@ -9,3 +9,4 @@",
+		" i",
+		" j",
+		" k",
+		"+l",
+	}
+	if diff := unifiedDiff("old", "new", from, to); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Incorrect diff, got:\n%s\nexpected:\n%s", strings.Join(diff, "\n"), strings.Join(expected, "\n"))
+	}
+}
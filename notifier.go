@@ -4,6 +4,8 @@ package main
 type Notifier interface {
 	NotifyOfStart() error
 	NotifyOfSkip() error
+	NotifyOfDefer() error
 	NotifyOfSuccess() error
 	NotifyOfFailure() error
+	NotifyOfSummary(results []configResult, failed bool) error
 }
@@ -26,6 +26,27 @@ func notifyOfSkip() error {
 	return savedError
 }
 
+// Deferrals go to the onFailure notifiers (or, failing that, the onSkip notifiers)
+// if no onDefer notifiers are configured, so a deferred run isn't silent
+func notifyOfDefer() error {
+	notifiers := onDeferNotifiers
+	if len(notifiers) == 0 {
+		notifiers = onFailureNotifiers
+	}
+	if len(notifiers) == 0 {
+		notifiers = onSkipNotifiers
+	}
+
+	var savedError error
+	for _, notifier := range notifiers {
+		if err := notifier.NotifyOfDefer(); err != nil {
+			savedError = err
+		}
+	}
+
+	return savedError
+}
+
 func notifyOfSuccess() error {
 	var savedError error
 	for _, notifier := range onSuccessNotifiers {
@@ -48,6 +69,24 @@ func notifyOfFailure() error {
 	return savedError
 }
 
+// Summary notifications (for -all-configs) go to the onFailure notifiers if any
+// configuration failed, and to the onSuccess notifiers otherwise
+func notifyOfSummary(results []configResult, failed bool) error {
+	notifiers := onSuccessNotifiers
+	if failed {
+		notifiers = onFailureNotifiers
+	}
+
+	var savedError error
+	for _, notifier := range notifiers {
+		if err := notifier.NotifyOfSummary(results, failed); err != nil {
+			savedError = err
+		}
+	}
+
+	return savedError
+}
+
 func testNotifications() error {
 	var savedError error
 	cmdConfig = "test"
@@ -65,6 +104,8 @@ func testNotifications() error {
 			chunkNewSize:     "8,106K",
 			chunkNewUploaded: "3,410K",
 			duration:         "9 seconds",
+			status:           statusSuccess,
+			attempts:         1,
 		},
 		{
 			storage:          "azure-direct",
@@ -78,6 +119,8 @@ func testNotifications() error {
 			chunkNewSize:     "8,106K",
 			chunkNewUploaded: "3,410K",
 			duration:         "2 seconds",
+			status:           statusSuccess,
+			attempts:         1,
 		},
 	}
 
@@ -89,9 +132,21 @@ func testNotifications() error {
 			chunkCopyCount:  "3",
 			chunkSkipCount:  "106",
 			duration:        "9 seconds",
+			status:          statusSuccess,
+			attempts:        2,
 		},
 	}
 
+	pruneTable = []operationRevision{
+		{storage: "b2", duration: "7 seconds", status: statusSuccess, attempts: 1},
+		{storage: "azure", duration: "1 second", status: statusFailed, attempts: 3},
+	}
+
+	checkTable = []operationRevision{
+		{storage: "b2", status: statusSkipped},
+		{storage: "azure", duration: "2 seconds", status: statusSuccess, attempts: 1},
+	}
+
 	// Testing notifications while no notifications are set makes no sense
 	if len(onFailureNotifiers) == 0 {
 		return errors.New("Warning: No notifiers are configured")
@@ -105,6 +160,10 @@ func testNotifications() error {
 		savedError = err
 	}
 
+	if err := notifyOfDefer(); err != nil {
+		savedError = err
+	}
+
 	if err := notifyOfSuccess(); err != nil {
 		savedError = err
 	}
@@ -0,0 +1,190 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Output from an operation running in parallel with other operations. Output is
+// collected for the operation, and added to the log file (and E-Mail) as a single
+// section when the operation completes, rather than interleaved with the output
+// of other operations.
+type logSection struct {
+	logger *log.Logger
+	buffer bytes.Buffer
+	mail   []string
+}
+
+var (
+	// Serializes output (log file, E-Mail body, screen) from parallel operations
+	outputMutex sync.Mutex
+
+	// Log sections for operations currently running (keyed by section logger)
+	logSections = map[*log.Logger]*logSection{}
+
+	// Serializes updates to results (tables for E-Mail, failure status)
+	resultsMutex sync.Mutex
+)
+
+func beginLogSection(logger *log.Logger) *logSection {
+	section := new(logSection)
+	section.logger = log.New(&section.buffer, logger.Prefix(), logger.Flags())
+
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	logSections[section.logger] = section
+
+	return section
+}
+
+// Add output collected for the section to the log file and E-Mail body
+func (section *logSection) end(logger *log.Logger) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	delete(logSections, section.logger)
+	logger.Writer().Write(section.buffer.Bytes())
+	mailBody = append(mailBody, section.mail...)
+}
+
+// Storages used by an operation. Operations sharing a storage are never run at
+// the same time.
+func operationStorages(info operationInfo) []string {
+	switch info := info.(type) {
+	case backupConfig:
+		return []string{info.Name}
+	case copyConfig:
+		return []string{info.From, info.To}
+	case pruneConfig:
+		return []string{info.Storage}
+	case checkConfig:
+		return []string{info.Storage}
+	}
+
+	return nil
+}
+
+// Perform each iteration of an operation (backup or copy), running up to
+// configFile.parallel iterations at the same time. Iterations are started in
+// order, but an iteration isn't started while another iteration using the same
+// storage is running, or while an earlier iteration it depends on ("after") has
+// not finished. If perform returns an error, no further iterations are started;
+// the first error is returned once running iterations have finished.
+func performParallel(ctx context.Context, logger *log.Logger, operation int, infos []operationInfo,
+	perform func(ctx context.Context, logger *log.Logger, i int) error) error {
+	if configFile.parallel <= 1 || len(infos) <= 1 {
+		for i := range infos {
+			if err := perform(ctx, logger, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	logMessage(logger, fmt.Sprintf("Performing %s operations (up to %d in parallel)", checkpointName(operation), configFile.parallel))
+
+	type result struct {
+		index int
+		err   error
+	}
+
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = stepName(info)
+	}
+
+	started := make([]bool, len(infos))
+	finished := make([]bool, len(infos))
+	busy := map[string]bool{}
+	results := make(chan result)
+	running := 0
+	var firstErr error
+
+	sharesStorage := func(i int, j int) bool {
+		for _, storage := range operationStorages(infos[i]) {
+			for _, other := range operationStorages(infos[j]) {
+				if storage == other {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	canStart := func(i int) bool {
+		for _, storage := range operationStorages(infos[i]) {
+			if busy[storage] {
+				return false
+			}
+		}
+		for j := 0; j < i; j++ {
+			// Keep iterations using the same storage in configuration order
+			if !started[j] && sharesStorage(i, j) {
+				return false
+			}
+			if finished[j] {
+				continue
+			}
+			for _, prerequisite := range configFile.dependencies[names[i]] {
+				if prerequisite == names[j] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	for {
+		for i := 0; firstErr == nil && i < len(infos) && running < configFile.parallel; i++ {
+			if started[i] || !canStart(i) {
+				continue
+			}
+
+			started[i] = true
+			running++
+			for _, storage := range operationStorages(infos[i]) {
+				busy[storage] = true
+			}
+
+			go func(i int) {
+				section := beginLogSection(logger)
+				err := perform(ctx, section.logger, i)
+				section.end(logger)
+				results <- result{i, err}
+			}(i)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+		finished[r.index] = true
+		for _, storage := range operationStorages(infos[r.index]) {
+			delete(busy, storage)
+		}
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+	}
+
+	return firstErr
+}
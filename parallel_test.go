@@ -0,0 +1,174 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPerformParallel_DisjointStorages(t *testing.T) {
+	savedConfig := configFile
+	configFile = newConfigurationFile()
+	configFile.parallel = 2
+	configFile.backupInfo = []backupConfig{{Name: "b2"}, {Name: "azure"}, {Name: "b2"}, {Name: "local"}}
+	quietFlag = true
+	defer func() {
+		configFile = savedConfig
+		quietFlag = false
+	}()
+
+	var mutex sync.Mutex
+	running := map[string]bool{}
+	maxRunning := 0
+	order := []int{}
+
+	perform := func(ctx context.Context, logger *log.Logger, i int) error {
+		storage := configFile.backupInfo[i].Name
+
+		mutex.Lock()
+		if running[storage] {
+			t.Errorf("Storage %s used by more than one operation at the same time", storage)
+		}
+		running[storage] = true
+		if len(running) > maxRunning {
+			maxRunning = len(running)
+		}
+		order = append(order, i)
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mutex.Lock()
+		delete(running, storage)
+		mutex.Unlock()
+		return nil
+	}
+
+	logger := log.New(&bytes.Buffer{}, "", 0)
+	if err := performParallel(context.Background(), logger, checkpointBackup, configFile.backupOperations(), perform); err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+
+	if maxRunning != 2 {
+		t.Errorf("Incorrect number of parallel operations, got %d, expected %d", maxRunning, 2)
+	}
+	if len(order) != 4 {
+		t.Fatalf("Incorrect number of operations performed, got %d, expected %d", len(order), 4)
+	}
+
+	// The second backup to b2 must not start before the first one
+	for _, i := range order {
+		if i == 2 {
+			t.Errorf("Second backup to b2 started before the first, order %v", order)
+		}
+		if i == 0 {
+			break
+		}
+	}
+}
+
+func TestPerformParallel_LogSections(t *testing.T) {
+	savedConfig := configFile
+	savedMailBody := mailBody
+	configFile = newConfigurationFile()
+	configFile.parallel = 2
+	configFile.backupInfo = []backupConfig{{Name: "b2"}, {Name: "azure"}}
+	quietFlag = true
+	mailBody = []string{}
+	defer func() {
+		configFile = savedConfig
+		mailBody = savedMailBody
+		quietFlag = false
+	}()
+
+	perform := func(ctx context.Context, logger *log.Logger, i int) error {
+		for line := 1; line <= 3; line++ {
+			logMessage(logger, configFile.backupInfo[i].Name)
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	logger := log.New(&buffer, "", 0)
+	if err := performParallel(context.Background(), logger, checkpointBackup, configFile.backupOperations(), perform); err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+
+	// Output of each operation should be contiguous in the log and the E-Mail body
+	logLines := strings.Split(strings.TrimSpace(buffer.String()), "\n")[1:]
+	for _, lines := range [][]string{logLines, mailBody[1:]} {
+		if len(lines) != 6 {
+			t.Fatalf("Incorrect number of lines, got %d, expected %d: %v", len(lines), 6, lines)
+		}
+		for _, section := range [][]string{lines[:3], lines[3:]} {
+			storage := strings.Fields(section[0])[len(strings.Fields(section[0]))-1]
+			for _, line := range section {
+				if !strings.HasSuffix(line, " "+storage) && line != storage {
+					t.Errorf("Output of parallel operations is interleaved: %v", lines)
+				}
+			}
+		}
+	}
+}
+
+func TestPerformParallel_After(t *testing.T) {
+	savedConfig := configFile
+	configFile = newConfigurationFile()
+	configFile.parallel = 3
+	configFile.backupInfo = []backupConfig{{Name: "b2"}, {Name: "azure"}, {Name: "local", operationConfig: operationConfig{After: []string{"backup:b2"}}}}
+	quietFlag = true
+	defer func() {
+		configFile = savedConfig
+		quietFlag = false
+	}()
+
+	var err error
+	if configFile.dependencies, err = buildDependencyGraph(configFile); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	var mutex sync.Mutex
+	finished := map[string]bool{}
+
+	perform := func(ctx context.Context, logger *log.Logger, i int) error {
+		storage := configFile.backupInfo[i].Name
+		if storage == "local" {
+			mutex.Lock()
+			if !finished["b2"] {
+				t.Error("Backup to local started before backup to b2 finished")
+			}
+			mutex.Unlock()
+		}
+
+		time.Sleep(20 * time.Millisecond)
+
+		mutex.Lock()
+		finished[storage] = true
+		mutex.Unlock()
+		return nil
+	}
+
+	logger := log.New(&bytes.Buffer{}, "", 0)
+	if err := performParallel(context.Background(), logger, checkpointBackup, configFile.backupOperations(), perform); err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Prompts for input that duplicacy might issue, along with the credential that
+// is likely missing. Input is never available (duplicacy reads from the null
+// device), so a command that prompts is terminated immediately. Patterns match
+// the whole prompt, not just text that could appear in a line of log output.
+var promptPatterns = []struct {
+	pattern    *regexp.Regexp
+	credential string
+}{
+	{regexp.MustCompile(`^Enter (the )?storage password`), "storage password (password)"},
+	{regexp.MustCompile(`^Enter Backblaze Account ID`), "Backblaze account ID (b2_id)"},
+	{regexp.MustCompile(`^Enter Backblaze Application Key`), "Backblaze application key (b2_key)"},
+	{regexp.MustCompile(`(?i)^Enter (the )?(SSH )?passphrase`), "SSH key passphrase (ssh_passphrase)"},
+	{regexp.MustCompile(`(?i)^Enter (the )?SSH password`), "SSH password (ssh_password)"},
+	{regexp.MustCompile(`(?i)^Enter [^:]*keyring[^:]*:\s*$`), "password to unlock the keyring"},
+	{regexp.MustCompile(`(?i)^Do you want to continue\?(\s*\[[yn]/[yn]\])?\s*$`), "confirmation to continue"},
+	{regexp.MustCompile(`^Enter [^:]+:\s*$`), ""},
+}
+
+// Error returned when a command was terminated because it prompted for input
+type promptError struct {
+	prompt     string
+	credential string
+	storage    string // Storage for the operation (if known)
+}
+
+func (e *promptError) Error() string {
+	message := fmt.Sprintf("duplicacy prompted for input (%q)", e.prompt)
+	if e.credential != "" {
+		message = fmt.Sprint("duplicacy prompted for ", e.credential)
+	}
+	if e.storage != "" {
+		message += fmt.Sprint(" for storage ", e.storage)
+	}
+
+	return message
+}
+
+// Return an error if the text (a line, or a partial line) is a prompt for input
+func detectPrompt(text string) *promptError {
+	text = strings.TrimSpace(text)
+	for _, prompt := range promptPatterns {
+		if prompt.pattern.MatchString(text) {
+			return &promptError{prompt: text, credential: prompt.credential}
+		}
+	}
+
+	return nil
+}
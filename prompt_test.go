@@ -0,0 +1,72 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestDetectPrompt(t *testing.T) {
+	tests := []struct {
+		text       string
+		prompt     bool
+		credential string
+	}{
+		{"Enter storage password:", true, "storage password (password)"},
+		{"Enter the storage password for b2:", true, "storage password (password)"},
+		{"Enter Backblaze Account ID:", true, "Backblaze account ID (b2_id)"},
+		{"Enter Backblaze Application Key:", true, "Backblaze application key (b2_key)"},
+		{"Enter SSH passphrase:", true, "SSH key passphrase (ssh_passphrase)"},
+		{"Enter SSH password:", true, "SSH password (ssh_password)"},
+		{"Enter the password to unlock the keyring: ", true, "password to unlock the keyring"},
+		{"Do you want to continue? [y/N]", true, "confirmation to continue"},
+		{"Enter Wasabi secret:", true, ""},
+
+		{"Storage set to b2://bucket", false, ""},
+		{"Enter", false, ""},
+		{"Backup for /Volumes/Quicken at revision 14 completed", false, ""},
+		{"2020-05-01 02:00:01.123 INFO PASSWORD_KEYCHAIN Reading the password from keyring", false, ""},
+		{"Failed to store the password in the keyring: The name org.freedesktop.secrets was not provided", false, ""},
+		{"Enter the password to unlock the keyring later, if asked", false, ""},
+		{"Hook: backup files; do you want to continue reading the documentation?", false, ""},
+	}
+
+	for _, test := range tests {
+		prompt := detectPrompt(test.text)
+		if (prompt != nil) != test.prompt {
+			t.Errorf("Incorrect detection for %q, got %v, expected %t", test.text, prompt, test.prompt)
+			continue
+		}
+		if prompt != nil && prompt.credential != test.credential {
+			t.Errorf("Incorrect credential for %q, got %q, expected %q", test.text, prompt.credential, test.credential)
+		}
+	}
+}
+
+func TestPromptError(t *testing.T) {
+	tests := []struct {
+		err      promptError
+		expected string
+	}{
+		{promptError{"Enter storage password:", "storage password (password)", "b2"}, "duplicacy prompted for storage password (password) for storage b2"},
+		{promptError{"Enter Wasabi secret:", "", ""}, `duplicacy prompted for input ("Enter Wasabi secret:")`},
+	}
+
+	for _, test := range tests {
+		if message := test.err.Error(); message != test.expected {
+			t.Errorf("Incorrect message, got %q, expected %q", message, test.expected)
+		}
+	}
+}
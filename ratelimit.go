@@ -0,0 +1,201 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule for the rate limit of backups and copies (duplicacy -limit-rate), like
+// "2MB/s mon-fri 08:00-18:00; unlimited". Each rule (separated by semicolons) has
+// a rate followed by days and/or a time range, as in a maintenance window; the
+// first rule that includes the current time is used. A rule without days or a
+// time range always applies (so "4096" is a fixed rate), and if no rule applies,
+// the rate is unlimited. Like a maintenance window, the schedule may start with a
+// time zone (like "TZ=Europe/Berlin").
+type rateSchedule struct {
+	expression string
+	location   *time.Location
+	rules      []rateRule
+}
+
+type rateRule struct {
+	rate int // KB/s (zero for unlimited)
+	windowRule
+}
+
+var ratePattern = regexp.MustCompile(`(?i)^([0-9]+)(k|kb/s|m|mb/s|g|gb/s)?$`)
+
+func parseRateSchedule(expression string) (*rateSchedule, error) {
+	location, text, err := parseTimeZone(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &rateSchedule{expression: expression, location: location}
+	for _, ruleText := range strings.Split(text, ";") {
+		fields := strings.Fields(ruleText)
+		if len(fields) == 0 {
+			return nil, errors.New("each rule must have a rate, optionally followed by days and/or a time range (like 2MB/s mon-fri 08:00-18:00)")
+		}
+
+		rule := rateRule{windowRule: windowRule{weekdays: 0x7f, start: 0, end: 24 * 60}}
+		if rule.rate, err = parseRate(fields[0]); err != nil {
+			return nil, err
+		}
+		if len(fields) > 1 {
+			if rule.windowRule, err = parseWindowRule(fields[1:]); err != nil {
+				return nil, err
+			}
+		}
+		schedule.rules = append(schedule.rules, rule)
+	}
+
+	return schedule, nil
+}
+
+// Parse a rate (like 2048, 512KB/s, 2MB/s, or unlimited) as KB/s
+func parseRate(text string) (int, error) {
+	if strings.EqualFold(text, "unlimited") {
+		return 0, nil
+	}
+
+	elements := ratePattern.FindStringSubmatch(text)
+	if elements == nil {
+		return 0, fmt.Errorf("invalid rate %s (like 2048, 512KB/s, 2MB/s, or unlimited)", text)
+	}
+	rate, err := strconv.Atoi(elements[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %s", text)
+	}
+	switch strings.ToLower(elements[2]) {
+	case "m", "mb/s":
+		rate *= 1024
+	case "g", "gb/s":
+		rate *= 1024 * 1024
+	}
+
+	return rate, nil
+}
+
+// Rate (in KB/s, zero for unlimited) in effect at the specified time
+func (schedule *rateSchedule) rateAt(t time.Time) int {
+	t = t.In(schedule.location)
+	for _, rule := range schedule.rules {
+		if rule.contains(t) {
+			return rule.rate
+		}
+	}
+
+	return 0
+}
+
+// Next time (after the specified time) that the rate changes, or the zero time if
+// it never does
+func (schedule *rateSchedule) nextChange(after time.Time) time.Time {
+	rate := schedule.rateAt(after)
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(0, 0, 8); t.Before(limit); t = t.Add(time.Minute) {
+		if schedule.rateAt(t) != rate {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (schedule *rateSchedule) String() string {
+	return schedule.expression
+}
+
+// Rate schedule for an operation (backups and copies only), and whether the
+// operation should be restarted when the rate changes
+func operationRateSchedule(info operationInfo) (*rateSchedule, bool) {
+	switch info := info.(type) {
+	case backupConfig:
+		return info.LimitRate, info.RestartOnRateChange
+	case copyConfig:
+		return info.LimitRate, info.RestartOnRateChange
+	}
+
+	return nil, false
+}
+
+// Arguments to limit the rate of an operation (if limited at the specified time)
+func limitRateArguments(info operationInfo, t time.Time) []string {
+	schedule, _ := operationRateSchedule(info)
+	if schedule == nil {
+		return nil
+	}
+	if rate := schedule.rateAt(t); rate != 0 {
+		return []string{"-limit-rate", strconv.Itoa(rate)}
+	}
+
+	return nil
+}
+
+// Run duplicacy with the rate limit in effect when it starts. If the operation
+// should be restarted when the rate changes, duplicacy is stopped at that time and
+// run again (with the new rate); backups and copies pick up where they left off,
+// since chunks that were already uploaded are skipped.
+func runRateLimited(ctx context.Context, logger *log.Logger, info operationInfo, cmdArgs []string, env []string, output func(string)) error {
+	run := func(ctx context.Context, cmdArgs []string) error {
+		if debugFlag {
+			logMessage(logger, redactSecrets(fmt.Sprint("Executing: ", duplicacyPath, cmdArgs)))
+		}
+		return duplicacyRunner.Run(ctx, cmdArgs, configFile.repoDir, env, output)
+	}
+
+	schedule, restart := operationRateSchedule(info)
+	if schedule == nil {
+		return run(ctx, cmdArgs)
+	}
+
+	for {
+		now := timeNow()
+		args := append(append([]string{}, cmdArgs...), limitRateArguments(info, now)...)
+		rate := "unlimited"
+		if limit := schedule.rateAt(now); limit != 0 {
+			rate = fmt.Sprint(limit, " KB/s")
+		}
+
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		change := schedule.nextChange(now)
+		if change.IsZero() {
+			logMessage(logger, fmt.Sprint("  Rate limit: ", rate))
+		} else {
+			logMessage(logger, fmt.Sprintf("  Rate limit: %s (until %s)", rate, change.In(time.Local).Format("01-02-2006 15:04")))
+			if restart {
+				runCtx, cancel = context.WithTimeout(ctx, change.Sub(now))
+			}
+		}
+
+		err := run(runCtx, args)
+		rateChanged := err != nil && ctx.Err() == nil && runCtx.Err() != nil
+		cancel()
+		if !rateChanged {
+			return err
+		}
+
+		logMessage(logger, "  Rate limit changed; restarting operation with the new rate")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateSchedule(t *testing.T) {
+	tests := []struct {
+		expression string
+		rate       int
+	}{
+		{"2048", 2048},
+		{"512KB/s", 512},
+		{"2MB/s", 2048},
+		{"1g", 1024 * 1024},
+		{"unlimited", 0},
+		{"TZ=UTC 2MB/s mon-fri 08:00-18:00; 512", 2048},
+	}
+	for _, test := range tests {
+		schedule, err := parseRateSchedule(test.expression)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.expression, err)
+			continue
+		}
+		if rate := schedule.rateAt(fixedTimeNow()); rate != test.rate {
+			t.Errorf("%q: rate was incorrect, got %d, expected %d", test.expression, rate, test.rate)
+		}
+	}
+
+	for _, expression := range []string{"", "fast", "2TB/s", "-5", "2MB/s mon-fri 08:00", "2MB/s;", "TZ=No/Such/Zone 2048"} {
+		if _, err := parseRateSchedule(expression); err == nil {
+			t.Errorf("%q: expected an error", expression)
+		}
+	}
+}
+
+func TestRateScheduleChanges(t *testing.T) {
+	schedule, err := parseRateSchedule("TZ=UTC 2MB/s mon-fri 08:00-18:00; 8MB/s sat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// July 17, 2018 was a Tuesday
+	tests := []struct {
+		time time.Time
+		rate int
+		next time.Time
+	}{
+		{time.Date(2018, 7, 17, 17, 58, 25, 0, time.UTC), 2048, time.Date(2018, 7, 17, 18, 0, 0, 0, time.UTC)},
+		{time.Date(2018, 7, 17, 18, 0, 0, 0, time.UTC), 0, time.Date(2018, 7, 18, 8, 0, 0, 0, time.UTC)},
+		{time.Date(2018, 7, 20, 23, 0, 0, 0, time.UTC), 0, time.Date(2018, 7, 21, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2018, 7, 21, 12, 0, 0, 0, time.UTC), 8192, time.Date(2018, 7, 22, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		if rate := schedule.rateAt(test.time); rate != test.rate {
+			t.Errorf("%s: rate was incorrect, got %d, expected %d", test.time.Format(time.RFC1123), rate, test.rate)
+		}
+		if next := schedule.nextChange(test.time); !next.Equal(test.next) {
+			t.Errorf("%s: next change was incorrect, got %s, expected %s", test.time.Format(time.RFC1123), next, test.next)
+		}
+	}
+
+	// A fixed rate never changes
+	fixed, _ := parseRateSchedule("4096")
+	if next := fixed.nextChange(fixedTimeNow()); !next.IsZero() {
+		t.Errorf("fixed rate should never change, got %s", next)
+	}
+}
+
+// Runner that records the arguments of each run. The first run lasts until it is
+// stopped, at which point the clock advances to the next rate.
+type rateRunner struct {
+	runs [][]string
+	now  *time.Time
+}
+
+func (runner *rateRunner) Run(ctx context.Context, cmdArgs []string, dir string, env []string, output func(string)) error {
+	runner.runs = append(runner.runs, cmdArgs)
+	if len(runner.runs) > 1 {
+		return nil
+	}
+
+	<-ctx.Done()
+	*runner.now = time.Date(2018, 7, 17, 18, 0, 0, 0, time.UTC)
+	return ctx.Err()
+}
+
+func TestRunRateLimited(t *testing.T) {
+	quietFlag = true
+	defer func() { quietFlag = false }()
+
+	// Shortly before the rate changes at 18:00
+	now := time.Date(2018, 7, 17, 17, 59, 59, 950000000, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() {
+		timeNow = time.Now
+		duplicacyRunner = execRunner{}
+	}()
+
+	schedule, _ := parseRateSchedule("TZ=UTC 2MB/s mon-fri 08:00-18:00; unlimited")
+	for _, restart := range []bool{true, false} {
+		now = time.Date(2018, 7, 17, 17, 59, 59, 950000000, time.UTC)
+		runner := &rateRunner{now: &now}
+		duplicacyRunner = runner
+		info := copyConfig{From: "b2", To: "azure", rateConfig: rateConfig{LimitRate: schedule, RestartOnRateChange: restart}}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		err := runRateLimited(ctx, nil, info, []string{"copy"}, nil, func(string) {})
+		cancel()
+
+		if restart {
+			if err != nil || len(runner.runs) != 2 {
+				t.Fatalf("operation should have been restarted, got %v after %d runs", err, len(runner.runs))
+			}
+			if first, second := strings.Join(runner.runs[0], " "), strings.Join(runner.runs[1], " "); first != "copy -limit-rate 2048" || second != "copy" {
+				t.Errorf("arguments were incorrect, got %q and %q", first, second)
+			}
+		} else if err == nil || len(runner.runs) != 1 {
+			t.Errorf("operation should not have been restarted, got %v after %d runs", err, len(runner.runs))
+		}
+	}
+
+	// Prune and check aren't rate limited
+	if args := limitRateArguments(pruneConfig{Storage: "b2"}, now); args != nil {
+		t.Errorf("prune should not be rate limited, got %v", args)
+	}
+}
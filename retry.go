@@ -0,0 +1,45 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"regexp"
+	"time"
+)
+
+type retryPolicy struct {
+	retries int            // Number of retries after the first attempt
+	delay   time.Duration  // Delay before the first retry
+	backoff float64        // Multiplier applied to the delay after each retry
+	retryOn *regexp.Regexp // If set, only retry if an output line matches
+}
+
+// Build the retry policy from the settings of an operation (retries, retryDelay,
+// retryBackoff and retryOn). By default, failed operations are not retried.
+// Settings were validated (and defaults applied) when the configuration was loaded.
+func newRetryPolicy(settings operationConfig) retryPolicy {
+	return retryPolicy{
+		retries: settings.Retries,
+		delay:   settings.RetryDelay,
+		backoff: settings.RetryBackoff,
+		retryOn: settings.RetryOn,
+	}
+}
+
+// Delay to wait after a failed attempt (attempts are numbered starting at 1)
+func (policy retryPolicy) delayFor(attempt int) time.Duration {
+	return time.Duration(float64(policy.delay) * math.Pow(policy.backoff, float64(attempt-1)))
+}
@@ -0,0 +1,105 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// Decode retry settings (as they would be when the configuration is loaded)
+func retrySettings(t *testing.T, raw map[string]string) operationConfig {
+	var settings operationConfig
+	if errs := decodeSettings("storage.0", raw, &settings); len(errs) != 0 {
+		t.Fatalf("Expected no errors for retry settings %v, got %v", raw, errs)
+	}
+	return settings
+}
+
+func TestRetryPolicy_Defaults(t *testing.T) {
+	policy := newRetryPolicy(retrySettings(t, map[string]string{}))
+
+	if policy.retries != 0 || policy.delay != time.Minute || policy.backoff != 2 || policy.retryOn != nil {
+		t.Errorf("Incorrect default retry policy: %+v", policy)
+	}
+}
+
+func TestRetryPolicy_Invalid(t *testing.T) {
+	tests := []map[string]string{
+		{"retries": "-1"},
+		{"retries": "three"},
+		{"retryDelay": "soon"},
+		{"retryBackoff": "0.5"},
+		{"retryOn": "([unbalanced"},
+	}
+
+	for _, raw := range tests {
+		var settings operationConfig
+		if errs := decodeSettings("storage.0", raw, &settings); len(errs) == 0 {
+			t.Errorf("Expected error for retry settings %v", raw)
+		}
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := newRetryPolicy(retrySettings(t, map[string]string{"retries": "3", "retryDelay": "30s", "retryBackoff": "2"}))
+
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute}
+	for i, delay := range expected {
+		if actual := policy.delayFor(i + 1); actual != delay {
+			t.Errorf("Incorrect delay for attempt %d, got '%s', expected '%s'", i+1, actual, delay)
+		}
+	}
+}
+
+func TestExecuteDuplicacy_Retries(t *testing.T) {
+	tests := []struct {
+		info     map[string]string
+		attempts int
+	}{
+		{map[string]string{}, 1},
+		{map[string]string{"retries": "2", "retryDelay": "1ms"}, 3},
+		{map[string]string{"retries": "2", "retryDelay": "1ms", "retryOn": "503|rate limit"}, 1},
+		{map[string]string{"retries": "2", "retryDelay": "1ms", "retryOn": "panic"}, 3},
+	}
+
+	logger, file, err := setupLogging()
+	if err != nil {
+		t.Errorf("unexpected error creating log file, got %#v", err)
+	}
+	quietFlag = true
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+		quietFlag = false
+	}()
+
+	// Command always fails (with a "panic" line written to stderr)
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	for _, test := range tests {
+		attempts, err := executeDuplicacy(context.Background(), logger, retrySettings(t, test.info), []string{"-stderr"}, nil, func(string) {})
+		if err == nil {
+			t.Errorf("Expected error from failed command, got nil")
+		}
+		if attempts != test.attempts {
+			t.Errorf("Incorrect number of attempts for %v, got %d, expected %d", test.info, attempts, test.attempts)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+)
+
+// DuplicacyRunner runs duplicacy with the specified arguments (in the specified
+// directory, with additional environment variables like "NAME=value"), passing
+// each line of output to the output function.
+type DuplicacyRunner interface {
+	Run(ctx context.Context, cmdArgs []string, dir string, env []string, output func(string)) error
+}
+
+// Runner used for all duplicacy operations
+var duplicacyRunner DuplicacyRunner = execRunner{}
+
+// Runner that executes the duplicacy binary
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, cmdArgs []string, dir string, env []string, output func(string)) error {
+	return duplicacyExecutor(ctx, duplicacyPath, cmdArgs, dir, env, output)
+}
@@ -0,0 +1,213 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Prefixes of secret references (values without one of these are literal)
+var secretPrefixes = []string{"env:", "file:", "cmd:", "keyfile:", "vault:"}
+
+// Replacement for secrets in debug output
+const redactedSecret = "********"
+
+var (
+	// Secrets that have been resolved (so they can be redacted from output)
+	resolvedSecrets = map[string]bool{}
+	secretsMutex    sync.Mutex
+)
+
+// Is the value a secret reference (rather than a literal value)?
+func isSecretReference(value string) bool {
+	for _, prefix := range secretPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolve a secret reference. A secret may be taken from an environment variable
+// ("env:NAME"), a file ("file:/path/to/file"), the output of a command
+// ("cmd:pass show duplicacy/b2"), a key file in the "keys" directory of the
+// storage directory ("keyfile:smtp"), or the secrets vault ("vault:smtp").
+// Anything else is taken literally.
+func resolveSecret(reference string) (string, error) {
+	secret, err := lookupSecret(reference)
+	if err == nil && secret != reference {
+		secretsMutex.Lock()
+		resolvedSecrets[secret] = true
+		secretsMutex.Unlock()
+	}
+
+	return secret, err
+}
+
+func lookupSecret(reference string) (string, error) {
+	switch {
+	case strings.HasPrefix(reference, "env:"):
+		name := strings.TrimPrefix(reference, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+
+	case strings.HasPrefix(reference, "file:"):
+		filename := strings.TrimPrefix(reference, "file:")
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+
+	case strings.HasPrefix(reference, "cmd:"):
+		command := strings.TrimPrefix(reference, "cmd:")
+		cmdName, cmdArgs := shellCommand(command)
+		output, err := execCommand(cmdName, cmdArgs...).Output()
+		if err != nil {
+			return "", fmt.Errorf("command %q failed: %s", command, err)
+		}
+		return strings.TrimRight(string(output), "\r\n"), nil
+
+	case strings.HasPrefix(reference, "keyfile:"):
+		name := strings.TrimPrefix(reference, "keyfile:")
+		if name == "" {
+			return "", fmt.Errorf("missing key file name")
+		}
+		filename := filepath.Join(globalStorageDirectory, "keys", name)
+		info, err := os.Stat(filename)
+		if err != nil {
+			return "", err
+		}
+		// Key files must be private (Windows doesn't have meaningful permission bits)
+		if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+			return "", fmt.Errorf("key file %s must not be accessible by group or others (permissions %s)", filename, info.Mode().Perm())
+		}
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+
+	case strings.HasPrefix(reference, "vault:"):
+		return vaultSecret(strings.TrimPrefix(reference, "vault:"))
+	}
+
+	return reference, nil
+}
+
+// Resolve secret references in all values of a configuration file, so the rest of
+// the code only sees resolved values. Storage credentials aren't resolved here
+// (see credentialEnvironment); they're resolved when needed.
+func resolveConfigSecrets(v *viper.Viper) error {
+	keys := []string{}
+	for key := range v.AllSettings() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Set entire top level values; viper doesn't merge nested values that were set
+	// with values from the configuration file
+	for _, key := range keys {
+		if key == "credentials" {
+			continue
+		}
+
+		value, changed, err := resolveSecretsIn(v.Get(key))
+		if err != nil {
+			return fmt.Errorf("unable to resolve secret for %s: %s", key, err)
+		}
+		if changed {
+			v.Set(key, value)
+		}
+	}
+
+	return nil
+}
+
+// Resolve secret references in a configuration value (which may be nested)
+func resolveSecretsIn(value interface{}) (interface{}, bool, error) {
+	changed := false
+	resolve := func(item interface{}) (interface{}, error) {
+		resolved, itemChanged, err := resolveSecretsIn(item)
+		changed = changed || itemChanged
+		return resolved, err
+	}
+
+	var err error
+	switch typed := value.(type) {
+	case string:
+		if !isSecretReference(typed) {
+			return typed, false, nil
+		}
+		secret, err := resolveSecret(typed)
+		return secret, err == nil, err
+
+	case []interface{}:
+		resolved := make([]interface{}, len(typed))
+		for i, item := range typed {
+			if resolved[i], err = resolve(item); err != nil {
+				return nil, false, err
+			}
+		}
+		return resolved, changed, nil
+
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			if resolved[key], err = resolve(item); err != nil {
+				return nil, false, err
+			}
+		}
+		return resolved, changed, nil
+
+	case map[interface{}]interface{}:
+		resolved := make(map[interface{}]interface{}, len(typed))
+		for key, item := range typed {
+			if resolved[key], err = resolve(item); err != nil {
+				return nil, false, err
+			}
+		}
+		return resolved, changed, nil
+	}
+
+	return value, false, nil
+}
+
+// Replace any resolved secrets in text (for debug output)
+func redactSecrets(text string) string {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+
+	for secret := range resolvedSecrets {
+		if secret != "" {
+			text = strings.Replace(text, secret, redactedSecret, -1)
+		}
+	}
+
+	return text
+}
@@ -0,0 +1,159 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestResolveSecret(t *testing.T) {
+	os.Setenv("DU_TEST_SECRET", "from-environment")
+	defer os.Unsetenv("DU_TEST_SECRET")
+
+	file, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatalf("Error creating secret file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("from-file\n")
+	file.Close()
+
+	// Key files live in the "keys" directory of the storage directory
+	savedStorageDirectory := globalStorageDirectory
+	globalStorageDirectory, err = ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("Error creating storage directory: %s", err)
+	}
+	defer func() {
+		os.RemoveAll(globalStorageDirectory)
+		globalStorageDirectory = savedStorageDirectory
+	}()
+	os.Mkdir(filepath.Join(globalStorageDirectory, "keys"), 0700)
+	ioutil.WriteFile(filepath.Join(globalStorageDirectory, "keys", "smtp"), []byte("from-key-file\n"), 0600)
+
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	tests := []struct {
+		reference string
+		expected  string
+	}{
+		{"literal-secret", "literal-secret"},
+		{"env:DU_TEST_SECRET", "from-environment"},
+		{"file:" + file.Name(), "from-file"},
+		{"cmd:pass show duplicacy/b2", "This is the expected\noutput"},
+		{"keyfile:smtp", "from-key-file"},
+	}
+
+	for _, test := range tests {
+		value, err := resolveSecret(test.reference)
+		if err != nil {
+			t.Errorf("Expected nil error for %s, got %v", test.reference, err)
+		}
+		if value != test.expected {
+			t.Errorf("Incorrect secret for %s, got %q, expected %q", test.reference, value, test.expected)
+		}
+	}
+}
+
+func TestResolveSecret_Errors(t *testing.T) {
+	os.Unsetenv("DU_TEST_SECRET")
+
+	savedStorageDirectory := globalStorageDirectory
+	globalStorageDirectory, _ = ioutil.TempDir("", "secrets")
+	defer func() {
+		os.RemoveAll(globalStorageDirectory)
+		globalStorageDirectory = savedStorageDirectory
+	}()
+	os.Mkdir(filepath.Join(globalStorageDirectory, "keys"), 0700)
+	ioutil.WriteFile(filepath.Join(globalStorageDirectory, "keys", "shared"), []byte("secret"), 0644)
+
+	references := []string{"env:DU_TEST_SECRET", "file:/no/such/secret/file", "keyfile:", "keyfile:missing"}
+	if runtime.GOOS != "windows" {
+		// Key files readable by others aren't secret
+		references = append(references, "keyfile:shared")
+	}
+	for _, reference := range references {
+		if _, err := resolveSecret(reference); err == nil {
+			t.Errorf("Expected error for %s, got nil", reference)
+		}
+	}
+}
+
+func TestResolveConfigSecrets(t *testing.T) {
+	os.Setenv("DU_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("DU_TEST_SECRET")
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(bytes.NewBufferString(`
+repository: /Volumes/Quicken
+email:
+    authUsername: donald
+    authPassword: "env:DU_TEST_SECRET"
+storage:
+    - name: b2
+      threads: 10
+      bucket: "env:DU_TEST_SECRET"
+credentials:
+    b2:
+        password: "env:DU_TEST_UNSET_SECRET"
+`))
+	if err != nil {
+		t.Fatalf("Error reading configuration: %s", err)
+	}
+
+	if err := resolveConfigSecrets(v); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	if value := v.GetString("email.authPassword"); value != "hunter2" {
+		t.Errorf("Incorrect email.authPassword, got %q", value)
+	}
+	if value := v.GetString("email.authUsername"); value != "donald" {
+		t.Errorf("Incorrect email.authUsername, got %q", value)
+	}
+	storage, _ := readSection(v, "test", "storage")
+	if len(storage) != 1 || storage[0]["bucket"] != "hunter2" || storage[0]["threads"] != "10" {
+		t.Errorf("Incorrect storage section, got %v", storage)
+	}
+
+	// Credentials are resolved when needed, not when loaded
+	if value := v.GetString("credentials.b2.password"); value != "env:DU_TEST_UNSET_SECRET" {
+		t.Errorf("Credentials should not be resolved, got %q", value)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	os.Setenv("DU_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("DU_TEST_SECRET")
+
+	if _, err := resolveSecret("env:DU_TEST_SECRET"); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	expected := "Backup Info: [map[bucket:" + redactedSecret + " name:b2]]"
+	if text := redactSecrets("Backup Info: [map[bucket:hunter2 name:b2]]"); text != expected {
+		t.Errorf("Incorrect redaction, got %q, expected %q", text, expected)
+	}
+}
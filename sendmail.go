@@ -18,6 +18,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"html"
+	"strconv"
 	"strings"
 
 	"gopkg.in/gomail.v2"
@@ -27,6 +28,9 @@ const (
 	htmlTableNone = 0 + iota
 	htmlTableBackup
 	htmlTableCopy
+	htmlTablePrune
+	htmlTableCheck
+	htmlTableSummary
 )
 
 var (
@@ -36,6 +40,9 @@ var (
 func htmlGenerateBody() []string {
 	// Construct the HTML mail body
 	htmlBody := htmlConstructHeader()
+	if catchUpNote != "" {
+		htmlBody = append(htmlBody, fmt.Sprintf("<p>%s</p>", html.EscapeString(catchUpNote)))
+	}
 
 	if len(backupTable) != 0 {
 		htmlBody = append(htmlBody, htmlConstructTableBackupHeader()...)
@@ -53,6 +60,38 @@ func htmlGenerateBody() []string {
 		htmlBody = append(htmlBody, htmlConstructTableEnd()...)
 	}
 
+	if len(pruneTable) != 0 {
+		htmlBody = append(htmlBody, htmlConstructTableOperationHeader(htmlTablePrune)...)
+		for _, entry := range pruneTable {
+			htmlBody = append(htmlBody, htmlContructTableOperationData(htmlTablePrune, entry)...)
+		}
+		htmlBody = append(htmlBody, htmlConstructTableEnd()...)
+	}
+
+	if len(checkTable) != 0 {
+		htmlBody = append(htmlBody, htmlConstructTableOperationHeader(htmlTableCheck)...)
+		for _, entry := range checkTable {
+			htmlBody = append(htmlBody, htmlContructTableOperationData(htmlTableCheck, entry)...)
+		}
+		htmlBody = append(htmlBody, htmlConstructTableEnd()...)
+	}
+
+	htmlBody = append(htmlBody, htmlConstructTrailer()...)
+
+	return htmlBody
+}
+
+// Construct the HTML mail body for a summary of configurations (-all-configs)
+func htmlGenerateSummaryBody(results []configResult) []string {
+	htmlBody := htmlConstructHeader()
+	htmlBody[len(htmlBody)-1] = fmt.Sprintf(`<h1>Results for %d configurations</h1>`, len(results))
+
+	htmlBody = append(htmlBody, htmlConstructTableSummaryHeader()...)
+	for _, result := range results {
+		htmlBody = append(htmlBody, htmlContructTableSummaryData(result)...)
+	}
+	htmlBody = append(htmlBody, htmlConstructTableEnd()...)
+
 	htmlBody = append(htmlBody, htmlConstructTrailer()...)
 
 	return htmlBody
@@ -102,6 +141,8 @@ func htmlConstructTableBackupHeader() []string {
 		`<table>`,
 		`  <tr>`,
 		`    <th style="text-align: left">Storage</th>`,
+		`    <th style="text-align: left">Status</th>`,
+		`    <th>Attempts</th>`,
 		`    <th>Duration</th>`,
 		`    <th>Total Chunks</th>`,
 		`	 <th>Total Used</th>`,
@@ -122,6 +163,8 @@ func htmlContructTableBackupData(data backupRevision) []string {
 	return []string{
 		`  <tr>`,
 		`    <td style="text-align: left">`, data.storage, `</td>`,
+		`    <td style="text-align: left">`, data.status, `</td>`, // Like: "Success"
+		`    <td>`, strconv.Itoa(data.attempts), `</td>`, // Like: "1"
 		`    <td>`, data.duration, `</td>`, // Like: "30:00:00"
 		`    <td>`, data.chunkTotalCount, `</td>`, // Like: "348444"
 		`    <td>`, data.chunkTotalSize, `</td>`, // Like: "1668G"
@@ -148,6 +191,8 @@ func htmlConstructTableCopyHeader() []string {
 		`  <tr>`,
 		`    <th style="text-align: left">From Storage</th>`,
 		`    <th style="text-align: left">To Storage</th>`,
+		`    <th style="text-align: left">Status</th>`,
+		`    <th>Attempts</th>`,
 		`    <th>Duration</th>`,
 		`    <th>Total Chunks</th>`,
 		`	 <th>Chunks Skipped</th>`,
@@ -166,6 +211,8 @@ func htmlContructTableCopyData(data copyRevision) []string {
 		`  <tr>`,
 		`    <td style="text-align: left">`, data.storageFrom, `</td>`,
 		`    <td style="text-align: left">`, data.storageTo, `</td>`,
+		`    <td style="text-align: left">`, data.status, `</td>`,
+		`    <td>`, strconv.Itoa(data.attempts), `</td>`,
 		`    <td>`, data.duration, `</td>`,
 		`    <td>`, data.chunkTotalCount, `</td>`,
 		`    <td>`, data.chunkSkipCount, `</td>`,
@@ -174,6 +221,84 @@ func htmlContructTableCopyData(data copyRevision) []string {
 	}
 }
 
+// Header for tables of operations without statistics (prune and check)
+func htmlConstructTableOperationHeader(context int) []string {
+	// Validate that our table context is correct
+	if htmlTableContext != htmlTableNone {
+		panic(fmt.Sprint("Invalid HTML Table Context: ", htmlTableContext))
+	}
+
+	htmlTableContext = context
+
+	title := "Prune Summary:"
+	if context == htmlTableCheck {
+		title = "Check Summary:"
+	}
+
+	return []string{
+		``,
+		`<h3>` + title + `</h3>`,
+		`<table>`,
+		`  <tr>`,
+		`    <th style="text-align: left">Storage</th>`,
+		`    <th style="text-align: left">Status</th>`,
+		`    <th>Attempts</th>`,
+		`    <th>Duration</th>`,
+		`  </tr>`,
+	}
+}
+
+func htmlContructTableOperationData(context int, data operationRevision) []string {
+	// Validate that our table context is correct
+	if htmlTableContext != context {
+		panic(fmt.Sprint("Invalid HTML Table Context: ", htmlTableContext))
+	}
+
+	return []string{
+		`  <tr>`,
+		`    <td style="text-align: left">`, data.storage, `</td>`,
+		`    <td style="text-align: left">`, data.status, `</td>`,
+		`    <td>`, strconv.Itoa(data.attempts), `</td>`,
+		`    <td>`, data.duration, `</td>`,
+		`  </tr>`,
+	}
+}
+
+func htmlConstructTableSummaryHeader() []string {
+	// Validate that our table context is correct
+	if htmlTableContext != htmlTableNone {
+		panic(fmt.Sprint("Invalid HTML Table Context: ", htmlTableContext))
+	}
+
+	htmlTableContext = htmlTableSummary
+
+	return []string{
+		``,
+		`<h3>Configuration Summary:</h3>`,
+		`<table>`,
+		`  <tr>`,
+		`    <th style="text-align: left">Configuration</th>`,
+		`    <th style="text-align: left">Status</th>`,
+		`    <th>Duration</th>`,
+		`  </tr>`,
+	}
+}
+
+func htmlContructTableSummaryData(data configResult) []string {
+	// Validate that our table context is correct
+	if htmlTableContext != htmlTableSummary {
+		panic(fmt.Sprint("Invalid HTML Table Context: ", htmlTableContext))
+	}
+
+	return []string{
+		`  <tr>`,
+		`    <td style="text-align: left">`, html.EscapeString(data.name), `</td>`,
+		`    <td style="text-align: left">`, data.status(), `</td>`,
+		`    <td>`, data.duration, `</td>`,
+		`  </tr>`,
+	}
+}
+
 func htmlConstructTableEnd() []string {
 	// Validate that our table context is correct
 	if htmlTableContext == htmlTableNone {
@@ -0,0 +1,169 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// A step of a simulation scenario: what the simulated duplicacy does when it is
+// run for an operation (backup, copy, prune, or check) on a storage (for copy,
+// the "to" storage). An empty operation or storage matches anything.
+type simulationStep struct {
+	Operation  string
+	Storage    string
+	Output     []string // Lines of output
+	OutputFile string   // File with lines of output (relative to the scenario file)
+	Delay      string   // Time to wait before exiting (i.e. "5s")
+	ExitCode   int
+	Prompt     string // Password prompt (duplicacy fails since input isn't available)
+}
+
+// Runner that replays a scenario rather than running duplicacy. Each time
+// duplicacy would be run, the first unused step that matches the operation and
+// storage is performed; if no step matches, duplicacy "succeeds" without output.
+type simulatedRunner struct {
+	directory string
+	steps     []simulationStep
+	used      []bool
+	mutex     sync.Mutex
+}
+
+// Load a simulation scenario file
+func newSimulatedRunner(filename string) (*simulatedRunner, error) {
+	v := viper.New()
+	v.SetConfigFile(filename)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	runner := &simulatedRunner{directory: filepath.Dir(filename)}
+	if err := v.UnmarshalKey("steps", &runner.steps); err != nil {
+		return nil, fmt.Errorf("invalid simulation scenario %s: %s", filename, err)
+	}
+	if len(runner.steps) == 0 {
+		return nil, fmt.Errorf("no steps defined in simulation scenario %s", filename)
+	}
+
+	for i, step := range runner.steps {
+		switch step.Operation {
+		case "", "backup", "copy", "prune", "check":
+		default:
+			return nil, fmt.Errorf("invalid operation for simulation step %d: %s", i, step.Operation)
+		}
+		if step.Delay != "" {
+			if _, err := time.ParseDuration(step.Delay); err != nil {
+				return nil, fmt.Errorf("invalid delay for simulation step %d: %s", i, step.Delay)
+			}
+		}
+		if step.OutputFile != "" && !filepath.IsAbs(step.OutputFile) {
+			runner.steps[i].OutputFile = filepath.Join(runner.directory, step.OutputFile)
+		}
+	}
+	runner.used = make([]bool, len(runner.steps))
+
+	return runner, nil
+}
+
+// Find (and use up) the step for the operation
+func (runner *simulatedRunner) nextStep(cmdArgs []string) *simulationStep {
+	operation, storage := "", ""
+	if len(cmdArgs) > 0 {
+		operation = cmdArgs[0]
+	}
+	for i := 0; i+1 < len(cmdArgs); i++ {
+		if cmdArgs[i] == "-storage" || cmdArgs[i] == "-to" {
+			storage = cmdArgs[i+1]
+		}
+	}
+
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+
+	for i, step := range runner.steps {
+		if runner.used[i] || (step.Operation != "" && step.Operation != operation) || (step.Storage != "" && step.Storage != storage) {
+			continue
+		}
+
+		runner.used[i] = true
+		return &runner.steps[i]
+	}
+
+	return nil
+}
+
+func (runner *simulatedRunner) Run(ctx context.Context, cmdArgs []string, dir string, env []string, output func(string)) error {
+	step := runner.nextStep(cmdArgs)
+	if step == nil {
+		return nil
+	}
+
+	for _, line := range step.Output {
+		output(line)
+	}
+
+	if step.OutputFile != "" {
+		file, err := os.Open(step.OutputFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			output(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	if step.Delay != "" {
+		delay, _ := time.ParseDuration(step.Delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// A recognized prompt terminates duplicacy (see executor); otherwise, like
+	// duplicacy, fail when reading the (nonexistent) input
+	exitCode := step.ExitCode
+	if step.Prompt != "" {
+		if prompt := detectPrompt(step.Prompt); prompt != nil {
+			output(step.Prompt)
+			return prompt
+		}
+		output(step.Prompt + "Failed to read the password: EOF")
+		if exitCode == 0 {
+			exitCode = 100
+		}
+	}
+
+	if exitCode != 0 {
+		return fmt.Errorf("exit status %d", exitCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulatedRunner(t *testing.T) {
+	runner, err := newSimulatedRunner("test/assets/scenario.yml")
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	run := func(cmdArgs ...string) ([]string, error) {
+		output := []string{}
+		err := runner.Run(context.Background(), cmdArgs, ".", nil, func(line string) { output = append(output, line) })
+		return output, err
+	}
+
+	// Steps are matched by operation and storage, not order
+	output, err := run("backup", "-storage", "azure", "-stats")
+	var prompt *promptError
+	if !errors.As(err, &prompt) {
+		t.Errorf("Expected prompt error for password prompt, got %v", err)
+	}
+	expected := "Storage set to azure://bucket\nEnter storage password:"
+	if strings.Join(output, "\n") != expected {
+		t.Errorf("Incorrect output, got %q, expected %q", strings.Join(output, "\n"), expected)
+	}
+
+	output, err = run("backup", "-storage", "b2", "-stats")
+	if err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+	if len(output) == 0 || !strings.HasPrefix(output[len(output)-1], "Total running time:") {
+		t.Errorf("Output file not replayed, got %v", output)
+	}
+
+	startTime := time.Now()
+	if _, err = run("copy", "-from", "b2", "-to", "azure"); err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+	if elapsed := time.Since(startTime); elapsed < 50*time.Millisecond {
+		t.Errorf("Delay not simulated (took %s)", elapsed)
+	}
+
+	// Once used, steps aren't used again; duplicacy succeeds without output
+	if output, err = run("backup", "-storage", "b2", "-stats"); err != nil || len(output) != 0 {
+		t.Errorf("Expected no output and nil error, got %v, %v", output, err)
+	}
+}
+
+func TestSimulatedRunner_Cancel(t *testing.T) {
+	runner := &simulatedRunner{steps: []simulationStep{{Delay: "30s"}}, used: []bool{false}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := runner.Run(ctx, []string{"check", "-storage", "b2"}, ".", nil, func(string) {})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded error, got %v", err)
+	}
+}
+
+func TestSimulatedRunner_InvalidScenario(t *testing.T) {
+	if _, err := newSimulatedRunner("test/assets/backupConfigs/numberedKeys.yml"); err == nil {
+		t.Error("Expected error for file without steps, got nil")
+	}
+}
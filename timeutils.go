@@ -19,6 +19,9 @@ import (
 	"time"
 )
 
+// Current time (replaced by unit tests for predictable durations)
+var timeNow = time.Now
+
 // Return difference between two times in a printable format
 func getTimeDiffString(a, b time.Time) (timeDiff string) {
 	year, month, day, hour, min, sec := getTimeDiffNumbers(a, b)
@@ -0,0 +1,184 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Valid names of variables (like "${REPOSITORY_ROOT}")
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Hook commands (like "hooks.preJob" or "hooks.preJob.command") are run by the
+// shell, which expands variables itself (including the DUPLICACY_UTIL_* variables
+// set for hooks), so they're left exactly as written
+var hookCommandLocation = regexp.MustCompile(`(?i)^hooks\.[^.]+(\.command)?$`)
+
+// Value of a variable: built-in variables, or environment variables
+func lookupVariable(name string) (string, bool) {
+	switch name {
+	case "HOSTNAME":
+		host, err := hostname()
+		return host, err == nil
+	case "HOME":
+		home, err := os.UserHomeDir()
+		return home, err == nil
+	case "CONFIG":
+		// Not set without -f (like for the global configuration with -all-configs
+		// or -daemon), so it doesn't silently expand to nothing
+		return cmdConfig, cmdConfig != ""
+	case "DATE":
+		return timeNow().Format("2006-01-02"), true
+	}
+
+	return os.LookupEnv(name)
+}
+
+// Expand variables in a string: "${NAME}" is replaced by the value of the
+// variable, and "${NAME:-default}" by the default if the variable isn't set (or
+// is empty). Defaults may refer to other variables. "$${" is a literal "${".
+func expandVariables(value string, lookup func(string) (string, bool)) (string, error) {
+	var expanded strings.Builder
+	for i := 0; i < len(value); {
+		if strings.HasPrefix(value[i:], "$${") {
+			expanded.WriteString("${")
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(value[i:], "${") {
+			expanded.WriteByte(value[i])
+			i++
+			continue
+		}
+
+		// Find the matching brace (defaults may have references of their own)
+		end, depth := -1, 0
+		for j := i + 2; j < len(value) && end < 0; j++ {
+			switch value[j] {
+			case '{':
+				depth++
+			case '}':
+				if depth == 0 {
+					end = j
+				}
+				depth--
+			}
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference: %s", value[i:])
+		}
+
+		reference := value[i+2 : end]
+		name, defaultValue, hasDefault := reference, "", false
+		if separator := strings.Index(reference, ":-"); separator >= 0 {
+			name, defaultValue, hasDefault = reference[:separator], reference[separator+2:], true
+		}
+		if !variableName.MatchString(name) {
+			return "", fmt.Errorf("invalid variable reference: ${%s}", reference)
+		}
+
+		variable, ok := lookup(name)
+		switch {
+		case ok && (variable != "" || !hasDefault):
+		case hasDefault:
+			var err error
+			if variable, err = expandVariables(defaultValue, lookup); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("undefined variable: %s", name)
+		}
+
+		expanded.WriteString(variable)
+		i = end + 1
+	}
+
+	return expanded.String(), nil
+}
+
+// Expand variables in all values of a configuration file. All problems (like
+// undefined variables) are returned, identified by the setting they're in.
+func expandConfigVariables(v *viper.Viper) []error {
+	keys := []string{}
+	for key := range v.AllSettings() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Set entire top level values (see resolveConfigSecrets)
+	errs := []error{}
+	for _, key := range keys {
+		value, changed, keyErrs := expandVariablesIn(key, v.Get(key))
+		errs = append(errs, keyErrs...)
+		if changed && len(keyErrs) == 0 {
+			v.Set(key, value)
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+
+	return errs
+}
+
+// Expand variables in a configuration value (which may be nested)
+func expandVariablesIn(location string, value interface{}) (interface{}, bool, []error) {
+	changed, errs := false, []error{}
+	expand := func(location string, item interface{}) interface{} {
+		expanded, itemChanged, itemErrs := expandVariablesIn(location, item)
+		changed = changed || itemChanged
+		errs = append(errs, itemErrs...)
+		return expanded
+	}
+
+	switch typed := value.(type) {
+	case string:
+		if hookCommandLocation.MatchString(location) {
+			return typed, false, nil
+		}
+		expanded, err := expandVariables(typed, lookupVariable)
+		if err != nil {
+			return typed, false, []error{fmt.Errorf("%s (in %s)", err, location)}
+		}
+		return expanded, expanded != typed, nil
+
+	case []interface{}:
+		expanded := make([]interface{}, len(typed))
+		for i, item := range typed {
+			expanded[i] = expand(fmt.Sprint(location, ".", i), item)
+		}
+		return expanded, changed, errs
+
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			expanded[key] = expand(location+"."+key, item)
+		}
+		return expanded, changed, errs
+
+	case map[interface{}]interface{}:
+		expanded := make(map[interface{}]interface{}, len(typed))
+		for key, item := range typed {
+			expanded[key] = expand(fmt.Sprint(location, ".", key), item)
+		}
+		return expanded, changed, errs
+	}
+
+	return value, false, nil
+}
@@ -0,0 +1,195 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandVariables(t *testing.T) {
+	variables := map[string]string{"ROOT": "/backups", "EMPTY": "", "HOST": "laptop"}
+	lookup := func(name string) (string, bool) {
+		value, ok := variables[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		value    string
+		expected string
+		err      string
+	}{
+		{"/no/variables", "/no/variables", ""},
+		{"${ROOT}/${HOST}", "/backups/laptop", ""},
+		{"${MISSING:-/default}", "/default", ""},
+		{"${EMPTY:-/default}", "/default", ""},
+		{"${EMPTY}", "", ""},
+		{"${MISSING:-${ROOT}/default}", "/backups/default", ""},
+		{"${ROOT:-${MISSING}}", "/backups", ""},
+		{"$ROOT and $${ROOT}", "$ROOT and ${ROOT}", ""},
+		{"${MISSING}", "", "undefined variable: MISSING"},
+		{"${MISSING:-${ALSO_MISSING}}", "", "undefined variable: ALSO_MISSING"},
+		{"${ROOT", "", "unterminated variable reference: ${ROOT"},
+		{"${NOT-VALID}", "", "invalid variable reference: ${NOT-VALID}"},
+	}
+
+	for _, test := range tests {
+		expanded, err := expandVariables(test.value, lookup)
+		switch {
+		case test.err != "" && (err == nil || err.Error() != test.err):
+			t.Errorf("%s: expected error '%s', got %v", test.value, test.err, err)
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.value, err)
+		case expanded != test.expected:
+			t.Errorf("%s: got '%s', expected '%s'", test.value, expanded, test.expected)
+		}
+	}
+}
+
+func TestLookupVariable_BuiltIn(t *testing.T) {
+	hostname = func() (string, error) { return "laptop", nil }
+	cmdConfig = "quicken"
+	timeNow = func() time.Time { return time.Date(2020, time.January, 31, 23, 0, 0, 0, time.Local) }
+	defer func() {
+		hostname = os.Hostname
+		cmdConfig = ""
+		timeNow = time.Now
+	}()
+
+	home, _ := os.UserHomeDir()
+	expected := map[string]string{
+		"HOSTNAME": "laptop",
+		"HOME":     home,
+		"CONFIG":   "quicken",
+		"DATE":     "2020-01-31",
+	}
+	for name, value := range expected {
+		if actual, ok := lookupVariable(name); !ok || actual != value {
+			t.Errorf("Incorrect value for %s, got '%s', expected '%s'", name, actual, value)
+		}
+	}
+
+	// Without a configuration (-f), CONFIG isn't set
+	cmdConfig = ""
+	if actual, ok := lookupVariable("CONFIG"); ok {
+		t.Errorf("CONFIG should not be set without a configuration, got '%s'", actual)
+	}
+}
+
+func TestConfigVariables(t *testing.T) {
+	quietFlag = true
+	hostname = func() (string, error) { return "laptop", nil }
+	cmdConfig = "variables"
+	os.Setenv("DU_TEST_STORAGE", "b2")
+	defer func() {
+		quietFlag = false
+		hostname = os.Hostname
+		cmdConfig = ""
+		os.Unsetenv("DU_TEST_STORAGE")
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("variables")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if configFile.repoDir != "." {
+		t.Errorf("Incorrect repository, got '%s'", configFile.repoDir)
+	}
+	if backup := configFile.backupInfo[0]; backup.Name != "b2" || backup.Quote != "variables on laptop" {
+		t.Errorf("Incorrect storage, got %v", backup)
+	}
+	if configFile.pruneInfo[0].Storage != "b2" || configFile.checkInfo[0].Storage != "b2" {
+		t.Errorf("Incorrect prune/check storage, got %v and %v", configFile.pruneInfo, configFile.checkInfo)
+	}
+	if command := configFile.hooks["preJob"]["command"]; command != "echo ${NOT_EXPANDED}" {
+		t.Errorf("Incorrect hook command, got '%s'", command)
+	}
+
+	// Hook commands are left for the shell to expand (even undefined variables), but
+	// other hook settings are expanded
+	if hook := configFile.hooks["postJob"]; hook["command"] != "echo ${DUPLICACY_UTIL_STATUS} ${DU_TEST_STORAGE}" || hook["directory"] != "b2" {
+		t.Errorf("Incorrect hook, got %v", hook)
+	}
+	if command, changed, errs := expandVariablesIn("hooks.prejob.command", "echo $${HOME}"); command != "echo $${HOME}" || changed || len(errs) != 0 {
+		t.Errorf("Hook command should be left as written, got '%s'", command)
+	}
+}
+
+func TestConfigVariables_Undefined(t *testing.T) {
+	quietFlag = true
+	defer func() {
+		quietFlag = false
+	}()
+
+	configFile = newConfigurationFile()
+	configFile.setConfig("undefinedVariables")
+	globalStorageDirectory = "test/assets/backupConfigs/"
+	if err := configFile.loadConfig(false, false); err == nil {
+		t.Error("Undefined variables should have been reported")
+	}
+
+	v, err := readConfigLayer("test/assets/backupConfigs/undefinedVariables.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, err := range expandConfigVariables(v) {
+		messages = append(messages, err.Error())
+	}
+	expected := "undefined variable: DU_TEST_UNDEFINED_REPOSITORY (in repository)\n" +
+		"unterminated variable reference: ${DU_TEST_UNDEFINED_QUOTE (in storage.0.quote)"
+	if strings.Join(messages, "\n") != expected {
+		t.Errorf("Incorrect errors, got:\n%s\nexpected:\n%s", strings.Join(messages, "\n"), expected)
+	}
+}
+
+func TestGlobalConfigVariables(t *testing.T) {
+	quietFlag = true
+	runningUnitTests = true
+	cmdConfig = "quicken"
+	directory := os.TempDir()
+	os.Setenv("DU_TEST_DIRECTORY", directory)
+	defer func() {
+		quietFlag = false
+		runningUnitTests = false
+		cmdConfig = ""
+		os.Unsetenv("DU_TEST_DIRECTORY")
+		os.Remove(directory + "/quicken-logs")
+	}()
+
+	if err := loadGlobalConfig(".", "test/assets/globalConfigs/variablesConfig.yml"); err != nil {
+		t.Fatal(err)
+	}
+	if globalLockDir != directory || globalLogDir != directory+"/quicken-logs" {
+		t.Errorf("Incorrect directories, got '%s' and '%s'", globalLockDir, globalLogDir)
+	}
+
+	// Without a configuration (like with -all-configs), ${CONFIG} is undefined
+	cmdConfig = ""
+	if err := loadGlobalConfig(".", "test/assets/globalConfigs/variablesConfig.yml"); err == nil {
+		t.Error("Undefined CONFIG variable should have been reported")
+	}
+
+	cmdConfig = "quicken"
+	os.Unsetenv("DU_TEST_DIRECTORY")
+	if err := loadGlobalConfig(".", "test/assets/globalConfigs/variablesConfig.yml"); err == nil {
+		t.Error("Undefined variables should have been reported")
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/term"
+)
+
+// The secrets vault is a file in the storage directory holding named secrets
+// (referenced from configuration files as "vault:name"), encrypted with AES-256-GCM.
+// The key is derived (PBKDF2) from a passphrase or from a machine key file.
+
+// Environment variable with the passphrase for the vault
+const vaultPassphraseVariable = "DU_SECRETS_PASSPHRASE"
+
+// Sources of the key material for the vault
+const (
+	vaultKeyPassphrase = "passphrase"
+	vaultKeyFile       = "keyfile"
+)
+
+// PBKDF2 iterations for new vaults (existing vaults record their own)
+var vaultIterations = 200000
+
+var (
+	// Decrypted secrets, so the vault is only read once (keyed by vault filename)
+	openedVaults = map[string]map[string]string{}
+	vaultMutex   sync.Mutex
+)
+
+// Contents of the vault file (byte slices are base64 encoded by encoding/json)
+type vaultFile struct {
+	Version    int    `json:"version"`
+	KeySource  string `json:"keySource"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+func vaultFilename() string {
+	return filepath.Join(globalStorageDirectory, "secrets.vault")
+}
+
+func vaultKeyFilename() string {
+	return filepath.Join(globalStorageDirectory, "keys", "vault.key")
+}
+
+// Key for the vault: PBKDF2 with HMAC-SHA256 (RFC 8018)
+func vaultKey(material []byte, salt []byte, iterations int) []byte {
+	return pbkdf2.Key(material, salt, iterations, 32, sha256.New)
+}
+
+// Key material for the vault: the passphrase, or the contents of the machine key
+// file (which is created, if asked, when it doesn't exist)
+func vaultKeyMaterial(keySource string, create bool) ([]byte, error) {
+	if keySource == vaultKeyPassphrase {
+		passphrase := os.Getenv(vaultPassphraseVariable)
+		if passphrase == "" {
+			return nil, fmt.Errorf("secrets vault is encrypted with a passphrase; set %s", vaultPassphraseVariable)
+		}
+		return []byte(passphrase), nil
+	}
+
+	filename := vaultKeyFilename()
+	if _, err := os.Stat(filename); os.IsNotExist(err) && create {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filename, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	// Same permission rules as any other key file
+	material, err := lookupSecret("keyfile:" + filepath.Base(filename))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read vault key file: %s", err)
+	}
+	return []byte(material), nil
+}
+
+// Additional data for encryption, so the header can't be altered
+func (vault *vaultFile) additionalData() []byte {
+	return []byte(fmt.Sprintf("duplicacy-util vault %d %s %d", vault.Version, vault.KeySource, vault.Iterations))
+}
+
+func (vault *vaultFile) cipher(material []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(vaultKey(material, vault.Salt, vault.Iterations))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Read and decrypt the vault. A vault that doesn't exist is empty. Also returns
+// the source of the key for the vault.
+func readVault() (map[string]string, string, error) {
+	secrets := map[string]string{}
+
+	contents, err := ioutil.ReadFile(vaultFilename())
+	if os.IsNotExist(err) {
+		return secrets, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	var vault vaultFile
+	if err := json.Unmarshal(contents, &vault); err != nil {
+		return nil, "", fmt.Errorf("invalid secrets vault %s: %s", vaultFilename(), err)
+	}
+	if vault.Version != 1 || vault.Iterations < 1 || (vault.KeySource != vaultKeyPassphrase && vault.KeySource != vaultKeyFile) {
+		return nil, "", fmt.Errorf("invalid secrets vault %s: unsupported format", vaultFilename())
+	}
+
+	material, err := vaultKeyMaterial(vault.KeySource, false)
+	if err != nil {
+		return nil, "", err
+	}
+	aead, err := vault.cipher(material)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext, err := aead.Open(nil, vault.Nonce, vault.Data, vault.additionalData())
+	if err != nil {
+		return nil, "", errors.New("unable to decrypt secrets vault (wrong passphrase or key file?)")
+	}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, "", fmt.Errorf("invalid secrets vault %s: %s", vaultFilename(), err)
+	}
+
+	return secrets, vault.KeySource, nil
+}
+
+// Encrypt and write the vault. A new vault uses the passphrase (if set), or else
+// the machine key file.
+func writeVault(secrets map[string]string, keySource string) error {
+	if keySource == "" {
+		keySource = vaultKeyFile
+		if os.Getenv(vaultPassphraseVariable) != "" {
+			keySource = vaultKeyPassphrase
+		}
+	}
+
+	vault := vaultFile{Version: 1, KeySource: keySource, Iterations: vaultIterations, Salt: make([]byte, 16)}
+	if _, err := rand.Read(vault.Salt); err != nil {
+		return err
+	}
+
+	material, err := vaultKeyMaterial(keySource, true)
+	if err != nil {
+		return err
+	}
+	aead, err := vault.cipher(material)
+	if err != nil {
+		return err
+	}
+	vault.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(vault.Nonce); err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	vault.Data = aead.Seal(nil, vault.Nonce, plaintext, vault.additionalData())
+
+	contents, err := json.MarshalIndent(vault, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write a new file and rename it, so the vault is never left half written
+	filename := vaultFilename()
+	if err := ioutil.WriteFile(filename+".tmp", append(contents, '\n'), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		os.Remove(filename + ".tmp")
+		return err
+	}
+
+	vaultMutex.Lock()
+	delete(openedVaults, filename)
+	vaultMutex.Unlock()
+
+	return nil
+}
+
+// Look up a secret in the vault (for "vault:name" secret references)
+func vaultSecret(name string) (string, error) {
+	vaultMutex.Lock()
+	defer vaultMutex.Unlock()
+
+	secrets, ok := openedVaults[vaultFilename()]
+	if !ok {
+		var err error
+		if secrets, _, err = readVault(); err != nil {
+			return "", err
+		}
+		openedVaults[vaultFilename()] = secrets
+	}
+
+	value, ok := secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s is not in the secrets vault", name)
+	}
+	return value, nil
+}
+
+// Read the value of a secret: from the terminal (without echoing it), or the first
+// line of input otherwise
+func readSecretValue(input io.Reader, name string) (string, error) {
+	if file, ok := input.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		fmt.Fprintf(os.Stderr, "Value for secret %s: ", name)
+		value, err := term.ReadPassword(int(file.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(value), err
+	}
+
+	value, err := bufio.NewReader(input).ReadString('\n')
+	if err == io.EOF {
+		err = nil
+	}
+	return strings.TrimRight(value, "\r\n"), err
+}
+
+// Handle the "secrets" subcommand (set, get, list, or rm), returning the exit code.
+// Values to set are read from input (so they don't show up in process listings).
+func performSecretsCommand(args []string, input io.Reader, output io.Writer) int {
+	usage := func() int {
+		logError(nil, "Usage: duplicacy-util [-sd directory] secrets set|get|rm <name> | secrets list")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	command, name := args[0], ""
+	switch {
+	case command == "list" && len(args) == 1:
+	case (command == "set" || command == "get" || command == "rm") && len(args) == 2 && args[1] != "":
+		name = args[1]
+	default:
+		return usage()
+	}
+
+	secrets, keySource, err := readVault()
+	if err != nil {
+		logError(nil, fmt.Sprint("Error: ", err))
+		return 1
+	}
+
+	switch command {
+	case "list":
+		names := []string{}
+		for name := range secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(output, name)
+		}
+		return 0
+
+	case "get":
+		value, ok := secrets[name]
+		if !ok {
+			logError(nil, fmt.Sprint("Error: secret not found: ", name))
+			return 1
+		}
+		fmt.Fprintln(output, value)
+		return 0
+
+	case "set":
+		value, err := readSecretValue(input, name)
+		if err != nil {
+			logError(nil, fmt.Sprint("Error: ", err))
+			return 1
+		}
+		if value == "" {
+			logError(nil, "Error: no value for secret (provide it on standard input)")
+			return 1
+		}
+		secrets[name] = value
+
+	case "rm":
+		if _, ok := secrets[name]; !ok {
+			logError(nil, fmt.Sprint("Error: secret not found: ", name))
+			return 1
+		}
+		delete(secrets, name)
+	}
+
+	if err := writeVault(secrets, keySource); err != nil {
+		logError(nil, fmt.Sprint("Error: ", err))
+		return 1
+	}
+	return 0
+}
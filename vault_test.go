@@ -0,0 +1,170 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Use a temporary storage directory (and fewer iterations, to keep tests fast)
+func setupVault(t *testing.T) func() {
+	savedStorageDirectory, savedIterations := globalStorageDirectory, vaultIterations
+	directory, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatalf("Error creating storage directory: %s", err)
+	}
+	globalStorageDirectory, vaultIterations = directory, 1000
+	quietFlag = true
+
+	return func() {
+		os.RemoveAll(directory)
+		globalStorageDirectory, vaultIterations = savedStorageDirectory, savedIterations
+		quietFlag = false
+		os.Unsetenv(vaultPassphraseVariable)
+	}
+}
+
+func secretsCommand(input string, args ...string) (int, string) {
+	var output bytes.Buffer
+	status := performSecretsCommand(args, strings.NewReader(input), &output)
+	return status, output.String()
+}
+
+func TestVaultKey(t *testing.T) {
+	// Existing vaults must still be readable, so check the key derivation (PBKDF2
+	// with HMAC-SHA256). The first vector is from RFC 7914 (section 11); the others
+	// use the inputs from RFC 6070 (which only lists HMAC-SHA1 results).
+	tests := []struct {
+		password   string
+		salt       string
+		iterations int
+		expected   string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096,
+			"348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1"},
+	}
+
+	for _, test := range tests {
+		key := hex.EncodeToString(vaultKey([]byte(test.password), []byte(test.salt), test.iterations))
+		if key != test.expected {
+			t.Errorf("Incorrect key for %q (%d iterations), got %s, expected %s",
+				test.password, test.iterations, key, test.expected)
+		}
+	}
+}
+
+func TestSecretsCommand(t *testing.T) {
+	defer setupVault(t)()
+
+	if status, output := secretsCommand("", "list"); status != 0 || output != "" {
+		t.Errorf("Expected empty list for missing vault, got %d: %q", status, output)
+	}
+
+	if status, _ := secretsCommand("hunter2\n", "set", "smtp"); status != 0 {
+		t.Errorf("Expected status 0 setting secret, got %d", status)
+	}
+	if status, _ := secretsCommand("b2-password", "set", "b2"); status != 0 {
+		t.Errorf("Expected status 0 setting secret, got %d", status)
+	}
+	if status, output := secretsCommand("", "list"); status != 0 || output != "b2\nsmtp\n" {
+		t.Errorf("Incorrect list, got %d: %q", status, output)
+	}
+	if status, output := secretsCommand("", "get", "smtp"); status != 0 || output != "hunter2\n" {
+		t.Errorf("Incorrect secret, got %d: %q", status, output)
+	}
+
+	// The vault (and the machine key file) are private, and the secret isn't in plaintext
+	contents, err := ioutil.ReadFile(vaultFilename())
+	if err != nil {
+		t.Fatalf("Error reading vault: %s", err)
+	}
+	if strings.Contains(string(contents), "hunter2") {
+		t.Errorf("Secret stored in plaintext in vault")
+	}
+	if runtime.GOOS != "windows" {
+		for _, filename := range []string{vaultFilename(), vaultKeyFilename()} {
+			if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("Incorrect permissions for %s: %v", filename, info.Mode())
+			}
+		}
+	}
+
+	// Secrets are referenced with "vault:name"
+	if value, err := resolveSecret("vault:b2"); err != nil || value != "b2-password" {
+		t.Errorf("Incorrect secret from vault, got %q (%v)", value, err)
+	}
+
+	if status, _ := secretsCommand("", "rm", "smtp"); status != 0 {
+		t.Errorf("Expected status 0 removing secret, got %d", status)
+	}
+	if status, _ := secretsCommand("", "get", "smtp"); status != 1 {
+		t.Errorf("Expected status 1 for removed secret, got %d", status)
+	}
+	if _, err := resolveSecret("vault:smtp"); err == nil {
+		t.Errorf("Expected error for removed secret")
+	}
+
+	for _, args := range [][]string{{}, {"set"}, {"list", "extra"}, {"dump"}} {
+		if status, _ := secretsCommand("", args...); status != 2 {
+			t.Errorf("Expected usage error for %v, got %d", args, status)
+		}
+	}
+	if status, _ := secretsCommand("", "set", "empty"); status != 1 {
+		t.Errorf("Expected error setting empty secret, got %d", status)
+	}
+}
+
+func TestSecretsCommand_Passphrase(t *testing.T) {
+	defer setupVault(t)()
+
+	os.Setenv(vaultPassphraseVariable, "correct horse battery staple")
+	if status, _ := secretsCommand("hunter2", "set", "smtp"); status != 0 {
+		t.Errorf("Expected status 0 setting secret, got %d", status)
+	}
+	if _, err := os.Stat(vaultKeyFilename()); !os.IsNotExist(err) {
+		t.Errorf("Machine key file should not be created with a passphrase")
+	}
+	if status, output := secretsCommand("", "get", "smtp"); status != 0 || output != "hunter2\n" {
+		t.Errorf("Incorrect secret, got %d: %q", status, output)
+	}
+
+	os.Setenv(vaultPassphraseVariable, "wrong passphrase")
+	if _, _, err := readVault(); err == nil || !strings.Contains(err.Error(), "unable to decrypt") {
+		t.Errorf("Expected decryption error, got %v", err)
+	}
+
+	os.Unsetenv(vaultPassphraseVariable)
+	if _, _, err := readVault(); err == nil || !strings.Contains(err.Error(), vaultPassphraseVariable) {
+		t.Errorf("Expected missing passphrase error, got %v", err)
+	}
+
+	// A tampered vault can't be decrypted
+	os.Setenv(vaultPassphraseVariable, "correct horse battery staple")
+	contents, _ := ioutil.ReadFile(vaultFilename())
+	tampered := strings.Replace(string(contents), `"iterations": 1000`, `"iterations": 1001`, 1)
+	ioutil.WriteFile(filepath.Join(globalStorageDirectory, "secrets.vault"), []byte(tampered), 0600)
+	if _, _, err := readVault(); err == nil {
+		t.Errorf("Expected error for tampered vault")
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned when a step is outside its maintenance window, so the remaining
+// operations are deferred (to be resumed from the checkpoint by a later run)
+var errDeferred = errors.New("outside maintenance window; remaining operations deferred")
+
+// Maintenance window (times when operations may be performed), like
+// "mon-fri 18:00-07:00; sat,sun". Each rule (separated by semicolons) has days of
+// the week (names or numbers, in cron syntax) and/or a time range; either defaults
+// to all (every day, or the whole day). A time range ending before it starts runs
+// past midnight, into the following day. Times are local unless the window starts
+// with a time zone, like "TZ=Europe/Berlin mon-fri 20:00-06:00".
+type maintenanceWindow struct {
+	expression string
+	location   *time.Location
+	rules      []windowRule
+}
+
+type windowRule struct {
+	weekdays uint64
+	start    int // Minutes after midnight
+	end      int
+}
+
+func parseWindow(expression string) (*maintenanceWindow, error) {
+	location, text, err := parseTimeZone(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	window := &maintenanceWindow{expression: expression, location: location}
+	for _, ruleText := range strings.Split(text, ";") {
+		rule, err := parseWindowRule(strings.Fields(ruleText))
+		if err != nil {
+			return nil, err
+		}
+		window.rules = append(window.rules, rule)
+	}
+
+	return window, nil
+}
+
+// Split the time zone (like "TZ=Europe/Berlin") from the start of an expression,
+// returning the location (local time if there is no time zone) and the remainder
+func parseTimeZone(expression string) (*time.Location, string, error) {
+	text := strings.TrimSpace(expression)
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(strings.ToUpper(fields[0]), "TZ=") {
+		return time.Local, text, nil
+	}
+
+	location, err := time.LoadLocation(fields[0][3:])
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown time zone %s", fields[0][3:])
+	}
+	return location, strings.TrimSpace(text[len(fields[0]):]), nil
+}
+
+// Parse a rule of a maintenance window: days, a time range, or both
+func parseWindowRule(fields []string) (windowRule, error) {
+	rule := windowRule{weekdays: 0x7f, start: 0, end: 24 * 60}
+	if len(fields) == 0 || len(fields) > 2 {
+		return rule, errors.New("each rule must have days and/or a time range (like mon-fri 18:00-07:00)")
+	}
+
+	for i, field := range fields {
+		if !strings.Contains(field, ":") {
+			if i != 0 {
+				return rule, fmt.Errorf("invalid time range %s", field)
+			}
+			weekdays, err := parseCronField(field, 0, 7, cronWeekdayNames)
+			if err != nil {
+				return rule, fmt.Errorf("days: %s", err)
+			}
+			// Sunday may be 0 or 7
+			if weekdays&(1<<7) != 0 {
+				weekdays |= 1
+			}
+			rule.weekdays = weekdays & 0x7f
+			continue
+		}
+
+		bounds := strings.Split(field, "-")
+		if len(bounds) != 2 {
+			return rule, fmt.Errorf("invalid time range %s", field)
+		}
+		var err error
+		if rule.start, err = parseWindowTime(bounds[0]); err != nil || rule.start == 24*60 {
+			return rule, fmt.Errorf("invalid time %s", bounds[0])
+		}
+		if rule.end, err = parseWindowTime(bounds[1]); err != nil {
+			return rule, fmt.Errorf("invalid time %s", bounds[1])
+		}
+		if rule.start == rule.end {
+			return rule, fmt.Errorf("empty time range %s", field)
+		}
+	}
+
+	return rule, nil
+}
+
+// Parse a time of day (like 07:30 or 24:00) as minutes after midnight
+func parseWindowTime(text string) (int, error) {
+	parts := strings.Split(text, ":")
+	if len(parts) != 2 || len(parts[1]) != 2 {
+		return 0, errors.New("invalid time")
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, err
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, errors.New("invalid time")
+	}
+
+	return hour*60 + minute, nil
+}
+
+// Is the specified time within the maintenance window?
+func (window *maintenanceWindow) contains(t time.Time) bool {
+	t = t.In(window.location)
+	for _, rule := range window.rules {
+		if rule.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Does the rule include the specified time (in the location of the rule)?
+func (rule windowRule) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := uint(t.Weekday())
+	yesterday := (today + 6) % 7
+
+	if rule.start < rule.end {
+		return rule.weekdays&(1<<today) != 0 && minute >= rule.start && minute < rule.end
+	}
+
+	// Time range runs past midnight
+	return (rule.weekdays&(1<<today) != 0 && minute >= rule.start) || (rule.weekdays&(1<<yesterday) != 0 && minute < rule.end)
+}
+
+// Next time (after the specified time) that the maintenance window opens, or the
+// zero time if it never does
+func (window *maintenanceWindow) nextOpen(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(0, 0, 8); t.Before(limit); t = t.Add(time.Minute) {
+		if window.contains(t) {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (window *maintenanceWindow) String() string {
+	return window.expression
+}
+
+// Maintenance window for an operation: the setting for the operation overrides the
+// setting for the repository
+func operationWindow(info operationInfo) *maintenanceWindow {
+	if window := info.settings().Window; window != nil {
+		return window
+	}
+
+	return configFile.window
+}
+
+// Reason an operation must be deferred (because it's outside its maintenance
+// window), or an empty string if it may be performed now
+func deferralReason(info operationInfo) string {
+	window := operationWindow(info)
+	now := timeNow()
+	if window == nil || window.contains(now) {
+		return ""
+	}
+
+	reason := fmt.Sprintf("outside maintenance window %s", window)
+	if next := window.nextOpen(now); !next.IsZero() {
+		reason += fmt.Sprint(" (next opens ", next.In(time.Local).Format("01-02-2006 15:04"), ")")
+	}
+	return reason
+}
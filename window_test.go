@@ -0,0 +1,213 @@
+// Copyright © 2018 Jeff Coffler <jeff@taltos.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	valid := []string{
+		"mon-fri 18:00-07:00",
+		"mon-fri 18:00-07:00; sat,sun",
+		"22:00-24:00",
+		"TZ=UTC sun 00:00-06:30",
+		"1-5",
+	}
+	for _, expression := range valid {
+		if _, err := parseWindow(expression); err != nil {
+			t.Errorf("%q: unexpected error %s", expression, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"mon-fri;",
+		"funday 18:00-07:00",
+		"mon-fri 18:00",
+		"mon-fri 18:00-25:00",
+		"mon-fri 24:00-06:00",
+		"mon-fri 8:5-09:00",
+		"mon-fri 09:00-09:00",
+		"18:00-07:00 mon-fri",
+		"mon-fri 18:00-07:00 extra",
+		"TZ=No/Such/Zone mon-fri",
+	}
+	for _, expression := range invalid {
+		if _, err := parseWindow(expression); err == nil {
+			t.Errorf("%q: expected an error", expression)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	window, err := parseWindow("TZ=UTC mon-fri 18:00-07:00; sat,sun")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// July 17, 2018 was a Tuesday
+	tests := []struct {
+		time     time.Time
+		expected bool
+	}{
+		{time.Date(2018, 7, 17, 17, 59, 0, 0, time.UTC), false},
+		{time.Date(2018, 7, 17, 18, 0, 0, 0, time.UTC), true},
+		{time.Date(2018, 7, 18, 6, 59, 0, 0, time.UTC), true},
+		{time.Date(2018, 7, 18, 7, 0, 0, 0, time.UTC), false},
+		{time.Date(2018, 7, 21, 12, 0, 0, 0, time.UTC), true},
+		{time.Date(2018, 7, 23, 3, 0, 0, 0, time.UTC), false},
+		{time.Date(2018, 7, 21, 3, 0, 0, 0, time.UTC), true},
+	}
+	for _, test := range tests {
+		if actual := window.contains(test.time); actual != test.expected {
+			t.Errorf("%s: got %t, expected %t", test.time.Format(time.RFC1123), actual, test.expected)
+		}
+	}
+
+	if next := window.nextOpen(fixedTimeNow()); !next.Equal(time.Date(2018, 7, 17, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("next opening was incorrect, got %s", next)
+	}
+}
+
+func TestWindowTimeZone(t *testing.T) {
+	if _, err := time.LoadLocation("America/New_York"); err != nil {
+		t.Skip("time zone database is not available")
+	}
+
+	// 17:58 UTC is 13:58 in New York (daylight saving time)
+	for expression, expected := range map[string]bool{
+		"TZ=America/New_York 09:00-17:00": true,
+		"TZ=UTC 09:00-17:00":              false,
+	} {
+		window, err := parseWindow(expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := window.contains(fixedTimeNow()); actual != expected {
+			t.Errorf("%q: got %t, expected %t", expression, actual, expected)
+		}
+	}
+}
+
+func TestRunDuplicacyBackup_Deferred(t *testing.T) {
+	logger, file, err := setupLogging()
+	if err != nil {
+		t.Errorf("unexpected error creating log file, got %#v", err)
+	}
+	quietFlag = true
+	globalLockDir = os.TempDir()
+	cmdConfig = "window-" + randomStringBytes(6)
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+		removeCheckpoint()
+		quietFlag = false
+	}()
+
+	// The repository window is open, but the second storage has a window of its own
+	// that is closed, so the backup stops after the first storage
+	open, _ := parseWindow("TZ=UTC 08:00-20:00")
+	closed, _ := parseWindow("TZ=UTC mon-fri 20:00-06:00")
+	configFile.window = open
+	configFile.backupInfo = []backupConfig{
+		{Name: "gcd"},
+		{Name: "azure-direct", operationConfig: operationConfig{Window: closed}},
+	}
+	backupTable = nil
+	mailBody = nil
+	defer func() {
+		configFile.window = nil
+		backupTable = nil
+	}()
+
+	runner := assetRunner("taltos.log", "backup", len(configFile.backupInfo))
+	duplicacyRunner = runner
+	timeNow = fixedTimeNow
+	defer func() {
+		duplicacyRunner = execRunner{}
+		timeNow = time.Now
+	}()
+
+	prepareCheckpoint(logger)
+	err = performDuplicacyBackup(context.Background(), logger)
+	if !errors.Is(err, errDeferred) {
+		t.Errorf("expected deferred error, got %v", err)
+	}
+	if len(backupTable) != 2 || backupTable[0].status != statusSuccess || backupTable[1].status != statusDeferred {
+		t.Errorf("backup table was incorrect, got %+v", backupTable)
+	}
+	if runner.used[1] {
+		t.Error("deferred backup should not have been performed")
+	}
+	if !strings.Contains(strings.Join(mailBody, "\n"), "Deferring backup to storage azure-direct: outside maintenance window") {
+		t.Errorf("deferral was not logged, got %v", mailBody)
+	}
+
+	// The completed backup is recorded, so a later run resumes with the second storage
+	if operation, iteration := readCheckpoint(); operation != checkpointBackup || iteration != 1 {
+		t.Errorf("checkpoint was incorrect, got %s #%d", checkpointName(operation), iteration)
+	}
+}
+
+// Notifier that counts deferral notifications
+type deferNotifier struct {
+	count *int
+}
+
+func (deferNotifier) NotifyOfStart() error   { return nil }
+func (deferNotifier) NotifyOfSkip() error    { return nil }
+func (deferNotifier) NotifyOfSuccess() error { return nil }
+func (deferNotifier) NotifyOfFailure() error { return nil }
+
+func (notifier deferNotifier) NotifyOfDefer() error {
+	*notifier.count++
+	return nil
+}
+
+func (deferNotifier) NotifyOfSummary([]configResult, bool) error { return nil }
+
+func TestNotifyOfDefer_Fallback(t *testing.T) {
+	savedDefer, savedFailure, savedSkip := onDeferNotifiers, onFailureNotifiers, onSkipNotifiers
+	defer func() {
+		onDeferNotifiers, onFailureNotifiers, onSkipNotifiers = savedDefer, savedFailure, savedSkip
+	}()
+
+	var deferCount, failureCount, skipCount int
+	tests := []struct {
+		onDefer, onFailure, onSkip []Notifier
+		expected                   [3]int
+	}{
+		{[]Notifier{deferNotifier{&deferCount}}, []Notifier{deferNotifier{&failureCount}}, nil, [3]int{1, 0, 0}},
+		{nil, []Notifier{deferNotifier{&failureCount}}, []Notifier{deferNotifier{&skipCount}}, [3]int{0, 1, 0}},
+		{nil, nil, []Notifier{deferNotifier{&skipCount}}, [3]int{0, 0, 1}},
+	}
+
+	for i, test := range tests {
+		deferCount, failureCount, skipCount = 0, 0, 0
+		onDeferNotifiers, onFailureNotifiers, onSkipNotifiers = test.onDefer, test.onFailure, test.onSkip
+		if err := notifyOfDefer(); err != nil {
+			t.Errorf("test %d: unexpected error %v", i, err)
+		}
+		if actual := [3]int{deferCount, failureCount, skipCount}; actual != test.expected {
+			t.Errorf("test %d: notifications (onDefer, onFailure, onSkip) were %v, expected %v", i, actual, test.expected)
+		}
+	}
+}